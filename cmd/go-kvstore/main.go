@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "net/http/pprof"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sprectza/go-kvstore/internal/aof"
 	"github.com/sprectza/go-kvstore/internal/kvstore"
 	"github.com/sprectza/go-kvstore/internal/queue"
 	kvstoreAPI "github.com/sprectza/go-kvstore/pkg/api"
@@ -18,11 +26,62 @@ import (
 ) */
 
 func main() {
+	respAddr := flag.String("resp-addr", ":6379", "address of the RESP (Redis protocol) listener, empty to disable")
+	aofPath := flag.String("aof", "", "path of the append-only file, empty to disable persistence")
+	aofFsync := flag.String("aof-fsync", "everysec", "how often the append-only file is synced: always, everysec or never")
+	snapshotDir := flag.String("snapshot-dir", "", "directory for snapshot files, empty to disable snapshots")
+	snapshotKeep := flag.Int("snapshot-keep", 3, "number of snapshot files to keep, 0 to keep all of them")
+	saveInterval := flag.Duration("save-interval", 0, "how often a background snapshot is taken, 0 to only save on demand")
+	shards := flag.Int("shards", kvstore.DefaultShards, "number of shards the keys are spread over")
+	expireHz := flag.Int("expire-hz", 10, "active expiry cycles per second")
+	expireBudget := flag.Float64("expire-cpu-budget", 0.25, "fraction of a CPU the active expiry cycles may use")
+	flag.Parse()
+
+	fsyncPolicy, err := aof.ParseFsyncPolicy(*aofFsync)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	os.Setenv("GOGC", "200")
 
-	kvs := kvstore.NewKVStore()
+	store := kvstore.NewShardedKVStore(*shards)
 	qs := queue.NewQueue()
-	service := kvstoreAPI.NewService(kvs, qs)
+	opts := []kvstoreAPI.Option{kvstoreAPI.WithActiveExpiry(*expireHz, *expireBudget)}
+	if *snapshotDir != "" {
+		opts = append(opts, kvstoreAPI.WithSnapshots(*snapshotDir, *snapshotKeep))
+	}
+	service := kvstoreAPI.NewService(store, qs, opts...)
+	prometheus.MustRegister(kvstoreAPI.NewShardCollector(store))
+	prometheus.MustRegister(kvstoreAPI.NewQueueCollector(qs))
+
+	// The append-only file holds every write since it was created, so when
+	// it is enabled it is the only thing loaded and snapshots are ignored
+	if *aofPath == "" && *snapshotDir != "" {
+		path, err := kvstoreAPI.LoadSnapshot(*snapshotDir, service)
+		if err != nil {
+			log.Fatalf("Loading snapshot from %s: %v", *snapshotDir, err)
+		}
+		if path != "" {
+			log.Printf("Loaded snapshot %s", path)
+		}
+	}
+
+	// Replay the append-only file before any listener opens, then log every
+	// write from here on
+	var appendLog *aof.AOF
+	if *aofPath != "" {
+		n, err := kvstoreAPI.ReplayAOF(*aofPath, service)
+		if err != nil {
+			log.Fatalf("Replaying %s: %v", *aofPath, err)
+		}
+		log.Printf("Replayed %d commands from %s", n, *aofPath)
+
+		appendLog, err = aof.Open(*aofPath, fsyncPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		service = kvstoreAPI.NewAOFMiddleware(appendLog, service)
+	}
 
 	// Instantiate the logger and wrap the service with the logging middleware
 	// logger := kitlog.NewLogfmtLogger(os.Stderr)
@@ -35,11 +94,58 @@ func main() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
 	}()
 
+	respServer := kvstoreAPI.NewRESPServer(service)
+	if *respAddr != "" {
+		go func() {
+			log.Printf("Starting RESP server on %s", *respAddr)
+			if err := respServer.ListenAndServe(*respAddr); err != net.ErrClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *snapshotDir != "" && *saveInterval > 0 {
+		go func() {
+			for range time.Tick(*saveInterval) {
+				if err := service.BGSave(); err != nil {
+					log.Printf("Scheduled background save: %v", err)
+				}
+			}
+		}()
+	}
+
+	// Cancelled on shutdown, so that requests blocked on an empty queue do
+	// not hold it up
+	ctx, cancel := context.WithCancel(context.Background())
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: handler,
+		Addr:        ":8080",
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		respServer.Close()
+		cancel()
+		server.Shutdown(context.Background())
+	}()
+
 	log.Println("Starting server on :8080")
-	log.Fatal(server.ListenAndServe())
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	if *snapshotDir != "" {
+		if err := service.Save(); err != nil {
+			log.Printf("Saving snapshot on shutdown: %v", err)
+		}
+	}
+
+	if appendLog != nil {
+		if err := appendLog.Close(); err != nil {
+			log.Printf("Closing %s: %v", *aofPath, err)
+		}
+	}
 }
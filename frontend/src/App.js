@@ -25,9 +25,10 @@ const App = () => {
         setCountdown(expirationTime);
       }
     } catch (error) {
+      const data = error.response.data;
       setResponse({
         status: error.response.status,
-        message: error.response.data,
+        message: data.error ? `${data.error.code}: ${data.error.message}` : data,
       });
     }
 
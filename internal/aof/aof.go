@@ -0,0 +1,229 @@
+package aof
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/resp"
+)
+
+var (
+	ErrInvalidFsyncPolicy = errors.New("fsync policy must be always, everysec or never")
+	ErrClosed             = errors.New("append-only file is closed")
+)
+
+// FsyncPolicy controls how often the append-only file is flushed to disk
+type FsyncPolicy int
+
+const (
+	// FsyncAlways syncs after every appended command
+	FsyncAlways FsyncPolicy = iota
+	// FsyncEverySec syncs once per second from a background goroutine
+	FsyncEverySec
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever
+)
+
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch s {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "never":
+		return FsyncNever, nil
+	}
+
+	return 0, ErrInvalidFsyncPolicy
+}
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncEverySec:
+		return "everysec"
+	case FsyncNever:
+		return "never"
+	}
+
+	return fmt.Sprintf("FsyncPolicy(%d)", int(p))
+}
+
+// AOF is an append-only log of commands. Every command is stored as a RESP
+// array of bulk strings, the same encoding Redis uses for its AOF.
+type AOF struct {
+	mu     sync.Mutex
+	f      *os.File
+	buf    bytes.Buffer
+	w      *resp.Writer
+	policy FsyncPolicy
+	dirty  bool
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Open opens the append-only file at path for appending, creating it if
+// needed.
+func Open(path string, policy FsyncPolicy) (*AOF, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &AOF{
+		f:      f,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	a.w = resp.NewWriter(&a.buf)
+
+	if policy == FsyncEverySec {
+		a.wg.Add(1)
+		go a.syncEverySecond()
+	}
+
+	return a, nil
+}
+
+// Append records a command. The command is handed to the operating system
+// before Append returns, and synced to disk as well under FsyncAlways.
+func (a *AOF) Append(args ...string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+
+	a.buf.Reset()
+	a.w.WriteCommand(args...)
+	a.w.Flush()
+
+	if _, err := a.f.Write(a.buf.Bytes()); err != nil {
+		return err
+	}
+
+	if a.policy == FsyncAlways {
+		return a.f.Sync()
+	}
+	a.dirty = true
+
+	return nil
+}
+
+// Sync flushes everything appended so far to disk
+func (a *AOF) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.sync()
+}
+
+func (a *AOF) sync() error {
+	if a.closed {
+		return ErrClosed
+	}
+	if !a.dirty {
+		return nil
+	}
+
+	a.dirty = false
+	return a.f.Sync()
+}
+
+func (a *AOF) syncEverySecond() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Sync(); err != nil && err != ErrClosed {
+				log.Printf("aof: background fsync failed: %v", err)
+			}
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// Close syncs and closes the file
+func (a *AOF) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return ErrClosed
+	}
+	err := a.f.Sync()
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	a.closed = true
+	close(a.done)
+	a.mu.Unlock()
+
+	a.wg.Wait()
+	return err
+}
+
+// Replay calls fn for every command recorded in the file at path, in order,
+// and returns how many commands were replayed. A missing file replays
+// nothing. If the last command was only partially written, for instance
+// because of a crash, it is discarded and the file is truncated so that new
+// commands are appended after the last complete one.
+func Replay(path string, fn func(args []string) error) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	cr := &countingReader{r: f}
+	r := resp.NewReader(cr)
+
+	n := 0
+	for {
+		offset := cr.n - int64(r.Buffered())
+
+		args, err := r.ReadCommand()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("aof: discarding truncated command at offset %d of %s", offset, path)
+			return n, f.Truncate(offset)
+		}
+		if err != nil {
+			return n, fmt.Errorf("aof: reading command at offset %d: %w", offset, err)
+		}
+
+		if err := fn(args); err != nil {
+			return n, fmt.Errorf("aof: replaying command at offset %d: %w", offset, err)
+		}
+		n++
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,69 @@
+package aof
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func replayAll(t *testing.T, path string) [][]string {
+	var got [][]string
+	_, err := Replay(path, func(args []string) error {
+		got = append(got, args)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return got
+}
+
+func TestAppendAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	for _, policy := range []FsyncPolicy{FsyncAlways, FsyncEverySec, FsyncNever} {
+		os.Remove(path)
+
+		a, err := Open(path, policy)
+		require.NoError(t, err)
+		require.NoError(t, a.Append("SET", "key1", "value with spaces\r\n", "NX"))
+		require.NoError(t, a.Append("QPUSH", "queue1", "a", ""))
+		require.NoError(t, a.Close())
+
+		assert.Equal(t, [][]string{
+			{"SET", "key1", "value with spaces\r\n", "NX"},
+			{"QPUSH", "queue1", "a", ""},
+		}, replayAll(t, path), policy.String())
+	}
+}
+
+func TestReplayTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	a, err := Open(path, FsyncAlways)
+	require.NoError(t, err)
+	require.NoError(t, a.Append("QPOP", "queue1"))
+	require.NoError(t, a.Close())
+
+	complete, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// Simulate a crash in the middle of writing the second command
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("*2\r\n$4\r\nQPOP\r\n$6\r\nque")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, [][]string{{"QPOP", "queue1"}}, replayAll(t, path))
+
+	truncated, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, complete, truncated)
+}
+
+func TestReplayMissingFile(t *testing.T) {
+	assert.Empty(t, replayAll(t, filepath.Join(t.TempDir(), "missing.aof")))
+}
@@ -0,0 +1,93 @@
+package kvstore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+	ErrNotFloat   = errors.New("value is not a valid float")
+	ErrOverflow   = errors.New("increment or decrement would overflow")
+	ErrNotFinite  = errors.New("increment would produce NaN or Infinity")
+)
+
+// IncrBy adds delta to the integer stored under key and returns the result.
+// A key that does not exist counts as 0 and is created with expiresAt, which
+// may be zero for no expiry; existing keys keep their expiry. Counters are
+// stored as decimal strings, so GET and SET see them as any other value.
+func (kvs *KVStore) IncrBy(key string, delta int64, expiresAt time.Time) (int64, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	kvs.expireIfNeeded(key)
+	keyValue, exists := kvs.store[key]
+	if !exists {
+		keyValue = KeyValue{Value: "0", ExpiresAt: expiresAt}
+	}
+
+	if !isPlain(keyValue.Value) {
+		return 0, ErrWrongType
+	}
+	s, ok := keyValue.Value.(string)
+	if !ok {
+		return 0, ErrNotInteger
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	n += delta
+
+	keyValue.Value = strconv.FormatInt(n, 10)
+	kvs.put(key, keyValue)
+
+	return n, nil
+}
+
+// IncrByFloat is IncrBy for floating point numbers. Results that are not
+// finite are refused.
+func (kvs *KVStore) IncrByFloat(key string, delta float64, expiresAt time.Time) (float64, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	kvs.expireIfNeeded(key)
+	keyValue, exists := kvs.store[key]
+	if !exists {
+		keyValue = KeyValue{Value: "0", ExpiresAt: expiresAt}
+	}
+
+	if !isPlain(keyValue.Value) {
+		return 0, ErrWrongType
+	}
+	s, ok := keyValue.Value.(string)
+	if !ok {
+		return 0, ErrNotFloat
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrNotFloat
+	}
+
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrNotFinite
+	}
+
+	keyValue.Value = FormatFloat(f)
+	kvs.put(key, keyValue)
+
+	return f, nil
+}
+
+// FormatFloat formats f the way IncrByFloat stores it, in the shortest form
+// that parses back to f and without an exponent
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
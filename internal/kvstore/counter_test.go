@@ -0,0 +1,90 @@
+package kvstore
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncrBy(t *testing.T) {
+	kvs := NewKVStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			kvs.IncrBy("hits", 1, time.Time{})
+		}()
+	}
+	wg.Wait()
+
+	value, _ := kvs.Get("hits")
+	assert.Equal(t, "100", value)
+
+	n, err := kvs.IncrBy("hits", -150, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-50), n)
+
+	mustSet(t, kvs, "max", "9223372036854775807", time.Time{}, "", false)
+	_, err = kvs.IncrBy("max", 1, time.Time{})
+	assert.Equal(t, ErrOverflow, err)
+	_, err = kvs.IncrBy("max", math.MinInt64, time.Time{})
+	assert.NoError(t, err)
+
+	mustSet(t, kvs, "name", "bob", time.Time{}, "", false)
+	_, err = kvs.IncrBy("name", 1, time.Time{})
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestIncrByExpiresOnCreate(t *testing.T) {
+	kvs := NewKVStore()
+	window := time.Now().Add(time.Minute)
+
+	kvs.IncrBy("window", 1, window)
+	kvs.IncrBy("window", 1, time.Now().Add(time.Hour))
+
+	// Only the increment creating the key sets its expiry
+	ttl, err := kvs.TTL("window")
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+
+	// Once the window is over the counter starts again
+	kvs.Expire("window", time.Now().Add(-time.Second), "")
+	n, _ := kvs.IncrBy("window", 1, time.Time{})
+	assert.Equal(t, int64(1), n)
+	ttl, _ = kvs.TTL("window")
+	assert.Equal(t, NoExpiry, ttl)
+}
+
+func TestIncrByFloat(t *testing.T) {
+	kvs := NewKVStore()
+
+	f, err := kvs.IncrByFloat("temp", 10.5, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 10.5, f)
+
+	f, _ = kvs.IncrByFloat("temp", 0.1, time.Time{})
+	value, _ := kvs.Get("temp")
+	assert.Equal(t, "10.6", value)
+	assert.Equal(t, 10.6, f)
+
+	// Integer counters can be incremented by floats, but not back
+	kvs.IncrBy("n", 3, time.Time{})
+	f, _ = kvs.IncrByFloat("n", 1.5, time.Time{})
+	assert.Equal(t, 4.5, f)
+	_, err = kvs.IncrBy("n", 1, time.Time{})
+	assert.Equal(t, ErrNotInteger, err)
+
+	_, err = kvs.IncrByFloat("temp", math.MaxFloat64, time.Time{})
+	assert.NoError(t, err)
+	_, err = kvs.IncrByFloat("temp", math.MaxFloat64, time.Time{})
+	assert.Equal(t, ErrNotFinite, err)
+
+	mustSet(t, kvs, "name", "bob", time.Time{}, "", false)
+	_, err = kvs.IncrByFloat("name", 1, time.Time{})
+	assert.Equal(t, ErrNotFloat, err)
+}
@@ -0,0 +1,142 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Keys looked at per sample
+	expireSampleSize = 20
+
+	// A store is sampled again as long as more than a quarter of the
+	// previous sample had expired, since it likely holds many more
+	expireRepeatPercent = 25
+)
+
+// ExpireStats describes one cycle of the Expirer
+type ExpireStats struct {
+	Sampled int
+	Expired int
+	Took    time.Duration
+
+	// Keys expired per second, measured over the last second
+	ExpiredPerSecond float64
+}
+
+// Expirer actively deletes expired keys from a set of stores, like the
+// Redis active expire cycle. Each cycle samples random keys that have an
+// expiry from every store in turn, deleting those that expired, and keeps
+// sampling a store while a large part of its samples turn out expired. A
+// cycle stops early once it has used up its share of CPU time, and the next
+// cycle resumes from the store it stopped at.
+type Expirer struct {
+	stores   []*KVStore
+	interval time.Duration
+	budget   time.Duration
+	onCycle  func(ExpireStats)
+
+	next int
+
+	windowStart   time.Time
+	windowExpired int
+	perSecond     float64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewExpirer returns an Expirer running hz cycles per second over stores.
+// cpuBudget is the fraction of one CPU the cycles may use, between 0 and 1.
+// onCycle, if not nil, is called after every cycle.
+func NewExpirer(stores []*KVStore, hz int, cpuBudget float64, onCycle func(ExpireStats)) *Expirer {
+	if hz <= 0 {
+		hz = 10
+	}
+	if cpuBudget <= 0 || cpuBudget > 1 {
+		cpuBudget = 0.25
+	}
+
+	interval := time.Second / time.Duration(hz)
+
+	return &Expirer{
+		stores:   stores,
+		interval: interval,
+		budget:   time.Duration(float64(interval) * cpuBudget),
+		onCycle:  onCycle,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start runs cycles in the background until Stop is called
+func (e *Expirer) Start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				stats := e.Cycle()
+				if e.onCycle != nil {
+					e.onCycle(stats)
+				}
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (e *Expirer) Stop() {
+	e.stopOnce.Do(func() { close(e.stop) })
+	e.wg.Wait()
+}
+
+// Cycle runs a single expire cycle. It must not be called concurrently with
+// itself or with a started Expirer.
+func (e *Expirer) Cycle() ExpireStats {
+	var stats ExpireStats
+
+	start := time.Now()
+	deadline := start.Add(e.budget)
+
+	for visited := 0; visited < len(e.stores); visited++ {
+		store := e.stores[e.next]
+
+		for {
+			sampled, expired := store.ExpireSample(expireSampleSize)
+			stats.Sampled += sampled
+			stats.Expired += expired
+
+			if sampled == 0 || expired*100 <= sampled*expireRepeatPercent || !time.Now().Before(deadline) {
+				break
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+		e.next = (e.next + 1) % len(e.stores)
+	}
+
+	now := time.Now()
+	stats.Took = now.Sub(start)
+
+	if e.windowStart.IsZero() {
+		e.windowStart = start
+	}
+	e.windowExpired += stats.Expired
+	if elapsed := now.Sub(e.windowStart); elapsed >= time.Second {
+		e.perSecond = float64(e.windowExpired) / elapsed.Seconds()
+		e.windowStart = now
+		e.windowExpired = 0
+	}
+	stats.ExpiredPerSecond = e.perSecond
+
+	return stats
+}
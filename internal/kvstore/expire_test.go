@@ -0,0 +1,106 @@
+package kvstore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpirerDeletesExpiredKeys(t *testing.T) {
+	stores := []*KVStore{NewKVStore(), NewKVStore()}
+
+	soon := time.Now().Add(10 * time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		store := stores[i%len(stores)]
+		mustSet(t, store, fmt.Sprint("session", i), "v", soon, "", false)
+		mustSet(t, store, fmt.Sprint("later", i), "v", time.Now().Add(time.Hour), "", false)
+		mustSet(t, store, fmt.Sprint("forever", i), "v", time.Time{}, "", false)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	e := NewExpirer(stores, 10, 1, nil)
+
+	expired := 0
+	// Sampling only finds every key eventually, not in a single cycle
+	for i := 0; i < 2000 && expired < 1000; i++ {
+		stats := e.Cycle()
+		expired += stats.Expired
+	}
+
+	assert.Equal(t, 1000, expired)
+	assert.Equal(t, 2000, stores[0].Len()+stores[1].Len())
+
+	_, err := stores[0].Get("later0")
+	assert.NoError(t, err)
+	_, err = stores[0].Get("forever0")
+	assert.NoError(t, err)
+}
+
+func TestSetNXOnExpiredKey(t *testing.T) {
+	kvs := NewKVStore()
+
+	mustSet(t, kvs, "key", "old", time.Now().Add(-time.Second), "", false)
+	assert.True(t, mustSet(t, kvs, "key", "new", time.Time{}, "NX", false))
+	assert.False(t, mustSet(t, kvs, "key", "newest", time.Time{}, "NX", false))
+
+	value, err := kvs.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", value)
+}
+
+func TestOnExpire(t *testing.T) {
+	kvs := NewKVStore()
+	var expired, locked []string
+	kvs.OnExpire(func(key string) func() {
+		locked = append(locked, key)
+		return func() {}
+	}, func(key string) { expired = append(expired, key) })
+
+	past := time.Now().Add(-time.Second)
+	mustSet(t, kvs, "written", "v", past, "", false)
+	mustSet(t, kvs, "sampled", "v", past, "", false)
+	mustSet(t, kvs, "live", "v", time.Now().Add(time.Hour), "", false)
+
+	// Writes delete the key they change first, under the caller's lock
+	_, err := kvs.HSet("written", map[string]string{"f": "v"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"written"}, expired)
+	assert.Empty(t, locked)
+
+	// while sampling takes the lock of the key
+	sampled, n := kvs.ExpireSample(10)
+	assert.Equal(t, 2, sampled)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"written", "sampled"}, expired)
+	assert.Equal(t, []string{"sampled"}, locked)
+}
+
+func TestHoldExpiry(t *testing.T) {
+	kvs := NewKVStore()
+	kvs.HoldExpiry(true)
+
+	mustSet(t, kvs, "key", "v", time.Now().Add(-time.Second), "", false)
+	_, err := kvs.IncrBy("n", 1, time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	_, err = kvs.Set("key", "kept", time.Time{}, "XX", true)
+	require.NoError(t, err)
+
+	// Keys past their expiry are written to as if they had not expired
+	value, err := kvs.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "kept", value)
+	n, err := kvs.IncrBy("n", 1, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	_, expired := kvs.ExpireSample(10)
+	assert.Equal(t, 0, expired)
+
+	kvs.HoldExpiry(false)
+	_, err = kvs.Get("key")
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, expired = kvs.ExpireSample(10)
+	assert.Equal(t, 2, expired)
+}
@@ -0,0 +1,104 @@
+package kvstore
+
+// MatchGlob reports whether s matches the Redis style glob pattern: a star
+// matches any sequence of characters, a question mark any single character,
+// [abc] one of the listed characters, [a-z] a range and [^abc] anything
+// but the listed characters. A backslash matches the next character
+// literally.
+func MatchGlob(pattern, s string) bool {
+	// On a mismatch the last star takes one more character and matching
+	// resumes after it. Earlier stars never need to take more, so matching
+	// takes at most len(pattern)*len(s) steps.
+	star, starS := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				star, starS = p, i
+				p++
+				continue
+			}
+			if next, ok := matchOne(pattern[p:], s[i]); ok {
+				p = len(pattern) - len(next)
+				i++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starS++
+		p, i = star+1, starS
+	}
+
+	// Only stars can match what is left of the pattern
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchOne matches c against the single character pattern at the start of
+// pattern, which is not a star. It returns the rest of the pattern.
+func matchOne(pattern string, c byte) (string, bool) {
+	switch pattern[0] {
+	case '?':
+		return pattern[1:], true
+
+	case '[':
+		matched, rest := matchClass(pattern[1:], c)
+		return rest, matched
+
+	case '\\':
+		if len(pattern) > 1 {
+			pattern = pattern[1:]
+		}
+	}
+
+	return pattern[1:], pattern[0] == c
+}
+
+// matchClass matches c against the character class at the start of pattern,
+// which follows the opening bracket. It returns the pattern after the
+// closing bracket.
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			pattern = pattern[3:]
+
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+
+	// Skip the closing bracket, an unterminated class ends the pattern
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return matched != negate, pattern
+}
@@ -0,0 +1,164 @@
+package kvstore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var ErrFieldNotFound = errors.New("field not found")
+
+// Hash is the value of keys holding a hash, a map of fields to values
+type Hash map[string]string
+
+// hash returns the hash stored under key, or nil if the key does not exist.
+// The caller must hold a lock.
+func (kvs *KVStore) hash(key string, now time.Time) (Hash, error) {
+	keyValue, exists := kvs.store[key]
+	if !exists || keyValue.expired(now) {
+		return nil, nil
+	}
+
+	h, ok := keyValue.Value.(Hash)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return h, nil
+}
+
+// HSet sets fields of the hash under key, creating it if needed, and returns
+// how many fields were added rather than updated
+func (kvs *KVStore) HSet(key string, fields map[string]string) (int, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	h, err := kvs.hash(key, kvs.expireIfNeeded(key))
+	if err != nil {
+		return 0, err
+	}
+	if h == nil {
+		h = make(Hash, len(fields))
+		kvs.put(key, KeyValue{Value: h})
+	}
+
+	added := 0
+	for field, value := range fields {
+		if _, exists := h[field]; !exists {
+			added++
+		}
+		h[field] = value
+	}
+
+	return added, nil
+}
+
+func (kvs *KVStore) HGet(key, field string) (string, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	h, err := kvs.hash(key, kvs.now())
+	if err != nil {
+		return "", err
+	}
+	if h == nil {
+		return "", ErrKeyNotFound
+	}
+
+	value, exists := h[field]
+	if !exists {
+		return "", ErrFieldNotFound
+	}
+
+	return value, nil
+}
+
+// HDel removes fields from the hash under key and returns how many existed.
+// The key is deleted along with its last field.
+func (kvs *KVStore) HDel(key string, fields ...string) (int, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	h, err := kvs.hash(key, kvs.expireIfNeeded(key))
+	if err != nil || h == nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, field := range fields {
+		if _, exists := h[field]; exists {
+			delete(h, field)
+			deleted++
+		}
+	}
+	if len(h) == 0 {
+		kvs.remove(key)
+	}
+
+	return deleted, nil
+}
+
+// HGetAll returns a copy of the hash under key, which is empty if the key
+// does not exist
+func (kvs *KVStore) HGetAll(key string) (map[string]string, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	h, err := kvs.hash(key, kvs.now())
+	if err != nil {
+		return nil, err
+	}
+
+	return h.clone(), nil
+}
+
+// HLen returns the number of fields of the hash under key
+func (kvs *KVStore) HLen(key string) (int, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	h, err := kvs.hash(key, kvs.now())
+	return len(h), err
+}
+
+// HIncrBy adds delta to the integer in field of the hash under key, see
+// IncrBy
+func (kvs *KVStore) HIncrBy(key, field string, delta int64) (int64, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	h, err := kvs.hash(key, kvs.expireIfNeeded(key))
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if value, exists := h[field]; exists {
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	n += delta
+
+	if h == nil {
+		h = make(Hash)
+		kvs.put(key, KeyValue{Value: h})
+	}
+	h[field] = strconv.FormatInt(n, 10)
+
+	return n, nil
+}
+
+// clone copies h, so that it can be read without holding the lock
+func (h Hash) clone() Hash {
+	c := make(Hash, len(h))
+	for field, value := range h {
+		c[field] = value
+	}
+
+	return c
+}
@@ -0,0 +1,67 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHash(t *testing.T) {
+	kvs := NewKVStore()
+
+	added, err := kvs.HSet("user:1", map[string]string{"name": "ada", "visits": "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	added, _ = kvs.HSet("user:1", map[string]string{"name": "ada l", "lang": "en"})
+	assert.Equal(t, 1, added)
+
+	value, err := kvs.HGet("user:1", "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "ada l", value)
+	_, err = kvs.HGet("user:1", "missing")
+	assert.Equal(t, ErrFieldNotFound, err)
+	_, err = kvs.HGet("user:2", "name")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	n, err := kvs.HIncrBy("user:1", "visits", 41)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+	_, err = kvs.HIncrBy("user:1", "name", 1)
+	assert.Equal(t, ErrNotInteger, err)
+
+	// The copy is not affected by later writes
+	fields, _ := kvs.HGetAll("user:1")
+	kvs.HSet("user:1", map[string]string{"name": "changed"})
+	assert.Equal(t, map[string]string{"name": "ada l", "visits": "42", "lang": "en"}, fields)
+
+	deleted, _ := kvs.HDel("user:1", "name", "visits", "missing")
+	assert.Equal(t, 2, deleted)
+	length, _ := kvs.HLen("user:1")
+	assert.Equal(t, 1, length)
+
+	// Deleting the last field deletes the key
+	kvs.HDel("user:1", "lang")
+	assert.False(t, kvs.Exists("user:1"))
+}
+
+func TestWrongType(t *testing.T) {
+	kvs := NewKVStore()
+	mustSet(t, kvs, "string", "v", time.Time{}, "", false)
+	kvs.HSet("hash", map[string]string{"f": "1"})
+
+	_, err := kvs.HSet("string", map[string]string{"f": "v"})
+	assert.Equal(t, ErrWrongType, err)
+	_, err = kvs.HGetAll("string")
+	assert.Equal(t, ErrWrongType, err)
+	_, err = kvs.Get("hash")
+	assert.Equal(t, ErrWrongType, err)
+	_, err = kvs.IncrBy("hash", 1, time.Time{})
+	assert.Equal(t, ErrWrongType, err)
+
+	// SET replaces a value of any type
+	mustSet(t, kvs, "hash", "v", time.Time{}, "", false)
+	value, err := kvs.Get("hash")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", value)
+}
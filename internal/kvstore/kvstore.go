@@ -2,15 +2,21 @@ package kvstore
 
 import (
 	"errors"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	ErrKeyNotFound      = errors.New("key not found")
 	ErrInvalidCondition = errors.New("invalid condition")
+	ErrWrongType        = errors.New("operation against a key holding the wrong kind of value")
 )
 
+// NoExpiry is returned by TTL for keys that never expire
+const NoExpiry time.Duration = -1
+
 type KeyValue struct {
 	Value     interface{}
 	ExpiresAt time.Time
@@ -19,46 +25,446 @@ type KeyValue struct {
 type KVStore struct {
 	store map[string]KeyValue
 	mu    sync.RWMutex
+
+	// Keys that have an expiry, sampled by ExpireSample
+	volatile map[string]struct{}
+	// Every key ordered by scan hash, then by key, scored by its hash
+	scanIndex *skiplist
+
+	// Set by HoldExpiry, keys then never expiring
+	expiryHeld atomic.Bool
+	// Set by OnExpire
+	lockKey  func(key string) func()
+	onExpire func(key string)
 }
 
 func NewKVStore() *KVStore {
 	return &KVStore{
-		store: make(map[string]KeyValue),
+		store:     make(map[string]KeyValue),
+		volatile:  make(map[string]struct{}),
+		scanIndex: newSkiplist(),
 	}
 }
 
-func (kvs *KVStore) Set(key string, value interface{}, expiresAt time.Time, condition string) error {
+// expired reports whether keyValue has an expiry that is not after now
+func (keyValue KeyValue) expired(now time.Time) bool {
+	return !keyValue.ExpiresAt.IsZero() && !now.Before(keyValue.ExpiresAt)
+}
+
+// now returns the time keys expire against, which is before every expiry
+// while expiry is held
+func (kvs *KVStore) now() time.Time {
+	if kvs.expiryHeld.Load() {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// HoldExpiry keeps keys from expiring while hold is set, as while replaying
+// writes made to keys before they expired
+func (kvs *KVStore) HoldExpiry(hold bool) {
+	kvs.expiryHeld.Store(hold)
+}
+
+// OnExpire reports every key deleted for having expired to expired. Writes
+// delete the key they change if it expired, under whatever lock their
+// caller holds, while ExpireSample deletes keys under the lock returned by
+// lockKey, taken before the lock of the store as writers do. This lets a
+// caller logging every write under per-key locks log the deletions in the
+// same order.
+func (kvs *KVStore) OnExpire(lockKey func(key string) func(), expired func(key string)) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	kvs.lockKey, kvs.onExpire = lockKey, expired
+}
+
+// expireIfNeeded deletes key if it expired, as writes do before changing
+// it, and returns the time it was checked against. The caller must hold the
+// write lock.
+func (kvs *KVStore) expireIfNeeded(key string) time.Time {
+	now := kvs.now()
+	if keyValue, exists := kvs.store[key]; exists && keyValue.expired(now) {
+		kvs.expire(key)
+	}
+
+	return now
+}
+
+// expire deletes key for having expired. The caller must hold the write
+// lock.
+func (kvs *KVStore) expire(key string) {
+	kvs.remove(key)
+	if kvs.onExpire != nil {
+		kvs.onExpire(key)
+	}
+}
+
+// put stores keyValue and keeps track of whether it can expire. The caller
+// must hold the write lock.
+func (kvs *KVStore) put(key string, keyValue KeyValue) {
+	if _, exists := kvs.store[key]; !exists {
+		kvs.scanIndex.insert(float64(scanHash(key)), key)
+	}
+	kvs.store[key] = keyValue
+	if keyValue.ExpiresAt.IsZero() {
+		delete(kvs.volatile, key)
+	} else {
+		kvs.volatile[key] = struct{}{}
+	}
+}
+
+// remove deletes key. The caller must hold the write lock.
+func (kvs *KVStore) remove(key string) {
+	if _, exists := kvs.store[key]; exists {
+		kvs.scanIndex.delete(float64(scanHash(key)), key)
+	}
+	delete(kvs.store, key)
+	delete(kvs.volatile, key)
+}
+
+// Set stores value under key and reports whether it did. condition may be
+// NX to only set keys that do not exist or XX to only set keys that exist.
+// With keepTTL the expiry of an existing key is kept and expiresAt is
+// ignored.
+func (kvs *KVStore) Set(key string, value interface{}, expiresAt time.Time, condition string, keepTTL bool) (bool, error) {
 	kvs.mu.Lock()
 	defer kvs.mu.Unlock()
 
 	if condition != "" && condition != "NX" && condition != "XX" {
-		return ErrInvalidCondition
+		return false, ErrInvalidCondition
 	}
 
-	_, exists := kvs.store[key]
+	kvs.expireIfNeeded(key)
+	keyValue, exists := kvs.store[key]
 	if condition == "NX" && exists {
-		return nil
+		return false, nil
 	} else if condition == "XX" && !exists {
-		return nil
+		return false, nil
+	}
+
+	if keepTTL {
+		expiresAt = keyValue.ExpiresAt
+		if !exists {
+			expiresAt = time.Time{}
+		}
 	}
 
-	kvs.store[key] = KeyValue{
+	kvs.put(key, KeyValue{
 		Value:     value,
 		ExpiresAt: expiresAt,
+	})
+
+	return true, nil
+}
+
+// TTL returns how long key has left to live, or NoExpiry if it never
+// expires
+func (kvs *KVStore) TTL(key string) (time.Duration, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	now := kvs.now()
+	keyValue, exists := kvs.store[key]
+	if !exists || keyValue.expired(now) {
+		return 0, ErrKeyNotFound
+	}
+	if keyValue.ExpiresAt.IsZero() {
+		return NoExpiry, nil
 	}
 
-	return nil
+	return keyValue.ExpiresAt.Sub(now), nil
 }
 
+// ExpiresAt returns the expiry of the entry under key, zero if it has none,
+// even if it passed already, and whether there is such an entry
+func (kvs *KVStore) ExpiresAt(key string) (time.Time, bool) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	keyValue, exists := kvs.store[key]
+	return keyValue.ExpiresAt, exists
+}
+
+// Expire sets the expiry of an existing key, deleting it right away if
+// expiresAt is not in the future. condition may be NX to only set an expiry
+// on keys without one, XX to only update an existing expiry, GT to only
+// extend it or LT to only shorten it; a key without expiry counts as
+// expiring never. Expire reports whether the expiry was changed.
+func (kvs *KVStore) Expire(key string, expiresAt time.Time, condition string) (bool, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	now := kvs.expireIfNeeded(key)
+	keyValue, exists := kvs.store[key]
+	if !exists {
+		return false, nil
+	}
+
+	persistent := keyValue.ExpiresAt.IsZero()
+	switch condition {
+	case "":
+	case "NX":
+		if !persistent {
+			return false, nil
+		}
+	case "XX":
+		if persistent {
+			return false, nil
+		}
+	case "GT":
+		if persistent || !expiresAt.After(keyValue.ExpiresAt) {
+			return false, nil
+		}
+	case "LT":
+		if !persistent && !expiresAt.Before(keyValue.ExpiresAt) {
+			return false, nil
+		}
+	default:
+		return false, ErrInvalidCondition
+	}
+
+	if !now.Before(expiresAt) {
+		kvs.expire(key)
+		return true, nil
+	}
+
+	keyValue.ExpiresAt = expiresAt
+	kvs.put(key, keyValue)
+
+	return true, nil
+}
+
+// Persist removes the expiry of key and reports whether it had one
+func (kvs *KVStore) Persist(key string) (bool, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	kvs.expireIfNeeded(key)
+	keyValue, exists := kvs.store[key]
+	if !exists || keyValue.ExpiresAt.IsZero() {
+		return false, nil
+	}
+
+	keyValue.ExpiresAt = time.Time{}
+	kvs.put(key, keyValue)
+
+	return true, nil
+}
+
+// Get returns the plain value stored under key, failing with ErrWrongType for
+// keys holding a data type such as a hash
 func (kvs *KVStore) Get(key string) (interface{}, error) {
 	kvs.mu.RLock()
 	defer kvs.mu.RLocker().Unlock()
 
-	if keyValue, exists := kvs.store[key]; exists {
-		if keyValue.ExpiresAt.IsZero() || time.Now().Before(keyValue.ExpiresAt) {
-			return keyValue.Value, nil
+	keyValue, exists := kvs.store[key]
+	if !exists || keyValue.expired(kvs.now()) {
+		return nil, ErrKeyNotFound
+	}
+	if !isPlain(keyValue.Value) {
+		return nil, ErrWrongType
+	}
+
+	return keyValue.Value, nil
+}
+
+// Delete removes keys and returns how many of them existed
+func (kvs *KVStore) Delete(keys ...string) int {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	deleted := 0
+	for _, key := range keys {
+		kvs.expireIfNeeded(key)
+		if _, exists := kvs.store[key]; exists {
+			kvs.remove(key)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// Exists reports whether key exists and has not expired
+func (kvs *KVStore) Exists(key string) bool {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	keyValue, exists := kvs.store[key]
+	return exists && !keyValue.expired(kvs.now())
+}
+
+// Keys returns every key matching the glob pattern, see MatchGlob
+func (kvs *KVStore) Keys(pattern string) []string {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	now := kvs.now()
+	var keys []string
+	for key, keyValue := range kvs.store {
+		if !keyValue.expired(now) && (pattern == "*" || MatchGlob(pattern, key)) {
+			keys = append(keys, key)
 		}
 	}
 
-	return nil, ErrKeyNotFound
+	return keys
+}
+
+// Scan iterates over the keys in the order of their scan hash. It looks at
+// about count keys whose hash is at least cursor and returns those that
+// match the glob pattern, how many keys it looked at and the cursor to
+// continue from. done is true once every key has been looked at.
+//
+// Since the order only depends on the keys themselves, a key that exists
+// for the whole iteration is returned at least once no matter what is added
+// or deleted in between. Keys sharing a hash are always returned together.
+// The keys are kept in that order, so a call costs O(log n + count).
+func (kvs *KVStore) Scan(cursor uint32, count int, pattern string) (keys []string, examined int, next uint32, done bool) {
+	if count < 1 {
+		count = 1
+	}
+
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	now := kvs.now()
+	x := kvs.scanIndex.first(func(n *skiplistNode) bool { return n.score >= float64(cursor) })
+	for last := x; x != nil && (examined < count || x.score == last.score); x = x.level[0].forward {
+		last = x
+		examined++
+		if kvs.store[x.member].expired(now) {
+			continue
+		}
+		if pattern == "" || pattern == "*" || MatchGlob(pattern, x.member) {
+			keys = append(keys, x.member)
+		}
+	}
+
+	if x == nil {
+		return keys, examined, 0, true
+	}
+
+	return keys, examined, uint32(x.score), false
+}
+
+func scanHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Dump copies every entry that has not expired into dst. The store is only
+// read-locked for the duration of the copy.
+func (kvs *KVStore) Dump(dst map[string]KeyValue) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	now := kvs.now()
+	for key, keyValue := range kvs.store {
+		if !keyValue.expired(now) {
+			keyValue.Value = cloneValue(keyValue.Value)
+			dst[key] = keyValue
+		}
+	}
+}
+
+// isPlain reports whether v is a plain value, as stored by Set, rather than
+// a data type
+func isPlain(v interface{}) bool {
+	switch v.(type) {
+	case Hash, Set, *ZSet:
+		return false
+	default:
+		return true
+	}
+}
+
+// cloneValue copies the data types that are modified in place, so that a
+// copy of them can be used without holding the lock
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Hash:
+		return v.clone()
+	case Set:
+		return v.clone()
+	case *ZSet:
+		return v.clone()
+	default:
+		return v
+	}
+}
+
+// Load stores every entry of src, replacing existing keys
+func (kvs *KVStore) Load(src map[string]KeyValue) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	for key, keyValue := range src {
+		kvs.put(key, keyValue)
+	}
+}
+
+// ExpireSample looks at up to n keys that have an expiry, picked at random,
+// and deletes the ones that have expired. It returns how many keys it looked
+// at and how many it deleted.
+func (kvs *KVStore) ExpireSample(n int) (sampled, expired int) {
+	kvs.mu.Lock()
+
+	// Map iteration starts at a random position, which makes the first n
+	// keys a cheap random sample
+	now := kvs.now()
+	var locked []string
+	for key := range kvs.volatile {
+		if sampled == n {
+			break
+		}
+		sampled++
+
+		if !kvs.store[key].expired(now) {
+			continue
+		}
+		if kvs.lockKey != nil {
+			locked = append(locked, key)
+		} else {
+			kvs.expire(key)
+			expired++
+		}
+	}
+	lockKey := kvs.lockKey
+	kvs.mu.Unlock()
+
+	// Taking the lock of each key, the store's has to be let go first
+	for _, key := range locked {
+		unlock := lockKey(key)
+		kvs.mu.Lock()
+		if keyValue, exists := kvs.store[key]; exists && keyValue.expired(kvs.now()) {
+			kvs.expire(key)
+			expired++
+		}
+		kvs.mu.Unlock()
+		unlock()
+	}
+
+	return sampled, expired
+}
+
+// Len returns the number of keys, including expired keys that have not been
+// deleted yet
+func (kvs *KVStore) Len() int {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	return len(kvs.store)
+}
+
+// Stats returns the number of keys and how many of them have an expiry
+func (kvs *KVStore) Stats() ShardStats {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	return ShardStats{
+		Keys:     len(kvs.store),
+		Volatile: len(kvs.volatile),
+	}
 }
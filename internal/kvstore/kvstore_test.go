@@ -0,0 +1,209 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type setter interface {
+	Set(key string, value interface{}, expiresAt time.Time, condition string, keepTTL bool) (bool, error)
+}
+
+// mustSet sets key and fails the test on error, returning whether it was set
+func mustSet(t *testing.T, s setter, key string, value interface{}, expiresAt time.Time, condition string, keepTTL bool) bool {
+	t.Helper()
+
+	applied, err := s.Set(key, value, expiresAt, condition, keepTTL)
+	require.NoError(t, err)
+
+	return applied
+}
+
+func TestTTLAndPersist(t *testing.T) {
+	kvs := NewKVStore()
+
+	_, err := kvs.TTL("missing")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	mustSet(t, kvs, "key", "v", time.Time{}, "", false)
+	ttl, err := kvs.TTL("key")
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiry, ttl)
+
+	applied, err := kvs.Expire("key", time.Now().Add(time.Minute), "")
+	assert.NoError(t, err)
+	assert.True(t, applied)
+	ttl, _ = kvs.TTL("key")
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+
+	applied, _ = kvs.Persist("key")
+	assert.True(t, applied)
+	applied, _ = kvs.Persist("key")
+	assert.False(t, applied)
+
+	// An expiry in the past deletes the key
+	applied, _ = kvs.Expire("key", time.Now().Add(-time.Second), "")
+	assert.True(t, applied)
+	assert.Equal(t, 0, kvs.Len())
+
+	applied, _ = kvs.Expire("key", time.Now().Add(time.Minute), "")
+	assert.False(t, applied)
+}
+
+func TestExpireConditions(t *testing.T) {
+	kvs := NewKVStore()
+	soon, later := time.Now().Add(time.Minute), time.Now().Add(time.Hour)
+
+	mustSet(t, kvs, "key", "v", time.Time{}, "", false)
+
+	tests := []struct {
+		condition string
+		expiresAt time.Time
+		applied   bool
+	}{
+		{"XX", soon, false}, // no expiry yet
+		{"GT", soon, false}, // no expiry counts as infinite
+		{"LT", later, true},
+		{"NX", soon, false}, // has an expiry now
+		{"GT", soon, false},
+		{"LT", soon, true},
+		{"GT", later, true},
+		{"XX", soon, true},
+	}
+
+	for i, tt := range tests {
+		applied, err := kvs.Expire("key", tt.expiresAt, tt.condition)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.applied, applied, "step %d: %s", i, tt.condition)
+	}
+
+	_, err := kvs.Expire("key", soon, "ZZ")
+	assert.Equal(t, ErrInvalidCondition, err)
+}
+
+func TestSetKeepTTL(t *testing.T) {
+	kvs := NewKVStore()
+	expiresAt := time.Now().Add(time.Minute)
+
+	mustSet(t, kvs, "key", "old", expiresAt, "", false)
+	mustSet(t, kvs, "key", "new", time.Time{}, "", true)
+
+	value, _ := kvs.Get("key")
+	assert.Equal(t, "new", value)
+	ttl, _ := kvs.TTL("key")
+	assert.NotEqual(t, NoExpiry, ttl)
+	kept, ok := kvs.ExpiresAt("key")
+	assert.True(t, ok)
+	assert.True(t, kept.Equal(expiresAt))
+
+	// Without KEEPTTL an overwrite clears the expiry
+	mustSet(t, kvs, "key", "newer", time.Time{}, "", false)
+	ttl, _ = kvs.TTL("key")
+	assert.Equal(t, NoExpiry, ttl)
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:42", true},
+		{"user:*", "session:42", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"*b*b", "abcbdb", true},
+		{"*a", "", false},
+		{"a**", "a", true},
+		{"*?", "", false},
+		{"*[0-9]", "key:x9", true},
+		{`*\*`, "a*b", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, MatchGlob(tt.pattern, tt.s), "%q %q", tt.pattern, tt.s)
+	}
+}
+
+func TestMatchGlobManyStars(t *testing.T) {
+	s := strings.Repeat("a", 60)
+	start := time.Now()
+	assert.False(t, MatchGlob("*a*a*a*a*a*a*a*a*a*a*b", s))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestScanReturnsEveryKeyDespiteWrites(t *testing.T) {
+	kvs := NewKVStore()
+	for i := 0; i < 500; i++ {
+		mustSet(t, kvs, fmt.Sprint("key", i), "v", time.Time{}, "", false)
+	}
+
+	seen := make(map[string]bool)
+	var cursor uint32
+	for step := 0; ; step++ {
+		keys, _, next, done := kvs.Scan(cursor, 7, "key*")
+		for _, key := range keys {
+			seen[key] = true
+		}
+
+		// Writes in the middle of the scan must not make it skip the
+		// keys that exist throughout
+		kvs.Delete(fmt.Sprint("key", 400+step))
+		mustSet(t, kvs, fmt.Sprint("new", step), "v", time.Time{}, "", false)
+
+		if done {
+			break
+		}
+		cursor = next
+	}
+
+	for i := 0; i < 400; i++ {
+		assert.True(t, seen[fmt.Sprint("key", i)], "key%d not returned", i)
+	}
+	for key := range seen {
+		assert.True(t, MatchGlob("key*", key), key)
+	}
+}
+
+// Each call only looks at count keys, in hash order, whatever the size of
+// the store
+func TestScanLooksAtCountKeys(t *testing.T) {
+	kvs := NewKVStore()
+	for i := 0; i < 1000; i++ {
+		mustSet(t, kvs, fmt.Sprint("key", i), "v", time.Time{}, "", false)
+	}
+	kvs.Delete("key7")
+
+	var all []string
+	var cursor uint32
+	for {
+		keys, examined, next, done := kvs.Scan(cursor, 10, "")
+		all = append(all, keys...)
+		if done {
+			assert.LessOrEqual(t, examined, 10)
+			break
+		}
+		assert.Equal(t, 10, examined)
+		assert.Greater(t, next, cursor)
+		cursor = next
+	}
+
+	assert.Len(t, all, 999)
+	assert.True(t, sort.SliceIsSorted(all, func(i, j int) bool { return scanHash(all[i]) < scanHash(all[j]) }))
+}
@@ -0,0 +1,190 @@
+package kvstore
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrInvalidSetOperation = errors.New("invalid set operation")
+
+// Set operations across keys
+const (
+	SetUnion = "UNION"
+	SetInter = "INTER"
+	SetDiff  = "DIFF"
+)
+
+// Set is the value of keys holding an unordered set of unique strings
+type Set map[string]struct{}
+
+// set returns the set stored under key, or nil if the key does not exist.
+// The caller must hold a lock.
+func (kvs *KVStore) set(key string, now time.Time) (Set, error) {
+	keyValue, exists := kvs.store[key]
+	if !exists || keyValue.expired(now) {
+		return nil, nil
+	}
+
+	set, ok := keyValue.Value.(Set)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return set, nil
+}
+
+// SAdd adds members to the set under key, creating it if needed, and
+// returns how many were not members yet
+func (kvs *KVStore) SAdd(key string, members ...string) (int, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	set, err := kvs.set(key, kvs.expireIfNeeded(key))
+	if err != nil {
+		return 0, err
+	}
+	if set == nil {
+		set = make(Set, len(members))
+		kvs.put(key, KeyValue{Value: set})
+	}
+
+	added := 0
+	for _, member := range members {
+		if _, exists := set[member]; !exists {
+			set[member] = struct{}{}
+			added++
+		}
+	}
+
+	return added, nil
+}
+
+// SRem removes members from the set under key and returns how many were
+// members. The key is deleted along with its last member.
+func (kvs *KVStore) SRem(key string, members ...string) (int, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	set, err := kvs.set(key, kvs.expireIfNeeded(key))
+	if err != nil || set == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if _, exists := set[member]; exists {
+			delete(set, member)
+			removed++
+		}
+	}
+	if len(set) == 0 {
+		kvs.remove(key)
+	}
+
+	return removed, nil
+}
+
+// SMembers returns the members of the set under key in sorted order, none if
+// the key does not exist
+func (kvs *KVStore) SMembers(key string) ([]string, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	set, err := kvs.set(key, kvs.now())
+	if err != nil {
+		return nil, err
+	}
+
+	return set.members(), nil
+}
+
+func (kvs *KVStore) SIsMember(key, member string) (bool, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	set, err := kvs.set(key, kvs.now())
+	if err != nil {
+		return false, err
+	}
+
+	_, exists := set[member]
+	return exists, nil
+}
+
+// SCard returns the number of members of the set under key
+func (kvs *KVStore) SCard(key string) (int, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	set, err := kvs.set(key, kvs.now())
+	return len(set), err
+}
+
+// members returns the members of set in sorted order
+func (set Set) members() []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	return members
+}
+
+// clone copies set, so that it can be read without holding the lock
+func (set Set) clone() Set {
+	c := make(Set, len(set))
+	for member := range set {
+		c[member] = struct{}{}
+	}
+
+	return c
+}
+
+// combineSets applies op to sets, a missing key being an empty set. The
+// result never shares memory with sets.
+func combineSets(op string, sets []Set) (Set, error) {
+	switch op {
+	case SetUnion:
+		result := make(Set)
+		for _, set := range sets {
+			for member := range set {
+				result[member] = struct{}{}
+			}
+		}
+		return result, nil
+
+	case SetInter:
+		// Only the members of the smallest set can be in the result
+		smallest := sets[0]
+		for _, set := range sets[1:] {
+			if len(set) < len(smallest) {
+				smallest = set
+			}
+		}
+
+		result := make(Set)
+	members:
+		for member := range smallest {
+			for _, set := range sets {
+				if _, exists := set[member]; !exists {
+					continue members
+				}
+			}
+			result[member] = struct{}{}
+		}
+		return result, nil
+
+	case SetDiff:
+		result := sets[0].clone()
+		for _, set := range sets[1:] {
+			for member := range set {
+				delete(result, member)
+			}
+		}
+		return result, nil
+	}
+
+	return nil, ErrInvalidSetOperation
+}
@@ -0,0 +1,125 @@
+package kvstore
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSet(t *testing.T) {
+	kvs := NewKVStore()
+
+	added, err := kvs.SAdd("tags", "go", "kv", "go")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	added, _ = kvs.SAdd("tags", "kv", "db")
+	assert.Equal(t, 1, added)
+
+	members, err := kvs.SMembers("tags")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db", "go", "kv"}, members)
+	members, _ = kvs.SMembers("missing")
+	assert.Empty(t, members)
+
+	isMember, _ := kvs.SIsMember("tags", "go")
+	assert.True(t, isMember)
+	isMember, _ = kvs.SIsMember("tags", "rust")
+	assert.False(t, isMember)
+
+	removed, _ := kvs.SRem("tags", "go", "rust")
+	assert.Equal(t, 1, removed)
+	card, _ := kvs.SCard("tags")
+	assert.Equal(t, 2, card)
+
+	// Removing the last member deletes the key
+	kvs.SRem("tags", "kv", "db")
+	assert.False(t, kvs.Exists("tags"))
+
+	mustSet(t, kvs, "string", "v", time.Time{}, "", false)
+	_, err = kvs.SAdd("string", "m")
+	assert.Equal(t, ErrWrongType, err)
+	kvs.SAdd("set", "m")
+	_, err = kvs.Get("set")
+	assert.Equal(t, ErrWrongType, err)
+}
+
+func TestSetOperation(t *testing.T) {
+	s := NewShardedKVStore(8)
+	s.SAdd("a", "1", "2", "3", "4")
+	s.SAdd("b", "3", "4", "5")
+	s.SAdd("c", "4", "6")
+
+	tests := []struct {
+		op   string
+		keys []string
+		want []string
+	}{
+		{SetUnion, []string{"a", "b", "c"}, []string{"1", "2", "3", "4", "5", "6"}},
+		{SetInter, []string{"a", "b", "c"}, []string{"4"}},
+		{SetInter, []string{"a", "missing"}, []string{}},
+		{SetDiff, []string{"a", "b", "c"}, []string{"1", "2"}},
+		{SetDiff, []string{"missing", "a"}, []string{}},
+	}
+	for _, test := range tests {
+		members, err := s.SetOperation(test.op, test.keys...)
+		assert.NoError(t, err)
+		assert.Equal(t, test.want, members, "%s %v", test.op, test.keys)
+	}
+
+	_, err := s.SetOperation("XOR", "a", "b")
+	assert.Equal(t, ErrInvalidSetOperation, err)
+	mustSet(t, s, "string", "v", time.Time{}, "", false)
+	_, err = s.SetOperation(SetUnion, "a", "string")
+	assert.Equal(t, ErrWrongType, err)
+
+	// The destination is replaced, whatever it held, and may be a source
+	n, err := s.SetOperationStore(SetInter, "string", "a", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	members, _ := s.SMembers("string")
+	assert.Equal(t, []string{"3", "4"}, members)
+
+	n, _ = s.SetOperationStore(SetUnion, "a", "a", "c")
+	assert.Equal(t, 5, n)
+	s.SAdd("c", "7")
+	members, _ = s.SMembers("a")
+	assert.Equal(t, []string{"1", "2", "3", "4", "6"}, members)
+
+	// An empty result deletes the destination
+	n, _ = s.SetOperationStore(SetDiff, "a", "c", "c")
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0, s.Exists("a"))
+}
+
+// Concurrent STOREs over overlapping keys in different orders must neither
+// deadlock nor see a source half way through another STORE
+func TestSetOperationStoreConcurrent(t *testing.T) {
+	s := NewShardedKVStore(4)
+	keys := make([]string, 8)
+	for i := range keys {
+		keys[i] = "set" + strconv.Itoa(i)
+		s.SAdd(keys[i], "x")
+	}
+
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				dest := keys[(i+j)%len(keys)]
+				_, err := s.SetOperationStore(SetUnion, dest, keys[(i+1)%len(keys)], keys[(i+j+3)%len(keys)])
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		members, _ := s.SMembers(key)
+		assert.Equal(t, []string{"x"}, members)
+	}
+}
@@ -0,0 +1,373 @@
+package kvstore
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultShards is the number of shards used when NewShardedKVStore is given
+// zero
+const DefaultShards = 128
+
+// shardSeed is the murmur3 seed keys are routed with
+const shardSeed = 2
+
+// ShardedKVStore spreads keys over several KVStores by the murmur3 hash of
+// the key, so that writes to different keys rarely wait on the same lock
+type ShardedKVStore struct {
+	shards []*KVStore
+}
+
+// ShardStats describes the contents of a single shard
+type ShardStats struct {
+	Keys     int
+	Volatile int
+}
+
+func NewShardedKVStore(numShards int) *ShardedKVStore {
+	if numShards <= 0 {
+		numShards = DefaultShards
+	}
+
+	s := &ShardedKVStore{shards: make([]*KVStore, numShards)}
+	for i := range s.shards {
+		s.shards[i] = NewKVStore()
+	}
+
+	return s
+}
+
+// ShardIndex returns the index of the shard key belongs to
+func (s *ShardedKVStore) ShardIndex(key string) int {
+	return int(Murmur3([]byte(key), shardSeed) % uint32(len(s.shards)))
+}
+
+// Shard returns the shard key belongs to
+func (s *ShardedKVStore) Shard(key string) *KVStore {
+	return s.shards[s.ShardIndex(key)]
+}
+
+// Shards returns every shard, in index order
+func (s *ShardedKVStore) Shards() []*KVStore {
+	return s.shards
+}
+
+// HoldExpiry keeps keys from expiring while hold is set, see
+// KVStore.HoldExpiry
+func (s *ShardedKVStore) HoldExpiry(hold bool) {
+	for _, shard := range s.shards {
+		shard.HoldExpiry(hold)
+	}
+}
+
+// OnExpire reports every key deleted for having expired, see
+// KVStore.OnExpire
+func (s *ShardedKVStore) OnExpire(lockKey func(key string) func(), expired func(key string)) {
+	for _, shard := range s.shards {
+		shard.OnExpire(lockKey, expired)
+	}
+}
+
+// now returns the time keys expire against, expiry being held in every
+// shard at once
+func (s *ShardedKVStore) now() time.Time {
+	return s.shards[0].now()
+}
+
+func (s *ShardedKVStore) Set(key string, value interface{}, expiresAt time.Time, condition string, keepTTL bool) (bool, error) {
+	return s.Shard(key).Set(key, value, expiresAt, condition, keepTTL)
+}
+
+func (s *ShardedKVStore) Get(key string) (interface{}, error) {
+	return s.Shard(key).Get(key)
+}
+
+func (s *ShardedKVStore) TTL(key string) (time.Duration, error) {
+	return s.Shard(key).TTL(key)
+}
+
+func (s *ShardedKVStore) ExpiresAt(key string) (time.Time, bool) {
+	return s.Shard(key).ExpiresAt(key)
+}
+
+func (s *ShardedKVStore) Expire(key string, expiresAt time.Time, condition string) (bool, error) {
+	return s.Shard(key).Expire(key, expiresAt, condition)
+}
+
+func (s *ShardedKVStore) Persist(key string) (bool, error) {
+	return s.Shard(key).Persist(key)
+}
+
+func (s *ShardedKVStore) IncrBy(key string, delta int64, expiresAt time.Time) (int64, error) {
+	return s.Shard(key).IncrBy(key, delta, expiresAt)
+}
+
+func (s *ShardedKVStore) IncrByFloat(key string, delta float64, expiresAt time.Time) (float64, error) {
+	return s.Shard(key).IncrByFloat(key, delta, expiresAt)
+}
+
+func (s *ShardedKVStore) HSet(key string, fields map[string]string) (int, error) {
+	return s.Shard(key).HSet(key, fields)
+}
+
+func (s *ShardedKVStore) HGet(key, field string) (string, error) {
+	return s.Shard(key).HGet(key, field)
+}
+
+func (s *ShardedKVStore) HDel(key string, fields ...string) (int, error) {
+	return s.Shard(key).HDel(key, fields...)
+}
+
+func (s *ShardedKVStore) HGetAll(key string) (map[string]string, error) {
+	return s.Shard(key).HGetAll(key)
+}
+
+func (s *ShardedKVStore) HLen(key string) (int, error) {
+	return s.Shard(key).HLen(key)
+}
+
+func (s *ShardedKVStore) HIncrBy(key, field string, delta int64) (int64, error) {
+	return s.Shard(key).HIncrBy(key, field, delta)
+}
+
+func (s *ShardedKVStore) SAdd(key string, members ...string) (int, error) {
+	return s.Shard(key).SAdd(key, members...)
+}
+
+func (s *ShardedKVStore) SRem(key string, members ...string) (int, error) {
+	return s.Shard(key).SRem(key, members...)
+}
+
+func (s *ShardedKVStore) SMembers(key string) ([]string, error) {
+	return s.Shard(key).SMembers(key)
+}
+
+func (s *ShardedKVStore) SIsMember(key, member string) (bool, error) {
+	return s.Shard(key).SIsMember(key, member)
+}
+
+func (s *ShardedKVStore) SCard(key string) (int, error) {
+	return s.Shard(key).SCard(key)
+}
+
+func (s *ShardedKVStore) ZAdd(key string, scores map[string]float64, condition, comparison string) (int, error) {
+	return s.Shard(key).ZAdd(key, scores, condition, comparison)
+}
+
+func (s *ShardedKVStore) ZIncrBy(key string, delta float64, member string) (float64, error) {
+	return s.Shard(key).ZIncrBy(key, delta, member)
+}
+
+func (s *ShardedKVStore) ZRem(key string, members ...string) (int, error) {
+	return s.Shard(key).ZRem(key, members...)
+}
+
+func (s *ShardedKVStore) ZRemRangeByScore(key, min, max string) (int, error) {
+	return s.Shard(key).ZRemRangeByScore(key, min, max)
+}
+
+func (s *ShardedKVStore) ZScore(key, member string) (float64, error) {
+	return s.Shard(key).ZScore(key, member)
+}
+
+func (s *ShardedKVStore) ZRank(key, member string, rev bool) (int, error) {
+	return s.Shard(key).ZRank(key, member, rev)
+}
+
+func (s *ShardedKVStore) ZCard(key string) (int, error) {
+	return s.Shard(key).ZCard(key)
+}
+
+func (s *ShardedKVStore) ZRange(key string, r ZRange) ([]ZMember, error) {
+	return s.Shard(key).ZRange(key, r)
+}
+
+// SetOperation applies op, one of SetUnion, SetInter or SetDiff, to the sets
+// under keys and returns the sorted members of the result. Every shard
+// involved is locked at once, so the sets are seen at one point in time.
+func (s *ShardedKVStore) SetOperation(op string, keys ...string) ([]string, error) {
+	defer s.lockShards(false, keys...)()
+
+	result, err := s.combineSets(op, keys, s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	return result.members(), nil
+}
+
+// SetOperationStore is SetOperation storing the result under dest, which
+// is replaced whatever it held, or deleted if the result is empty. It
+// returns the size of the result.
+func (s *ShardedKVStore) SetOperationStore(op, dest string, keys ...string) (int, error) {
+	defer s.lockShards(true, append([]string{dest}, keys...)...)()
+
+	result, err := s.combineSets(op, keys, s.Shard(dest).expireIfNeeded(dest))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(result) == 0 {
+		s.Shard(dest).remove(dest)
+	} else {
+		s.Shard(dest).put(dest, KeyValue{Value: result})
+	}
+
+	return len(result), nil
+}
+
+// combineSets applies op to the sets under keys. The caller must hold the
+// locks of their shards.
+func (s *ShardedKVStore) combineSets(op string, keys []string, now time.Time) (Set, error) {
+	sets := make([]Set, len(keys))
+	for i, key := range keys {
+		set, err := s.Shard(key).set(key, now)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	return combineSets(op, sets)
+}
+
+// lockShards locks the shards of keys, each one once and in index order so
+// that concurrent calls cannot deadlock, and returns a function unlocking
+// them
+func (s *ShardedKVStore) lockShards(write bool, keys ...string) func() {
+	seen := make(map[int]bool, len(keys))
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		if idx := s.ShardIndex(key); !seen[idx] {
+			seen[idx] = true
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Ints(indexes)
+
+	for _, idx := range indexes {
+		if write {
+			s.shards[idx].mu.Lock()
+		} else {
+			s.shards[idx].mu.RLock()
+		}
+	}
+
+	return func() {
+		for _, idx := range indexes {
+			if write {
+				s.shards[idx].mu.Unlock()
+			} else {
+				s.shards[idx].mu.RUnlock()
+			}
+		}
+	}
+}
+
+// Delete deletes keys and returns how many existed
+func (s *ShardedKVStore) Delete(keys ...string) int {
+	deleted := 0
+	for _, key := range keys {
+		deleted += s.Shard(key).Delete(key)
+	}
+
+	return deleted
+}
+
+// Exists returns how many of keys exist, counting repeated keys every time
+func (s *ShardedKVStore) Exists(keys ...string) int {
+	count := 0
+	for _, key := range keys {
+		if s.Shard(key).Exists(key) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Keys returns every key matching the glob pattern, locking one shard at a
+// time
+func (s *ShardedKVStore) Keys(pattern string) []string {
+	keys := []string{}
+	for _, shard := range s.shards {
+		keys = append(keys, shard.Keys(pattern)...)
+	}
+
+	return keys
+}
+
+// Scan returns keys matching pattern, starting at cursor and looking at about
+// count keys, along with the cursor of the next call. A scan starts with
+// cursor 0 and is complete when 0 is returned again.
+//
+// The cursor holds the shard being scanned in its upper 32 bits and the
+// position in that shard in the lower ones, so only one shard is locked at a
+// time.
+func (s *ShardedKVStore) Scan(cursor uint64, count int, pattern string) (uint64, []string) {
+	shard, pos := int(cursor>>32), uint32(cursor)
+	keys := []string{}
+
+	for shard < len(s.shards) && count > 0 {
+		found, examined, next, done := s.shards[shard].Scan(pos, count, pattern)
+		keys = append(keys, found...)
+		count -= examined
+
+		if !done {
+			return uint64(shard)<<32 | uint64(next), keys
+		}
+		shard, pos = shard+1, 0
+	}
+
+	if shard >= len(s.shards) {
+		return 0, keys
+	}
+
+	return uint64(shard) << 32, keys
+}
+
+// Dump copies every key into dst, one shard at a time
+func (s *ShardedKVStore) Dump(dst map[string]KeyValue) {
+	for _, shard := range s.shards {
+		shard.Dump(dst)
+	}
+}
+
+// Load routes the keys of src to their shards and stores them
+func (s *ShardedKVStore) Load(src map[string]KeyValue) {
+	perShard := make([]map[string]KeyValue, len(s.shards))
+	for key, keyValue := range src {
+		idx := s.ShardIndex(key)
+		if perShard[idx] == nil {
+			perShard[idx] = make(map[string]KeyValue)
+		}
+		perShard[idx][key] = keyValue
+	}
+
+	for idx, entries := range perShard {
+		if entries != nil {
+			s.shards[idx].Load(entries)
+		}
+	}
+}
+
+// Len returns the number of keys in every shard, including expired keys that
+// have not been deleted yet
+func (s *ShardedKVStore) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+
+	return n
+}
+
+// Stats returns the stats of every shard, in index order
+func (s *ShardedKVStore) Stats() []ShardStats {
+	stats := make([]ShardStats, len(s.shards))
+	for i, shard := range s.shards {
+		stats[i] = shard.Stats()
+	}
+
+	return stats
+}
@@ -0,0 +1,61 @@
+package kvstore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedKVStoreRoutesReadsAndWrites(t *testing.T) {
+	s := NewShardedKVStore(8)
+
+	for i := 0; i < 100; i++ {
+		mustSet(t, s, fmt.Sprint("key", i), i, time.Time{}, "", false)
+	}
+
+	value, err := s.Get("key42")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, value)
+	assert.Equal(t, 2, s.Exists("key1", "key2", "missing"))
+
+	total := 0
+	for i, stats := range s.Stats() {
+		assert.Equal(t, s.Shards()[i].Len(), stats.Keys)
+		total += stats.Keys
+	}
+	assert.Equal(t, 100, total)
+
+	// Dumped keys load back into the shard they are routed to
+	dump := make(map[string]KeyValue)
+	s.Dump(dump)
+	loaded := NewShardedKVStore(8)
+	loaded.Load(dump)
+	assert.Equal(t, s.Stats(), loaded.Stats())
+
+	assert.Equal(t, 3, s.Delete("key1", "key2", "key3", "missing"))
+	assert.Equal(t, 97, s.Len())
+}
+
+func TestShardedKVStoreScan(t *testing.T) {
+	s := NewShardedKVStore(4)
+	for i := 0; i < 200; i++ {
+		mustSet(t, s, fmt.Sprint("key", i), "v", time.Time{}, "", false)
+	}
+
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		next, keys := s.Scan(cursor, 9, "*")
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	assert.Len(t, seen, 200)
+}
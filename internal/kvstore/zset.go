@@ -0,0 +1,504 @@
+package kvstore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMemberNotFound = errors.New("member not found")
+	ErrInvalidRange   = errors.New("min or max is not a valid range item")
+)
+
+// Orders of ZRange
+const (
+	ZByRank  = ""
+	ZByScore = "SCORE"
+	ZByLex   = "LEX"
+)
+
+// ZSet is the value of keys holding a sorted set, members with a score
+// ordered by score, then by member. Scores are looked up in a map and ranks
+// and ranges in a skiplist.
+type ZSet struct {
+	scores map[string]float64
+	list   *skiplist
+}
+
+// ZMember is a member of a sorted set along with its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZRange selects members of a sorted set
+type ZRange struct {
+	// ZByRank, ZByScore or ZByLex
+	By string
+	// Start and Stop are ranks counted from 0, negative ones from the end,
+	// scores such as "1.5", "(1.5" or "-inf", or members such as "[a", "(a",
+	// "-" or "+", a "(" making the bound exclusive. With Rev, Start is the
+	// higher bound.
+	Start, Stop string
+	// Rev walks the set from the highest member to the lowest
+	Rev bool
+	// Offset and Count limit score and lex ranges, a negative Count
+	// returning every member after Offset
+	Offset, Count int
+}
+
+// NewZSet returns a sorted set holding members
+func NewZSet(members ...ZMember) *ZSet {
+	z := &ZSet{scores: make(map[string]float64, len(members)), list: newSkiplist()}
+	for _, m := range members {
+		z.set(m.Member, m.Score)
+	}
+
+	return z
+}
+
+// Len returns the number of members of z
+func (z *ZSet) Len() int {
+	return len(z.scores)
+}
+
+// Members returns every member of z in order
+func (z *ZSet) Members() []ZMember {
+	members := make([]ZMember, 0, len(z.scores))
+	for x := z.list.header.level[0].forward; x != nil; x = x.level[0].forward {
+		members = append(members, ZMember{Member: x.member, Score: x.score})
+	}
+
+	return members
+}
+
+// set adds member with score, or moves it to score if it exists
+func (z *ZSet) set(member string, score float64) {
+	if old, exists := z.scores[member]; exists {
+		if old == score {
+			return
+		}
+		z.list.delete(old, member)
+	}
+	z.scores[member] = score
+	z.list.insert(score, member)
+}
+
+func (z *ZSet) remove(member string) bool {
+	score, exists := z.scores[member]
+	if !exists {
+		return false
+	}
+	delete(z.scores, member)
+	z.list.delete(score, member)
+
+	return true
+}
+
+// clone copies z, so that it can be read without holding the lock
+func (z *ZSet) clone() *ZSet {
+	return NewZSet(z.Members()...)
+}
+
+// zset returns the sorted set stored under key, or nil if the key does not
+// exist. The caller must hold a lock.
+func (kvs *KVStore) zset(key string, now time.Time) (*ZSet, error) {
+	keyValue, exists := kvs.store[key]
+	if !exists || keyValue.expired(now) {
+		return nil, nil
+	}
+
+	z, ok := keyValue.Value.(*ZSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return z, nil
+}
+
+// ZAdd sets the scores of members of the sorted set under key, creating it
+// if needed, and returns how many members were added. With the condition NX
+// only new members are added and with XX only existing ones are updated;
+// with the comparison GT or LT existing members are only updated if the new
+// score is greater or less than the current one.
+func (kvs *KVStore) ZAdd(key string, scores map[string]float64, condition, comparison string) (int, error) {
+	if (condition != "" && condition != "NX" && condition != "XX") ||
+		(comparison != "" && comparison != "GT" && comparison != "LT") ||
+		(condition == "NX" && comparison != "") {
+		return 0, ErrInvalidCondition
+	}
+	for _, score := range scores {
+		if math.IsNaN(score) || math.IsInf(score, 0) {
+			return 0, ErrNotFloat
+		}
+	}
+
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	z, err := kvs.zset(key, kvs.expireIfNeeded(key))
+	if err != nil {
+		return 0, err
+	}
+	if z == nil {
+		if condition == "XX" {
+			return 0, nil
+		}
+		z = NewZSet()
+		kvs.put(key, KeyValue{Value: z})
+	}
+
+	added := 0
+	for member, score := range scores {
+		old, exists := z.scores[member]
+		switch {
+		case exists && condition == "NX", !exists && condition == "XX":
+			continue
+		case exists && comparison == "GT" && score <= old, exists && comparison == "LT" && score >= old:
+			continue
+		}
+		if !exists {
+			added++
+		}
+		z.set(member, score)
+	}
+
+	if z.Len() == 0 {
+		kvs.remove(key)
+	}
+
+	return added, nil
+}
+
+// ZIncrBy adds delta to the score of member in the sorted set under key,
+// adding the member with a score of 0 first if needed, and returns the new
+// score
+func (kvs *KVStore) ZIncrBy(key string, delta float64, member string) (float64, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	z, err := kvs.zset(key, kvs.expireIfNeeded(key))
+	if err != nil {
+		return 0, err
+	}
+
+	var score float64
+	if z != nil {
+		score = z.scores[member]
+	}
+	score += delta
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return 0, ErrNotFinite
+	}
+
+	if z == nil {
+		z = NewZSet()
+		kvs.put(key, KeyValue{Value: z})
+	}
+	z.set(member, score)
+
+	return score, nil
+}
+
+// ZRem removes members from the sorted set under key and returns how many
+// existed. The key is deleted along with its last member.
+func (kvs *KVStore) ZRem(key string, members ...string) (int, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	z, err := kvs.zset(key, kvs.expireIfNeeded(key))
+	if err != nil || z == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if z.remove(member) {
+			removed++
+		}
+	}
+	if z.Len() == 0 {
+		kvs.remove(key)
+	}
+
+	return removed, nil
+}
+
+// ZRemRangeByScore removes the members of the sorted set under key with a
+// score between min and max, see ZRange, and returns how many there were
+func (kvs *KVStore) ZRemRangeByScore(key, min, max string) (int, error) {
+	gteMin, lteMax, err := scoreBounds(min, max)
+	if err != nil {
+		return 0, err
+	}
+
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	z, err := kvs.zset(key, kvs.expireIfNeeded(key))
+	if err != nil || z == nil {
+		return 0, err
+	}
+
+	removed := z.list.deleteRange(gteMin, lteMax, func(x *skiplistNode) {
+		delete(z.scores, x.member)
+	})
+	if z.Len() == 0 {
+		kvs.remove(key)
+	}
+
+	return removed, nil
+}
+
+// ZScore returns the score of member in the sorted set under key
+func (kvs *KVStore) ZScore(key, member string) (float64, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	z, err := kvs.zset(key, kvs.now())
+	if err != nil {
+		return 0, err
+	}
+	if z == nil {
+		return 0, ErrKeyNotFound
+	}
+
+	score, exists := z.scores[member]
+	if !exists {
+		return 0, ErrMemberNotFound
+	}
+
+	return score, nil
+}
+
+// ZRank returns the rank of member in the sorted set under key, counted from
+// 0 for the lowest score, or for the highest with rev
+func (kvs *KVStore) ZRank(key, member string, rev bool) (int, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	z, err := kvs.zset(key, kvs.now())
+	if err != nil {
+		return 0, err
+	}
+	if z == nil {
+		return 0, ErrKeyNotFound
+	}
+
+	score, exists := z.scores[member]
+	if !exists {
+		return 0, ErrMemberNotFound
+	}
+
+	rank := z.list.rank(score, member) - 1
+	if rev {
+		rank = z.Len() - 1 - rank
+	}
+
+	return rank, nil
+}
+
+// ZCard returns the number of members of the sorted set under key
+func (kvs *KVStore) ZCard(key string) (int, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	z, err := kvs.zset(key, kvs.now())
+	if err != nil || z == nil {
+		return 0, err
+	}
+
+	return z.Len(), nil
+}
+
+// ZRange returns the members of the sorted set under key selected by r, in
+// order
+func (kvs *KVStore) ZRange(key string, r ZRange) ([]ZMember, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+
+	z, err := kvs.zset(key, kvs.now())
+	if err != nil {
+		return nil, err
+	}
+	if z == nil {
+		z = NewZSet()
+	}
+
+	if r.By == ZByRank {
+		return z.rangeByRank(r)
+	}
+
+	min, max := r.Start, r.Stop
+	if r.Rev {
+		min, max = max, min
+	}
+
+	var gteMin, lteMax func(*skiplistNode) bool
+	switch r.By {
+	case ZByScore:
+		gteMin, lteMax, err = scoreBounds(min, max)
+	case ZByLex:
+		gteMin, lteMax, err = lexBounds(min, max)
+	default:
+		err = ErrInvalidRange
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk from the first member in range, or the last one with rev
+	var x *skiplistNode
+	var inRange func(*skiplistNode) bool
+	if r.Rev {
+		x, inRange = z.list.last(lteMax), gteMin
+	} else {
+		x, inRange = z.list.first(gteMin), lteMax
+	}
+
+	members := []ZMember{}
+	for offset := r.Offset; x != nil && inRange(x) && r.Count != 0; {
+		if offset > 0 {
+			offset--
+		} else {
+			members = append(members, ZMember{Member: x.member, Score: x.score})
+			r.Count--
+		}
+
+		if r.Rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+
+	return members, nil
+}
+
+func (z *ZSet) rangeByRank(r ZRange) ([]ZMember, error) {
+	start, err := strconv.Atoi(r.Start)
+	if err != nil {
+		return nil, ErrNotInteger
+	}
+	stop, err := strconv.Atoi(r.Stop)
+	if err != nil {
+		return nil, ErrNotInteger
+	}
+
+	n := z.Len()
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []ZMember{}, nil
+	}
+
+	members := make([]ZMember, 0, stop-start+1)
+	if r.Rev {
+		for x := z.list.byRank(n - start); len(members) < cap(members); x = x.backward {
+			members = append(members, ZMember{Member: x.member, Score: x.score})
+		}
+	} else {
+		for x := z.list.byRank(start + 1); len(members) < cap(members); x = x.level[0].forward {
+			members = append(members, ZMember{Member: x.member, Score: x.score})
+		}
+	}
+
+	return members, nil
+}
+
+// scoreBounds parses a score range into the predicates telling whether a
+// node is above min and below max
+func scoreBounds(min, max string) (func(*skiplistNode) bool, func(*skiplistNode) bool, error) {
+	minScore, minEx, err := parseScoreBound(min)
+	if err != nil {
+		return nil, nil, err
+	}
+	maxScore, maxEx, err := parseScoreBound(max)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gteMin := func(x *skiplistNode) bool {
+		return x.score > minScore || (!minEx && x.score == minScore)
+	}
+	lteMax := func(x *skiplistNode) bool {
+		return x.score < maxScore || (!maxEx && x.score == maxScore)
+	}
+
+	return gteMin, lteMax, nil
+}
+
+func parseScoreBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false, ErrInvalidRange
+	}
+
+	return score, exclusive, nil
+}
+
+// lexBounds is scoreBounds for a range of members, which is only meaningful
+// if every member has the same score
+func lexBounds(min, max string) (func(*skiplistNode) bool, func(*skiplistNode) bool, error) {
+	if !validLexBound(min) || !validLexBound(max) {
+		return nil, nil, ErrInvalidRange
+	}
+
+	gteMin := func(x *skiplistNode) bool {
+		switch min[0] {
+		case '-':
+			return true
+		case '+':
+			return false
+		case '(':
+			return x.member > min[1:]
+		default:
+			return x.member >= min[1:]
+		}
+	}
+	lteMax := func(x *skiplistNode) bool {
+		switch max[0] {
+		case '-':
+			return false
+		case '+':
+			return true
+		case '(':
+			return x.member < max[1:]
+		default:
+			return x.member <= max[1:]
+		}
+	}
+
+	return gteMin, lteMax, nil
+}
+
+// validLexBound reports whether s is "-", "+", or a member prefixed with "["
+// or "("
+func validLexBound(s string) bool {
+	switch {
+	case s == "-", s == "+":
+		return true
+	case len(s) > 0 && (s[0] == '[' || s[0] == '('):
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,178 @@
+package kvstore
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZAdd(t *testing.T) {
+	kvs := NewKVStore()
+
+	added, err := kvs.ZAdd("lb", map[string]float64{"ada": 10, "bob": 20}, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	tests := []struct {
+		condition, comparison string
+		score                 float64
+		added                 int
+		want                  float64
+	}{
+		{"NX", "", 1, 0, 10},
+		{"XX", "", 11, 0, 11},
+		{"", "GT", 5, 0, 11},
+		{"", "GT", 15, 0, 15},
+		{"XX", "LT", 12, 0, 12},
+		{"", "LT", 13, 0, 12},
+	}
+	for _, test := range tests {
+		added, err := kvs.ZAdd("lb", map[string]float64{"ada": test.score}, test.condition, test.comparison)
+		assert.NoError(t, err)
+		assert.Equal(t, test.added, added)
+		score, _ := kvs.ZScore("lb", "ada")
+		assert.Equal(t, test.want, score, "%s %s %v", test.condition, test.comparison, test.score)
+	}
+
+	// XX never adds, GT and LT still do
+	added, _ = kvs.ZAdd("lb", map[string]float64{"cy": 1}, "XX", "")
+	assert.Equal(t, 0, added)
+	added, _ = kvs.ZAdd("lb", map[string]float64{"cy": 1}, "", "GT")
+	assert.Equal(t, 1, added)
+
+	_, err = kvs.ZAdd("lb", map[string]float64{"ada": 1}, "NX", "GT")
+	assert.Equal(t, ErrInvalidCondition, err)
+	_, err = kvs.ZScore("lb", "dan")
+	assert.Equal(t, ErrMemberNotFound, err)
+	_, err = kvs.ZScore("missing", "dan")
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestZRank(t *testing.T) {
+	kvs := NewKVStore()
+	kvs.ZAdd("lb", map[string]float64{"a": 1, "b": 2, "c": 2, "d": 3}, "", "")
+
+	for i, member := range []string{"a", "b", "c", "d"} {
+		rank, err := kvs.ZRank("lb", member, false)
+		assert.NoError(t, err)
+		assert.Equal(t, i, rank)
+		rank, _ = kvs.ZRank("lb", member, true)
+		assert.Equal(t, 3-i, rank)
+	}
+
+	score, _ := kvs.ZIncrBy("lb", -2.5, "d")
+	assert.Equal(t, 0.5, score)
+	rank, _ := kvs.ZRank("lb", "d", false)
+	assert.Equal(t, 0, rank)
+
+	removed, _ := kvs.ZRem("lb", "a", "x")
+	assert.Equal(t, 1, removed)
+	card, _ := kvs.ZCard("lb")
+	assert.Equal(t, 3, card)
+}
+
+func TestZRange(t *testing.T) {
+	kvs := NewKVStore()
+	kvs.ZAdd("lb", map[string]float64{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}, "", "")
+	kvs.ZAdd("lex", map[string]float64{"a": 0, "b": 0, "c": 0, "d": 0}, "", "")
+
+	tests := []struct {
+		key  string
+		r    ZRange
+		want string
+	}{
+		{"lb", ZRange{Start: "0", Stop: "-1", Count: -1}, "abcde"},
+		{"lb", ZRange{Start: "1", Stop: "2", Count: -1}, "bc"},
+		{"lb", ZRange{Start: "-2", Stop: "100", Count: -1}, "de"},
+		{"lb", ZRange{Start: "0", Stop: "1", Rev: true, Count: -1}, "ed"},
+		{"lb", ZRange{Start: "3", Stop: "1", Count: -1}, ""},
+		{"lb", ZRange{By: ZByScore, Start: "2", Stop: "4", Count: -1}, "bcd"},
+		{"lb", ZRange{By: ZByScore, Start: "(2", Stop: "+inf", Count: -1}, "cde"},
+		{"lb", ZRange{By: ZByScore, Start: "-inf", Stop: "(3", Count: -1}, "ab"},
+		{"lb", ZRange{By: ZByScore, Start: "4", Stop: "2", Rev: true, Count: -1}, "dcb"},
+		{"lb", ZRange{By: ZByScore, Start: "-inf", Stop: "+inf", Offset: 1, Count: 2}, "bc"},
+		{"lb", ZRange{By: ZByScore, Start: "+inf", Stop: "-inf", Rev: true, Offset: 1, Count: 2}, "dc"},
+		{"lb", ZRange{By: ZByScore, Start: "4", Stop: "2", Count: -1}, ""},
+		{"lex", ZRange{By: ZByLex, Start: "-", Stop: "+", Count: -1}, "abcd"},
+		{"lex", ZRange{By: ZByLex, Start: "[b", Stop: "(d", Count: -1}, "bc"},
+		{"lex", ZRange{By: ZByLex, Start: "+", Stop: "(b", Rev: true, Count: -1}, "dc"},
+		{"missing", ZRange{Start: "0", Stop: "-1", Count: -1}, ""},
+	}
+	for _, test := range tests {
+		members, err := kvs.ZRange(test.key, test.r)
+		assert.NoError(t, err)
+		got := ""
+		for _, m := range members {
+			got += m.Member
+		}
+		assert.Equal(t, test.want, got, "%s %+v", test.key, test.r)
+	}
+
+	_, err := kvs.ZRange("lb", ZRange{By: ZByScore, Start: "x", Stop: "1"})
+	assert.Equal(t, ErrInvalidRange, err)
+	_, err = kvs.ZRange("lb", ZRange{By: ZByLex, Start: "a", Stop: "+"})
+	assert.Equal(t, ErrInvalidRange, err)
+	_, err = kvs.ZRange("lb", ZRange{Start: "x", Stop: "1"})
+	assert.Equal(t, ErrNotInteger, err)
+
+	removed, err := kvs.ZRemRangeByScore("lb", "(1", "4")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	members, _ := kvs.ZRange("lb", ZRange{Start: "0", Stop: "-1", Count: -1})
+	assert.Equal(t, []ZMember{{"a", 1}, {"e", 5}}, members)
+
+	// Removing the last member deletes the key
+	kvs.ZRemRangeByScore("lb", "-inf", "+inf")
+	assert.False(t, kvs.Exists("lb"))
+}
+
+// The skiplist must agree with a sorted slice after any sequence of writes
+func TestZSetRandom(t *testing.T) {
+	z := NewZSet()
+	scores := make(map[string]float64)
+
+	for i := 0; i < 5000; i++ {
+		member := strconv.Itoa(rand.Intn(300))
+		if rand.Intn(4) == 0 {
+			z.remove(member)
+			delete(scores, member)
+		} else {
+			score := float64(rand.Intn(50))
+			z.set(member, score)
+			scores[member] = score
+		}
+	}
+
+	want := make([]ZMember, 0, len(scores))
+	for member, score := range scores {
+		want = append(want, ZMember{Member: member, Score: score})
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].Score < want[j].Score || (want[i].Score == want[j].Score && want[i].Member < want[j].Member)
+	})
+
+	require.Equal(t, want, z.Members())
+	for i, m := range want {
+		assert.Equal(t, i+1, z.list.rank(m.Score, m.Member))
+		assert.Equal(t, m.Member, z.list.byRank(i+1).member)
+	}
+	assert.Nil(t, z.list.byRank(len(want)+1))
+}
+
+func TestZSetWrongType(t *testing.T) {
+	kvs := NewKVStore()
+	mustSet(t, kvs, "string", "v", time.Time{}, "", false)
+	kvs.ZAdd("zset", map[string]float64{"m": 1}, "", "")
+
+	_, err := kvs.ZAdd("string", map[string]float64{"m": 1}, "", "")
+	assert.Equal(t, ErrWrongType, err)
+	_, err = kvs.Get("zset")
+	assert.Equal(t, ErrWrongType, err)
+	_, err = kvs.SMembers("zset")
+	assert.Equal(t, ErrWrongType, err)
+}
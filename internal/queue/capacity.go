@@ -0,0 +1,190 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrQueueFull = errors.New("queue is full")
+
+// A queue given a max length only takes the pushes of producers as far as
+// it has room, the rest being handled as its overflow policy says. Values
+// scheduled for the queue take room from the time they are scheduled, so
+// that it is not taken over its max length as they are pushed, short of
+// dropping older values. Values that come back to a queue rather than being
+// pushed to it, as redelivered, requeued or restored ones, are always
+// taken.
+
+// Overflow is what happens to a push that does not fit in its queue
+type Overflow string
+
+const (
+	// The push fails with ErrQueueFull, which is the default
+	OverflowReject Overflow = "reject"
+	// The push fails with ErrQueueFull as well, the producer waiting for
+	// room with WaitRoom before pushing again
+	OverflowBlock Overflow = "block"
+	// The values pushed longest ago are dropped to make room
+	OverflowDropOldest Overflow = "drop-oldest"
+)
+
+func (o Overflow) valid() bool {
+	switch o {
+	case "", OverflowReject, OverflowBlock, OverflowDropOldest:
+		return true
+	}
+	return false
+}
+
+// String returns the name of the policy, reject for the zero value
+func (o Overflow) String() string {
+	if o == "" {
+		return string(OverflowReject)
+	}
+	return string(o)
+}
+
+// room checks that n more values fit in the queue under key, unless its
+// policy is to drop values, and counts the push as rejected if they do not.
+// Pushes that can wait for room are not counted until they give up. The
+// caller must hold the lock.
+func (q *Queue) room(key string, n int) error {
+	c := q.configs[key]
+	if c.MaxLen == 0 || c.Overflow == OverflowDropOldest || q.held(key)+n <= c.MaxLen {
+		return nil
+	}
+
+	if c.Overflow != OverflowBlock {
+		q.count(key).rejected++
+	}
+	return ErrQueueFull
+}
+
+// held returns the number of values taking room in the queue under key,
+// those scheduled for it included. The caller must hold the lock.
+func (q *Queue) held(key string) int {
+	return q.length(key) + q.pending[key]
+}
+
+// shrink drops the values pushed longest ago while the queue under key is
+// over its max length, if its policy is to drop values. The caller must
+// hold the lock.
+func (q *Queue) shrink(key string) {
+	c := q.configs[key]
+	if c.MaxLen == 0 || c.Overflow != OverflowDropOldest {
+		return
+	}
+
+	for q.length(key) > c.MaxLen {
+		q.dropOldest(key)
+		q.count(key).dropped++
+	}
+}
+
+// dropOldest removes the value pushed longest ago from the queue under key,
+// which is not empty, wherever it is. The caller must hold the lock.
+func (q *Queue) dropOldest(key string) {
+	i, _, _ := q.oldest(key)
+	if pq, ok := q.priorities[key]; ok {
+		heap.Remove(pq, i)
+		if pq.len() == 0 {
+			delete(q.priorities, key)
+		}
+		return
+	}
+
+	queue := q.queues[key]
+	queue.remove(i)
+	if queue.len() == 0 {
+		delete(q.queues, key)
+	}
+}
+
+// WaitRoom waits until n more values fit in the queue under key, for pushes
+// that failed with ErrQueueFull to try again. It fails with ErrQueueFull
+// right away if the values do not fit and the policy of the queue is not to
+// block, or if they never fit or its block timeout goes by first, counting
+// the push as rejected, or with the error of ctx if it is done first.
+func (q *Queue) WaitRoom(ctx context.Context, key string, n int) error {
+	q.lock()
+	c := q.configs[key]
+	if c.MaxLen == 0 || c.Overflow == OverflowDropOldest || q.held(key)+n <= c.MaxLen {
+		q.mu.Unlock()
+		return nil
+	}
+	if c.Overflow != OverflowBlock {
+		// Already counted by the push
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	if n > c.MaxLen {
+		q.count(key).rejected++
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	room := make(chan struct{})
+	q.producers[key] = append(q.producers[key], room)
+	q.mu.Unlock()
+
+	var expired <-chan time.Time
+	if c.BlockTimeout > 0 {
+		timer := time.NewTimer(c.BlockTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case <-room:
+		return nil
+	case <-expired:
+		err = ErrQueueFull
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case <-room:
+		// Freed as it gave up
+		return nil
+	default:
+	}
+	producers := q.producers[key]
+	for i, ch := range producers {
+		if ch == room {
+			producers = append(producers[:i], producers[i+1:]...)
+			break
+		}
+	}
+	if len(producers) == 0 {
+		delete(q.producers, key)
+	} else {
+		q.producers[key] = producers
+	}
+	if err == ErrQueueFull {
+		q.count(key).rejected++
+	}
+
+	return err
+}
+
+// freed wakes the pushes waiting for room in the queue under key, as values
+// were taken out of it or its settings changed. They all check again, as
+// many as fit getting in. The caller must hold the lock.
+func (q *Queue) freed(key string) {
+	producers, ok := q.producers[key]
+	if !ok {
+		return
+	}
+
+	for _, room := range producers {
+		close(room)
+	}
+	delete(q.producers, key)
+}
@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func limit(t *testing.T, q *Queue, key string, maxLen int, overflow Overflow, timeout time.Duration) {
+	t.Helper()
+	_, err := q.Configure(key, ConfigUpdate{MaxLen: &maxLen, Overflow: &overflow, BlockTimeout: &timeout})
+	require.NoError(t, err)
+}
+
+func TestOverflowReject(t *testing.T) {
+	q := NewQueue()
+	limit(t, q, "q", 2, OverflowReject, 0)
+
+	assert.NoError(t, q.QPush("q", "a"))
+	_, err := q.RPush("q", "b", "c")
+	assert.Equal(t, ErrQueueFull, err)
+	_, err = q.LPush("q", "b")
+	assert.NoError(t, err)
+	_, err = q.PushPriority("q", 1, "c")
+	assert.Equal(t, ErrQueueFull, err)
+	_, err = q.LInsert("q", true, "a", "c")
+	assert.Equal(t, ErrQueueFull, err)
+	assert.Equal(t, ErrQueueFull, q.QPush("q", "c"))
+
+	// Values coming back are always taken
+	q.Append("q", "c")
+	assert.Equal(t, []interface{}{"b", "a", "c"}, q.LRange("q", 0, -1))
+	assert.Equal(t, uint64(4), q.Stats("q").Rejected)
+
+	// Raising the limit lets pushes through
+	limit(t, q, "q", 4, OverflowReject, 0)
+	n, err := q.RPush("q", "d")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	q := NewQueue()
+	limit(t, q, "q", 3, OverflowDropOldest, 0)
+
+	q.Append("q", "a", "b")
+	time.Sleep(time.Millisecond)
+	n, err := q.RPush("q", "c", "d")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []interface{}{"b", "c", "d"}, q.LRange("q", 0, -1))
+
+	// Values pushed at the head drop the older ones behind them
+	time.Sleep(time.Millisecond)
+	q.LPush("q", "e")
+	assert.Equal(t, []interface{}{"e", "c", "d"}, q.LRange("q", 0, -1))
+	time.Sleep(time.Millisecond)
+	q.RPush("q", "x")
+	assert.Equal(t, []interface{}{"e", "d", "x"}, q.LRange("q", 0, -1))
+
+	// A push longer than the queue only keeps its last values
+	n, _ = q.RPush("q", "f", "g", "h", "i")
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []interface{}{"g", "h", "i"}, q.LRange("q", 0, -1))
+	assert.Equal(t, uint64(7), q.Stats("q").Dropped)
+
+	// Priority queues drop the value pushed longest ago, whatever its
+	// priority
+	time.Sleep(time.Millisecond)
+	q.PushPriority("q", 5, "p")
+	assert.Equal(t, []interface{}{"p", "h", "i"}, q.LRange("q", 0, -1))
+}
+
+// With values pushed at both ends, the oldest is in the middle
+func TestOverflowDropOldestMixed(t *testing.T) {
+	q := NewQueue()
+	limit(t, q, "q", 2, OverflowDropOldest, 0)
+
+	q.RPush("q", "a")
+	time.Sleep(time.Millisecond)
+	q.LPush("q", "b")
+	time.Sleep(time.Millisecond)
+	q.RPush("q", "c")
+	assert.Equal(t, []interface{}{"b", "c"}, q.LRange("q", 0, -1))
+
+	// and so is the value inserted last
+	limit(t, q, "q", 3, OverflowDropOldest, 0)
+	q.LInsert("q", false, "b", "d")
+	time.Sleep(time.Millisecond)
+	q.LPush("q", "e")
+	assert.Equal(t, []interface{}{"e", "d", "c"}, q.LRange("q", 0, -1))
+}
+
+// Scheduled values take room before they are pushed
+func TestOverflowScheduled(t *testing.T) {
+	q := NewQueue()
+	limit(t, q, "q", 1, OverflowReject, 0)
+
+	at := time.Now().Add(20 * time.Millisecond)
+	assert.NoError(t, q.Schedule("q", at, "a"))
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, ErrQueueFull, q.Schedule("q", at, "b"))
+	}
+	_, err := q.RPush("q", "c")
+	assert.Equal(t, ErrQueueFull, err)
+	assert.Equal(t, ErrQueueFull, q.Schedule("q", time.Time{}, "d"))
+
+	require.Eventually(t, func() bool { return q.LLen("q") == 1 }, time.Second, time.Millisecond)
+	assert.Empty(t, q.pending)
+	assert.Equal(t, ErrQueueFull, q.Schedule("q", at, "b"))
+
+	// or drop older values once pushed
+	limit(t, q, "q", 1, OverflowDropOldest, 0)
+	at = time.Now().Add(20 * time.Millisecond)
+	for _, v := range []string{"e", "f", "g"} {
+		assert.NoError(t, q.Schedule("q", at, v))
+	}
+	require.Eventually(t, func() bool { return q.Stats("q").Dropped == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, []interface{}{"g"}, q.LRange("q", 0, -1))
+}
+
+func TestOverflowBlock(t *testing.T) {
+	q := NewQueue()
+	limit(t, q, "q", 1, OverflowBlock, 20*time.Millisecond)
+	q.Append("q", "a")
+
+	// A blocking queue refuses pushes as well, the wait being left to the
+	// producer, and only counts them once it gives up
+	_, err := q.RPush("q", "b")
+	assert.Equal(t, ErrQueueFull, err)
+	assert.Equal(t, uint64(0), q.Stats("q").Rejected)
+	assert.Equal(t, ErrQueueFull, q.WaitRoom(context.Background(), "q", 1))
+	assert.Equal(t, ErrQueueFull, q.WaitRoom(context.Background(), "q", 2))
+	assert.Equal(t, uint64(2), q.Stats("q").Rejected)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, q.WaitRoom(ctx, "q", 1))
+
+	// A pop makes room
+	limit(t, q, "q", 1, OverflowBlock, 0)
+	result := make(chan error, 1)
+	go func() { result <- q.WaitRoom(context.Background(), "q", 1) }()
+	require.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.producers["q"]) == 1
+	}, time.Second, time.Millisecond)
+
+	q.Pop("q")
+	assert.NoError(t, <-result)
+	_, err = q.RPush("q", "b")
+	assert.NoError(t, err)
+	assert.Empty(t, q.producers)
+
+	// Other policies do not wait
+	limit(t, q, "q", 1, OverflowReject, 0)
+	assert.Equal(t, ErrQueueFull, q.WaitRoom(context.Background(), "q", 1))
+	limit(t, q, "q", 1, OverflowDropOldest, 0)
+	assert.NoError(t, q.WaitRoom(context.Background(), "q", 1))
+}
+
+func TestMoveToFullQueue(t *testing.T) {
+	q := NewQueue()
+	limit(t, q, "dst", 1, OverflowReject, 0)
+	q.Append("src", "a", "b")
+
+	_, err := q.Move("src", "dst", false, false)
+	assert.NoError(t, err)
+	_, err = q.Move("src", "dst", false, false)
+	assert.Equal(t, ErrQueueFull, err)
+	assert.Equal(t, []interface{}{"b"}, q.LRange("src", 0, -1))
+
+	// A full queue can still be rotated
+	_, err = q.Move("dst", "dst", false, false)
+	assert.NoError(t, err)
+}
+
+func TestConfigureCapacity(t *testing.T) {
+	q := NewQueue()
+	n, overflow, timeout := -1, Overflow("newest"), -time.Second
+
+	_, err := q.Configure("q", ConfigUpdate{MaxLen: &n})
+	assert.Equal(t, ErrNegativeMaxLen, err)
+	_, err = q.Configure("q", ConfigUpdate{Overflow: &overflow})
+	assert.Equal(t, ErrUnknownOverflow, err)
+	_, err = q.Configure("q", ConfigUpdate{BlockTimeout: &timeout})
+	assert.Equal(t, ErrNegativeTimeout, err)
+	assert.Empty(t, q.configs)
+
+	// The default policy is kept as the zero value
+	assert.Equal(t, "reject", Config{}.Overflow.String())
+	limit(t, q, "q", 0, OverflowReject, 0)
+	assert.Empty(t, q.configs)
+}
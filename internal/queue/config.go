@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoDeadLetterQueue   = errors.New("max attempts needs a dead-letter queue")
+	ErrOwnDeadLetterQueue  = errors.New("a queue cannot be its own dead-letter queue")
+	ErrNegativeMaxAttempts = errors.New("max attempts must not be negative")
+	ErrNegativeMaxLen      = errors.New("max length must not be negative")
+	ErrUnknownOverflow     = errors.New("overflow policy must be reject, block or drop-oldest")
+	ErrNegativeTimeout     = errors.New("block timeout must not be negative")
+)
+
+// Config holds the settings of a queue, the zero value being the default
+type Config struct {
+	// Reserved messages that failed MaxAttempts deliveries are moved to the
+	// DeadLetter queue instead of being delivered again. 0 means no limit.
+	MaxAttempts int
+	DeadLetter  string
+	// Pushes that would take the queue over MaxLen values are handled as
+	// Overflow says, waiting up to BlockTimeout for OverflowBlock, forever
+	// if it is 0. MaxLen 0 means no limit.
+	MaxLen       int
+	Overflow     Overflow
+	BlockTimeout time.Duration
+}
+
+// ConfigUpdate holds the settings to change in a Config, those left nil
+// staying as they are
+type ConfigUpdate struct {
+	MaxAttempts  *int
+	DeadLetter   *string
+	MaxLen       *int
+	Overflow     *Overflow
+	BlockTimeout *time.Duration
+}
+
+// Empty reports whether u changes nothing
+func (u ConfigUpdate) Empty() bool {
+	return u.MaxAttempts == nil && u.DeadLetter == nil && u.MaxLen == nil && u.Overflow == nil && u.BlockTimeout == nil
+}
+
+func (c Config) validate(key string) error {
+	switch {
+	case c.MaxAttempts < 0:
+		return ErrNegativeMaxAttempts
+	case c.MaxAttempts > 0 && c.DeadLetter == "":
+		return ErrNoDeadLetterQueue
+	case c.DeadLetter == key:
+		return ErrOwnDeadLetterQueue
+	case c.MaxLen < 0:
+		return ErrNegativeMaxLen
+	case !c.Overflow.valid():
+		return ErrUnknownOverflow
+	case c.BlockTimeout < 0:
+		return ErrNegativeTimeout
+	}
+
+	return nil
+}
+
+// Configure applies u to the settings of the queue under key and returns
+// them. The settings are kept even while the queue is empty.
+func (q *Queue) Configure(key string, u ConfigUpdate) (Config, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c := q.configs[key]
+	if u.MaxAttempts != nil {
+		c.MaxAttempts = *u.MaxAttempts
+	}
+	if u.DeadLetter != nil {
+		c.DeadLetter = *u.DeadLetter
+	}
+	if u.MaxLen != nil {
+		c.MaxLen = *u.MaxLen
+	}
+	if u.Overflow != nil {
+		c.Overflow = *u.Overflow
+	}
+	// Rejecting is the default, kept as no policy so that a queue set back
+	// to it has no settings
+	if c.Overflow == OverflowReject {
+		c.Overflow = ""
+	}
+	if u.BlockTimeout != nil {
+		c.BlockTimeout = *u.BlockTimeout
+	}
+	if err := c.validate(key); err != nil {
+		return q.configs[key], err
+	}
+
+	if c == (Config{}) {
+		delete(q.configs, key)
+		q.forget(key)
+	} else {
+		q.configs[key] = c
+	}
+	// A higher limit or another policy may let blocked pushes through
+	q.freed(key)
+
+	return c, nil
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrMessageNotFound = errors.New("no such message")
+
+// Why a delivery failed
+const (
+	ReasonExpired = "lease expired"
+	ReasonNacked  = "nacked"
+)
+
+// DeadLetter is a message moved to a dead-letter queue once it failed the
+// max attempts of its queue
+type DeadLetter struct {
+	// The queue and ID of the message, as in jobs/1700000000000-0, as
+	// messages from different queues may have the same ID
+	ID    string
+	Value interface{}
+	// Key of the queue the message was reserved from
+	Queue    string
+	Attempts int
+	// Why the last delivery failed
+	Reason     string
+	EnqueuedAt time.Time
+	DeadAt     time.Time
+}
+
+// deadLetter moves m, reserved from the queue under key, to the dead-letter
+// queue dlq. The caller must hold the lock.
+func (q *Queue) deadLetter(key, dlq string, m *lease, now time.Time) {
+	q.deadLetters[dlq] = append(q.deadLetters[dlq], DeadLetter{
+		ID:         key + "/" + m.ID,
+		Value:      m.Value,
+		Queue:      key,
+		Attempts:   m.Attempts,
+		Reason:     m.reason,
+		EnqueuedAt: m.EnqueuedAt,
+		DeadAt:     now,
+	})
+}
+
+// DeadLetters returns the messages of the dead-letter queue dlq from start
+// to stop, as for LRange, oldest first
+func (q *Queue) DeadLetters(dlq string, start, stop int) []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := q.deadLetters[dlq]
+	start, stop = clampRange(len(letters), start, stop)
+
+	return append([]DeadLetter{}, letters[start:stop]...)
+}
+
+// DeadLetter returns the message of the dead-letter queue dlq with the
+// given ID
+func (q *Queue) DeadLetter(dlq, id string) (DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, d := range q.deadLetters[dlq] {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+
+	return DeadLetter{}, ErrMessageNotFound
+}
+
+// Requeue moves the messages of the dead-letter queue dlq with the given
+// IDs, or all of them if none is given, back to the tail of the queue they
+// came from, and returns how many there were. They start over with no
+// attempts.
+func (q *Queue) Requeue(dlq string, ids ...string) int {
+	q.lock()
+	defer q.mu.Unlock()
+
+	removed := q.removeDeadLetters(dlq, ids)
+	for _, d := range removed {
+		q.pushBack(d.Queue, []interface{}{d.Value})
+	}
+
+	return len(removed)
+}
+
+// Purge deletes the messages of the dead-letter queue dlq with the given
+// IDs, or all of them if none is given, and returns how many there were
+func (q *Queue) Purge(dlq string, ids ...string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.removeDeadLetters(dlq, ids))
+}
+
+// removeDeadLetters removes the messages of the dead-letter queue dlq with
+// the given IDs, or all of them if ids is empty, and returns them in order.
+// The caller must hold the lock.
+func (q *Queue) removeDeadLetters(dlq string, ids []string) []DeadLetter {
+	letters := q.deadLetters[dlq]
+	if len(ids) == 0 {
+		delete(q.deadLetters, dlq)
+		return letters
+	}
+
+	var removed []DeadLetter
+	kept := letters[:0]
+	for _, d := range letters {
+		if contains(ids, d.ID) {
+			removed = append(removed, d)
+		} else {
+			kept = append(kept, d)
+		}
+	}
+
+	for i := len(kept); i < len(letters); i++ {
+		letters[i] = DeadLetter{}
+	}
+
+	if len(kept) == 0 {
+		delete(q.deadLetters, dlq)
+	} else {
+		q.deadLetters[dlq] = kept
+	}
+
+	return removed
+}
@@ -0,0 +1,262 @@
+package queue
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// Values can be scheduled to be pushed later rather than right away. They
+// wait in a heap ordered by due time and are promoted to the tail of their
+// queue once due, by a timer so that blocked pops are woken on time, and by
+// every operation before it looks at the queues so that none sees a queue
+// without the values already due. A caller logging every change to the
+// queues can hold due values instead, to push them itself under its own
+// locks.
+
+// Delayed is a value scheduled to be pushed to its queue at At
+type Delayed struct {
+	Value interface{}
+	At    time.Time
+}
+
+// scheduled is a value waiting in the heap
+type scheduled struct {
+	key string
+	at  time.Time
+	// Order of scheduling, so that values due at the same time are pushed
+	// in the order they were scheduled
+	seq uint64
+	item
+}
+
+// schedule is a min-heap of scheduled values by due time
+type schedule []*scheduled
+
+func (s schedule) Len() int { return len(s) }
+
+func (s schedule) Less(i, j int) bool {
+	if !s[i].at.Equal(s[j].at) {
+		return s[i].at.Before(s[j].at)
+	}
+	return s[i].seq < s[j].seq
+}
+
+func (s schedule) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s *schedule) Push(x interface{}) { *s = append(*s, x.(*scheduled)) }
+
+func (s *schedule) Pop() interface{} {
+	old := *s
+	v := old[len(old)-1]
+	old[len(old)-1] = nil
+	*s = old[:len(old)-1]
+	return v
+}
+
+// Schedule pushes values to the tail of the queue under key at at, or right
+// away if at has passed. The values are only seen by pops once pushed, but
+// take room in the queue from now on, so it fails with ErrQueueFull as a
+// push would if they do not fit.
+func (q *Queue) Schedule(key string, at time.Time, values ...interface{}) error {
+	q.lock()
+	defer q.mu.Unlock()
+
+	if err := q.room(key, len(values)); err != nil {
+		return err
+	}
+	q.schedule(key, at, values)
+
+	return nil
+}
+
+// schedule is Schedule for values that are always taken. The caller must
+// hold the lock.
+func (q *Queue) schedule(key string, at time.Time, values []interface{}) {
+	now := time.Now()
+	if !at.After(now) && !q.hold {
+		q.pushBack(key, values)
+		q.shrink(key)
+		return
+	}
+
+	for _, v := range values {
+		q.delayedSeq++
+		heap.Push(&q.delayed, &scheduled{key: key, at: at, seq: q.delayedSeq, item: newItem(v, now)})
+	}
+	q.pending[key] += len(values)
+	q.arm()
+}
+
+// lock takes the lock and promotes the values that are due, unless they are
+// held, so that the caller sees every one of them in its queue
+func (q *Queue) lock() {
+	q.mu.Lock()
+	if !q.hold {
+		q.promote(time.Now())
+	}
+}
+
+// HoldDue keeps scheduled values out of their queue once due until Promote
+// pushes them, calling onDue, unless it is nil, with the keys of the values
+// that are due. It is meant for callers logging when values are pushed, as
+// under their own locks, and for replaying such a log.
+func (q *Queue) HoldDue(onDue func(keys []string)) {
+	q.mu.Lock()
+	q.hold, q.onDue = true, onDue
+	q.mu.Unlock()
+
+	q.fire()
+}
+
+// Promote pushes the values scheduled for key right away in the order they
+// are due, only those due by until unless it is zero and no more than n
+// unless it is 0, and returns how many it pushed
+func (q *Queue) Promote(key string, n int, until time.Time) int {
+	q.lock()
+	defer q.mu.Unlock()
+
+	var due schedule
+	for _, s := range q.delayed {
+		if s.key == key && (until.IsZero() || !s.at.After(until)) {
+			due = append(due, s)
+		}
+	}
+	sort.Sort(due)
+	if n > 0 && len(due) > n {
+		due = due[:n]
+	}
+	if len(due) == 0 {
+		return 0
+	}
+
+	promoted := make(map[*scheduled]bool, len(due))
+	for _, s := range due {
+		promoted[s] = true
+		q.push(key, s.item)
+	}
+	q.unscheduleIf(func(s *scheduled) bool { return promoted[s] })
+	q.shrink(key)
+	q.serve(key)
+	q.arm()
+
+	return len(due)
+}
+
+// fire promotes the values that are due, or tells onDue about them if they
+// are held
+func (q *Queue) fire() {
+	q.mu.Lock()
+	if !q.hold {
+		q.promote(time.Now())
+		q.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	var keys []string
+	for _, s := range q.delayed {
+		if !s.at.After(now) && !contains(keys, s.key) {
+			keys = append(keys, s.key)
+		}
+	}
+	onDue := q.onDue
+	q.mu.Unlock()
+
+	if onDue != nil && len(keys) > 0 {
+		onDue(keys)
+	}
+}
+
+// promote pushes the values due at now to their queue, serving the pops
+// blocked on them. The caller must hold the lock.
+func (q *Queue) promote(now time.Time) {
+	if len(q.delayed) == 0 || q.delayed[0].at.After(now) {
+		return
+	}
+
+	var keys []string
+	for len(q.delayed) > 0 && !q.delayed[0].at.After(now) {
+		s := heap.Pop(&q.delayed).(*scheduled)
+		q.push(s.key, s.item)
+		q.unpend(s.key)
+		if !contains(keys, s.key) {
+			keys = append(keys, s.key)
+		}
+	}
+	for _, key := range keys {
+		q.shrink(key)
+		q.serve(key)
+	}
+
+	q.arm()
+}
+
+// arm sets the timer to promote the next value when it is due. The caller
+// must hold the lock.
+func (q *Queue) arm() {
+	if len(q.delayed) == 0 {
+		if q.timer != nil {
+			q.timer.Stop()
+		}
+		return
+	}
+
+	d := time.Until(q.delayed[0].at)
+	if q.timer == nil {
+		q.timer = time.AfterFunc(d, q.fire)
+		return
+	}
+	q.timer.Reset(d)
+}
+
+// delayedValues returns the values scheduled for each key in the order
+// they are due. The caller must hold the lock.
+func (q *Queue) delayedValues() map[string][]Delayed {
+	ordered := append(schedule{}, q.delayed...)
+	heap.Init(&ordered)
+
+	values := make(map[string][]Delayed)
+	for len(ordered) > 0 {
+		s := heap.Pop(&ordered).(*scheduled)
+		values[s.key] = append(values[s.key], Delayed{Value: s.value, At: s.at})
+	}
+
+	return values
+}
+
+// unschedule drops the values scheduled for the keys in keys. The caller
+// must hold the lock.
+func (q *Queue) unschedule(keys map[string][]Delayed) {
+	q.unscheduleIf(func(s *scheduled) bool {
+		_, ok := keys[s.key]
+		return ok
+	})
+}
+
+// unscheduleIf drops the scheduled values for which drop returns true. The
+// caller must hold the lock.
+func (q *Queue) unscheduleIf(drop func(s *scheduled) bool) {
+	kept := q.delayed[:0]
+	for _, s := range q.delayed {
+		if drop(s) {
+			q.unpend(s.key)
+		} else {
+			kept = append(kept, s)
+		}
+	}
+	for i := len(kept); i < len(q.delayed); i++ {
+		q.delayed[i] = nil
+	}
+
+	q.delayed = kept
+	heap.Init(&q.delayed)
+}
+
+// unpend counts a value scheduled for key as no longer waiting. The caller
+// must hold the lock.
+func (q *Queue) unpend(key string) {
+	if q.pending[key]--; q.pending[key] == 0 {
+		delete(q.pending, key)
+	}
+}
@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleHidesValuesUntilDue(t *testing.T) {
+	q := NewQueue()
+	begin := time.Now()
+	q.Schedule("q", begin.Add(50*time.Millisecond), "later")
+	q.Schedule("q", begin.Add(-time.Second), "now")
+
+	value, err := q.Pop("q")
+	assert.NoError(t, err)
+	assert.Equal(t, "now", value)
+	_, err = q.Pop("q")
+	assert.Equal(t, ErrQueueEmpty, err)
+	assert.Equal(t, 0, q.LLen("q"))
+
+	// Blocked pops are woken once the value is due
+	_, value, err = q.BPop(context.Background(), []string{"q"}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "later", value)
+	assert.GreaterOrEqual(t, time.Since(begin), 50*time.Millisecond)
+}
+
+func TestScheduleOrder(t *testing.T) {
+	q := NewQueue()
+	at := time.Now().Add(time.Hour)
+	q.Schedule("q", at, "b", "c")
+	q.Schedule("q", at.Add(-time.Minute), "a")
+	q.Schedule("other", at, "x")
+
+	assert.Equal(t, map[string][]Delayed{
+		"q":     {{Value: "a", At: at.Add(-time.Minute)}, {Value: "b", At: at}, {Value: "c", At: at}},
+		"other": {{Value: "x", At: at}},
+	}, q.Dump().Delayed)
+
+	// Values due at the same time are pushed in the order they were
+	// scheduled
+	q.mu.Lock()
+	q.promote(at)
+	q.mu.Unlock()
+	assert.Equal(t, []interface{}{"a", "b", "c"}, q.LRange("q", 0, -1))
+	assert.Empty(t, q.delayed)
+}
+
+func TestDumpLoadDelayed(t *testing.T) {
+	q := NewQueue()
+	at := time.Now().Add(time.Hour)
+	q.Append("q", "a")
+	q.Schedule("q", at, "b")
+
+	loaded := NewQueue()
+	loaded.Schedule("q", at, "dropped")
+	s := q.Dump()
+	s.Delayed["q"] = append(s.Delayed["q"], Delayed{Value: "due", At: time.Now().Add(-time.Second)})
+	loaded.Load(s)
+
+	// Values due by the time they are loaded are pushed right away
+	assert.Equal(t, []interface{}{"a", "due"}, loaded.LRange("q", 0, -1))
+	require.Len(t, loaded.delayed, 1)
+	assert.Equal(t, "b", loaded.delayed[0].value)
+}
+
+func TestHoldDue(t *testing.T) {
+	q := NewQueue()
+	due := make(chan []string, 1)
+	q.HoldDue(func(keys []string) { due <- keys })
+
+	begin := time.Now()
+	q.Schedule("q", begin.Add(-time.Second), "a")
+	q.Schedule("q", begin.Add(20*time.Millisecond), "b")
+	q.Schedule("q", begin.Add(time.Hour), "c")
+
+	// Held values are left out of the queue until promoted, even once due
+	_, err := q.Pop("q")
+	assert.Equal(t, ErrQueueEmpty, err)
+	select {
+	case keys := <-due:
+		assert.Equal(t, []string{"q"}, keys)
+	case <-time.After(5 * time.Second):
+		t.Fatal("onDue was not called")
+	}
+	assert.Equal(t, 0, q.LLen("q"))
+
+	time.Sleep(time.Until(begin.Add(20 * time.Millisecond)))
+	assert.Equal(t, 2, q.Promote("q", 0, time.Now()))
+	assert.Equal(t, []interface{}{"a", "b"}, q.LRange("q", 0, -1))
+
+	// Without a time, values are promoted whenever they are due
+	assert.Equal(t, 1, q.Promote("q", 1, time.Time{}))
+	assert.Equal(t, []interface{}{"a", "b", "c"}, q.LRange("q", 0, -1))
+	assert.Equal(t, 0, q.Promote("q", 0, time.Time{}))
+	assert.Empty(t, q.pending)
+}
@@ -0,0 +1,221 @@
+package queue
+
+import "time"
+
+// chunkSize is the number of values held by each chunk of a deque
+const chunkSize = 64
+
+type chunk [chunkSize]item
+
+// item is a value of a queue with the time it was pushed
+type item struct {
+	value interface{}
+	// In nanoseconds since the epoch, which takes a third of the space of
+	// a time.Time
+	pushedAt int64
+	// Only used by priority queues
+	priority int64
+}
+
+func newItem(value interface{}, now time.Time) item {
+	return item{value: value, pushedAt: now.UnixNano()}
+}
+
+func (it item) pushed() time.Time {
+	return time.Unix(0, it.pushedAt)
+}
+
+// deque is a double-ended queue of values stored in fixed-size chunks. The
+// chunks are kept in a ring, so pushing and popping at either end is
+// amortized O(1), and a chunk is released as soon as its last value is
+// popped, so a long-lived queue only holds memory for what it contains.
+type deque struct {
+	// Ring of chunks whose length is zero or a power of two. Only the
+	// chunks holding values are allocated.
+	chunks []*chunk
+	// Index in chunks of the chunk holding the first value
+	first int
+	// Position of the first value in its chunk
+	off int
+	n   int
+	// Set once a value is put out of the order the values were pushed in,
+	// as by LPUSH, the first value being the oldest until then
+	unordered bool
+}
+
+func (d *deque) len() int {
+	return d.n
+}
+
+// used returns the number of chunks holding values
+func (d *deque) used() int {
+	if d.n == 0 {
+		return 0
+	}
+	return (d.off + d.n + chunkSize - 1) / chunkSize
+}
+
+// chunk returns the i-th chunk from the first one, allocating it if needed
+func (d *deque) chunk(i int) *chunk {
+	idx := (d.first + i) & (len(d.chunks) - 1)
+	if d.chunks[idx] == nil {
+		d.chunks[idx] = new(chunk)
+	}
+	return d.chunks[idx]
+}
+
+// resize moves the chunks holding values to the start of a ring of size
+func (d *deque) resize(size int) {
+	chunks := make([]*chunk, size)
+	for i := 0; i < d.used(); i++ {
+		chunks[i] = d.chunks[(d.first+i)&(len(d.chunks)-1)]
+	}
+	d.chunks, d.first = chunks, 0
+}
+
+// grow makes room for one more chunk
+func (d *deque) grow() {
+	if d.used() < len(d.chunks) {
+		return
+	}
+	if len(d.chunks) == 0 {
+		d.resize(1)
+		return
+	}
+	d.resize(2 * len(d.chunks))
+}
+
+// release frees the i-th chunk from the first one, which must not hold
+// values anymore, and shrinks the ring once it is mostly empty
+func (d *deque) release(i int) {
+	d.chunks[(d.first+i)&(len(d.chunks)-1)] = nil
+
+	if d.n == 0 {
+		d.chunks, d.first, d.off = nil, 0, 0
+		return
+	}
+	if len(d.chunks) > 4 && 4*d.used() <= len(d.chunks) {
+		d.resize(len(d.chunks) / 2)
+	}
+}
+
+func (d *deque) pushBack(v item) {
+	if d.n > 0 && v.pushedAt < d.at(d.n-1).pushedAt {
+		d.unordered = true
+	}
+	p := d.off + d.n
+	if p%chunkSize == 0 {
+		d.grow()
+	}
+	d.chunk(p / chunkSize)[p%chunkSize] = v
+	d.n++
+}
+
+func (d *deque) pushFront(v item) {
+	if d.n > 0 && v.pushedAt > d.at(0).pushedAt {
+		d.unordered = true
+	}
+	if d.n == 0 {
+		// Start in the middle of a chunk, leaving room at both ends
+		d.off = chunkSize / 2
+	}
+	if d.off == 0 || d.n == 0 {
+		d.grow()
+	}
+	if d.off == 0 {
+		d.first = (d.first - 1) & (len(d.chunks) - 1)
+		d.off = chunkSize
+	}
+	d.off--
+	d.chunk(0)[d.off] = v
+	d.n++
+}
+
+func (d *deque) popFront() (item, bool) {
+	if d.n == 0 {
+		return item{}, false
+	}
+
+	c := d.chunk(0)
+	v := c[d.off]
+	c[d.off] = item{}
+	d.off++
+	d.n--
+
+	if d.off == chunkSize || d.n == 0 {
+		d.release(0)
+		if d.n > 0 {
+			d.first = (d.first + 1) & (len(d.chunks) - 1)
+			d.off = 0
+		}
+	}
+
+	return v, true
+}
+
+func (d *deque) popBack() (item, bool) {
+	if d.n == 0 {
+		return item{}, false
+	}
+
+	p := d.off + d.n - 1
+	c := d.chunk(p / chunkSize)
+	v := c[p%chunkSize]
+	c[p%chunkSize] = item{}
+	d.n--
+
+	if p%chunkSize == 0 || d.n == 0 {
+		d.release(p / chunkSize)
+	}
+
+	return v, true
+}
+
+// at returns the i-th item, which must exist
+func (d *deque) at(i int) item {
+	p := d.off + i
+	return d.chunk(p / chunkSize)[p%chunkSize]
+}
+
+func (d *deque) set(i int, v item) {
+	p := d.off + i
+	d.chunk(p / chunkSize)[p%chunkSize] = v
+}
+
+// insert inserts v before the i-th value, shifting the values after it
+func (d *deque) insert(i int, v item) {
+	d.pushBack(item{})
+	for j := d.n - 1; j > i; j-- {
+		d.set(j, d.at(j-1))
+	}
+	d.set(i, v)
+	d.unordered = true
+}
+
+// remove removes the i-th value, shifting the values after it
+func (d *deque) remove(i int) {
+	for j := i; j < d.n-1; j++ {
+		d.set(j, d.at(j+1))
+	}
+	d.popBack()
+}
+
+// slice returns a copy of the values from start up to, but excluding, stop
+func (d *deque) slice(start, stop int) []interface{} {
+	values := make([]interface{}, 0, stop-start)
+	for i := start; i < stop; i++ {
+		values = append(values, d.at(i).value)
+	}
+
+	return values
+}
+
+// trim keeps the values from start up to, but excluding, stop
+func (d *deque) trim(start, stop int) {
+	for n := d.n - stop; n > 0; n-- {
+		d.popBack()
+	}
+	for ; start > 0; start-- {
+		d.popFront()
+	}
+}
@@ -0,0 +1,200 @@
+package queue
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The deque must agree with a slice after any sequence of operations
+func TestDequeRandom(t *testing.T) {
+	d := &deque{}
+	var want []interface{}
+
+	for i := 0; i < 20000; i++ {
+		switch op := rand.Intn(10); {
+		case op < 3:
+			d.pushBack(item{value: i})
+			want = append(want, i)
+		case op < 6:
+			d.pushFront(item{value: i})
+			want = append([]interface{}{i}, want...)
+		case op < 8:
+			v, ok := d.popFront()
+			if len(want) == 0 {
+				require.False(t, ok)
+				continue
+			}
+			require.Equal(t, want[0], v.value)
+			want = want[1:]
+		default:
+			v, ok := d.popBack()
+			if len(want) == 0 {
+				require.False(t, ok)
+				continue
+			}
+			require.Equal(t, want[len(want)-1], v.value)
+			want = want[:len(want)-1]
+		}
+		require.Equal(t, len(want), d.len())
+	}
+
+	assert.Equal(t, append([]interface{}{}, want...), d.slice(0, d.len()))
+}
+
+func TestDequeInsertAndTrim(t *testing.T) {
+	d := &deque{}
+	for i := 0; i < 3*chunkSize; i++ {
+		d.pushBack(item{value: i})
+	}
+
+	d.insert(chunkSize, item{value: "x"})
+	assert.Equal(t, chunkSize-1, d.at(chunkSize-1).value)
+	assert.Equal(t, "x", d.at(chunkSize).value)
+	assert.Equal(t, chunkSize, d.at(chunkSize+1).value)
+	assert.True(t, d.unordered)
+
+	d.remove(chunkSize)
+	assert.Equal(t, chunkSize, d.at(chunkSize).value)
+	assert.Equal(t, 3*chunkSize, d.len())
+
+	d.trim(10, 20)
+	assert.Equal(t, []interface{}{10, 11, 12, 13, 14, 15, 16, 17, 18, 19}, d.slice(0, d.len()))
+}
+
+// Draining a queue releases its chunks, unlike reslicing
+func TestDequeReleasesMemory(t *testing.T) {
+	d := &deque{}
+	for i := 0; i < 1000*chunkSize; i++ {
+		d.pushBack(item{value: i})
+	}
+	for i := 0; i < 999*chunkSize; i++ {
+		d.popFront()
+	}
+
+	allocated := 0
+	for _, c := range d.chunks {
+		if c != nil {
+			allocated++
+		}
+	}
+	assert.Equal(t, 1, allocated)
+	assert.LessOrEqual(t, len(d.chunks), 4)
+
+	for d.len() > 0 {
+		d.popBack()
+	}
+	assert.Nil(t, d.chunks)
+}
+
+func TestQueueDeletesEmptyQueues(t *testing.T) {
+	q := NewQueue()
+	q.Append("q", 1, 2)
+	q.Pop("q")
+	q.Pop("q")
+
+	assert.Empty(t, q.queues)
+	_, err := q.Pop("q")
+	assert.Equal(t, ErrQueueEmpty, err)
+}
+
+// sliceQueue is how queues were stored before the deque, for comparison
+type sliceQueue []interface{}
+
+func (s *sliceQueue) pushBack(v interface{}) {
+	*s = append(*s, v)
+}
+
+func (s *sliceQueue) popFront() interface{} {
+	v := (*s)[0]
+	*s = (*s)[1:]
+	return v
+}
+
+// A queue holding about 1000 values with a push for every pop, as a busy
+// work queue does
+func BenchmarkSteadyState(b *testing.B) {
+	b.Run("deque", func(b *testing.B) {
+		d := &deque{}
+		for i := 0; i < 1000; i++ {
+			d.pushBack(item{value: i})
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			d.pushBack(item{value: i})
+			d.popFront()
+		}
+	})
+
+	b.Run("slice", func(b *testing.B) {
+		s := &sliceQueue{}
+		for i := 0; i < 1000; i++ {
+			s.pushBack(i)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.pushBack(i)
+			s.popFront()
+		}
+	})
+}
+
+// Filling a queue with 10000 values then draining it
+func BenchmarkFillDrain(b *testing.B) {
+	const n = 10000
+
+	b.Run("deque", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			d := &deque{}
+			for j := 0; j < n; j++ {
+				d.pushBack(item{value: j})
+			}
+			for j := 0; j < n; j++ {
+				d.popFront()
+			}
+		}
+	})
+
+	b.Run("slice", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s := &sliceQueue{}
+			for j := 0; j < n; j++ {
+				s.pushBack(j)
+			}
+			for j := 0; j < n; j++ {
+				s.popFront()
+			}
+		}
+	})
+}
+
+// Pushing at the head, which the slice can only do by copying
+func BenchmarkPushFront(b *testing.B) {
+	const n = 1000
+
+	b.Run("deque", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			d := &deque{}
+			for j := 0; j < n; j++ {
+				d.pushFront(item{value: j})
+			}
+		}
+	})
+
+	b.Run("slice", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var s []interface{}
+			for j := 0; j < n; j++ {
+				s = append([]interface{}{j}, s...)
+			}
+		}
+	})
+}
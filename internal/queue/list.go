@@ -0,0 +1,197 @@
+package queue
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+var ErrIndexOutOfRange = errors.New("index out of range")
+
+// Every queue is also a list that can be pushed to and popped from at both
+// ends. QPush and Pop work at the tail and the head, as RPush and LPop do.
+// Priority queues are only lists as far as their order allows: values are
+// pushed at either end of their priority and read in the order they are
+// popped, but cannot be popped from the tail, trimmed or inserted into.
+
+// LPush inserts values at the head of the list under key, one after the
+// other so that the last value ends up first, and returns the new length
+func (q *Queue) LPush(key string, values ...interface{}) (int, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	if err := q.room(key, len(values)); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, v := range values {
+		q.pushFront(key, newItem(v, now))
+		q.count(key).enqueued++
+	}
+	q.shrink(key)
+
+	n := q.length(key)
+	q.serve(key)
+
+	return n, nil
+}
+
+// RPush appends values at the tail of the list under key and returns the
+// new length
+func (q *Queue) RPush(key string, values ...interface{}) (int, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	return q.pushLimited(key, values)
+}
+
+func (q *Queue) LPop(key string) (interface{}, error) {
+	return q.Pop(key)
+}
+
+func (q *Queue) RPop(key string) (interface{}, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	it, err := q.popBack(key)
+	return it.value, err
+}
+
+// LLen returns the length of the list under key
+func (q *Queue) LLen(key string) int {
+	q.lock()
+	defer q.mu.Unlock()
+
+	return q.length(key)
+}
+
+// LIndex returns the element at index of the list under key, negative
+// indexes counting from the tail
+func (q *Queue) LIndex(key string, index int) (interface{}, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	n := q.length(key)
+	if index < 0 {
+		index += n
+	}
+	if index < 0 || index >= n {
+		return nil, ErrIndexOutOfRange
+	}
+
+	if pq, ok := q.priorities[key]; ok {
+		return pq.sorted()[index].value, nil
+	}
+	return q.queues[key].at(index).value, nil
+}
+
+// LRange returns a copy of the elements of the list under key from start to
+// stop, both included and negative ones counting from the tail
+func (q *Queue) LRange(key string, start, stop int) []interface{} {
+	q.lock()
+	defer q.mu.Unlock()
+
+	if pq, ok := q.priorities[key]; ok {
+		items := pq.sorted()
+		start, stop = clampRange(len(items), start, stop)
+		values := make([]interface{}, 0, stop-start)
+		for _, it := range items[start:stop] {
+			values = append(values, it.value)
+		}
+		return values
+	}
+
+	queue, ok := q.queues[key]
+	if !ok {
+		return []interface{}{}
+	}
+	start, stop = clampRange(queue.len(), start, stop)
+
+	return queue.slice(start, stop)
+}
+
+// LTrim keeps only the elements of the list under key from start to stop,
+// as for LRange, so LTrim(key, 0, 99) caps a list to its first 100 elements
+func (q *Queue) LTrim(key string, start, stop int) error {
+	q.lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.priorities[key]; ok {
+		return ErrPriorityQueue
+	}
+	queue, ok := q.queues[key]
+	if !ok {
+		return nil
+	}
+	n := queue.len()
+	start, stop = clampRange(n, start, stop)
+
+	// Trimmed values count as popped
+	queue.trim(start, stop)
+	q.count(key).dequeued += uint64(n - queue.len())
+	if queue.len() == 0 {
+		delete(q.queues, key)
+	}
+	q.freed(key)
+	q.forget(key)
+
+	return nil
+}
+
+// LInsert inserts value before or after the first element of the list under
+// key equal to pivot and returns the new length, or -1 if there is no such
+// element and 0 if the list is empty
+func (q *Queue) LInsert(key string, before bool, pivot, value interface{}) (int, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.priorities[key]; ok {
+		return 0, ErrPriorityQueue
+	}
+	queue, ok := q.queues[key]
+	if !ok {
+		return 0, nil
+	}
+
+	for i := 0; i < queue.len(); i++ {
+		if !reflect.DeepEqual(queue.at(i).value, pivot) {
+			continue
+		}
+		if !before {
+			i++
+		}
+		if err := q.room(key, 1); err != nil {
+			return 0, err
+		}
+		queue.insert(i, newItem(value, time.Now()))
+		q.count(key).enqueued++
+		q.shrink(key)
+
+		return q.length(key), nil
+	}
+
+	return -1, nil
+}
+
+// clampRange turns start and stop, both included and negative ones counting
+// from the end, into slice bounds of a list of length n
+func clampRange(n, start, stop int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
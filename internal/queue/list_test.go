@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPushPop(t *testing.T) {
+	q := NewQueue()
+
+	n, err := q.RPush("l", "c", "d")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = q.LPush("l", "b", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []interface{}{"a", "b", "c", "d"}, q.LRange("l", 0, -1))
+
+	value, err := q.LPop("l")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+	value, _ = q.RPop("l")
+	assert.Equal(t, "d", value)
+	assert.Equal(t, 2, q.LLen("l"))
+
+	q.RPop("l")
+	q.RPop("l")
+	_, err = q.RPop("l")
+	assert.Equal(t, ErrQueueEmpty, err)
+}
+
+func TestListIndexAndRange(t *testing.T) {
+	q := NewQueue()
+	q.RPush("l", "a", "b", "c", "d", "e")
+
+	value, err := q.LIndex("l", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, "e", value)
+	_, err = q.LIndex("l", 5)
+	assert.Equal(t, ErrIndexOutOfRange, err)
+
+	tests := []struct {
+		start, stop int
+		want        []interface{}
+	}{
+		{1, 2, []interface{}{"b", "c"}},
+		{-2, 100, []interface{}{"d", "e"}},
+		{-100, 0, []interface{}{"a"}},
+		{3, 1, []interface{}{}},
+		{5, 10, []interface{}{}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, q.LRange("l", test.start, test.stop), "%d %d", test.start, test.stop)
+	}
+}
+
+func TestListTrimAndInsert(t *testing.T) {
+	q := NewQueue()
+
+	// A capped feed keeps its three latest entries
+	for _, event := range []string{"e1", "e2", "e3", "e4", "e5"} {
+		q.LPush("feed", event)
+		q.LTrim("feed", 0, 2)
+	}
+	assert.Equal(t, []interface{}{"e5", "e4", "e3"}, q.LRange("feed", 0, -1))
+	// and counts the trimmed ones as popped
+	s := q.Stats("feed")
+	assert.Equal(t, uint64(5), s.Enqueued)
+	assert.Equal(t, uint64(2), s.Dequeued)
+
+	insert := func(key string, before bool, pivot, value interface{}) int {
+		n, err := q.LInsert(key, before, pivot, value)
+		require.NoError(t, err)
+		return n
+	}
+	assert.Equal(t, 4, insert("feed", true, "e4", "x"))
+	assert.Equal(t, 5, insert("feed", false, "e3", "y"))
+	assert.Equal(t, -1, insert("feed", false, "missing", "z"))
+	assert.Equal(t, 0, insert("empty", false, "e3", "z"))
+	assert.Equal(t, []interface{}{"e5", "x", "e4", "e3", "y"}, q.LRange("feed", 0, -1))
+
+	// Trimming everything deletes the list
+	q.LTrim("feed", 3, 1)
+	assert.Equal(t, 0, q.LLen("feed"))
+	assert.NotContains(t, q.Dump().Queues, "feed")
+}
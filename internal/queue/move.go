@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// A value can be moved from one queue to another in a single step, so that
+// it is in one of them at any time and never lost between two stages of a
+// pipeline. It keeps its priority, making its new queue a priority queue if
+// it had one.
+
+// move is where a moved value is taken from and put to
+type move struct {
+	dst string
+	// Pop from the tail of the source rather than its head, and push to the
+	// head of the destination rather than its tail
+	fromTail bool
+	toHead   bool
+}
+
+// PopN pops up to n values from the head of the queue under key and returns
+// them in order, none if the queue is empty
+func (q *Queue) PopN(key string, n int) []interface{} {
+	q.lock()
+	defer q.mu.Unlock()
+
+	values := []interface{}{}
+	for len(values) < n {
+		it, err := q.popFront(key)
+		if err != nil {
+			break
+		}
+		values = append(values, it.value)
+	}
+
+	return values
+}
+
+// Move pops a value from the queue under src and pushes it to the queue
+// under dst, from the head of src to the tail of dst unless fromTail or
+// toHead are set, and returns it
+func (q *Queue) Move(src, dst string, fromTail, toHead bool) (interface{}, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	it, err := q.move(src, &move{dst: dst, fromTail: fromTail, toHead: toHead})
+	return it.value, err
+}
+
+// BMove is Move, waiting for a value to be pushed to src if it is empty as
+// BPop does. Once moved, the value stays in dst even if ctx is done before
+// it is returned.
+func (q *Queue) BMove(ctx context.Context, src, dst string, fromTail, toHead bool, timeout time.Duration) (interface{}, error) {
+	q.lock()
+	m := &move{dst: dst, fromTail: fromTail, toHead: toHead}
+	if it, err := q.move(src, m); err != ErrQueueEmpty {
+		q.mu.Unlock()
+		return it.value, err
+	}
+
+	w := &waiter{value: make(chan popped, 1), move: m}
+	q.addWaiter(w, []string{src})
+	q.mu.Unlock()
+
+	p, err := q.wait(ctx, w, timeout)
+	return p.value, err
+}
+
+// move pops an item from the queue under src and pushes it as m says,
+// serving the pops blocked on its destination. It fails with ErrQueueFull,
+// leaving the item in src, if the destination has no room for it. The
+// caller must hold the lock.
+func (q *Queue) move(src string, m *move) (item, error) {
+	if m.dst != src && q.length(src) > 0 {
+		if err := q.room(m.dst, 1); err != nil {
+			return item{}, err
+		}
+	}
+
+	var it item
+	var err error
+	if m.fromTail {
+		it, err = q.popBack(src)
+	} else {
+		it, err = q.popFront(src)
+	}
+	if err != nil {
+		return item{}, err
+	}
+
+	// The value is new to its destination, as far as its age goes
+	moved := it
+	moved.pushedAt = time.Now().UnixNano()
+	if m.toHead {
+		q.pushFront(m.dst, moved)
+		q.count(m.dst).enqueued++
+	} else {
+		q.push(m.dst, moved)
+	}
+	q.shrink(m.dst)
+	q.serve(m.dst)
+
+	return it, nil
+}
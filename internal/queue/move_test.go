@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPopN(t *testing.T) {
+	q := NewQueue()
+	assert.Equal(t, []interface{}{}, q.PopN("q", 3))
+
+	q.Append("q", "a", "b", "c", "d")
+	assert.Equal(t, []interface{}{"a", "b", "c"}, q.PopN("q", 3))
+	assert.Equal(t, []interface{}{"d"}, q.PopN("q", 3))
+	assert.Empty(t, q.queues)
+}
+
+func TestMove(t *testing.T) {
+	q := NewQueue()
+	_, err := q.Move("src", "dst", false, false)
+	assert.Equal(t, ErrQueueEmpty, err)
+
+	q.Append("src", "a", "b", "c")
+	q.Append("dst", "x")
+
+	value, err := q.Move("src", "dst", false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+	value, _ = q.Move("src", "dst", true, true)
+	assert.Equal(t, "c", value)
+	assert.Equal(t, []interface{}{"b"}, q.LRange("src", 0, -1))
+	assert.Equal(t, []interface{}{"c", "x", "a"}, q.LRange("dst", 0, -1))
+
+	// Rotating a queue onto itself
+	value, _ = q.Move("dst", "dst", false, false)
+	assert.Equal(t, "c", value)
+	assert.Equal(t, []interface{}{"x", "a", "c"}, q.LRange("dst", 0, -1))
+
+	// Values keep their priority
+	q.PushPriority("pq", 5, "p")
+	q.Move("pq", "dst", false, false)
+	assert.Equal(t, []interface{}{"p", "x", "a", "c"}, q.LRange("dst", 0, -1))
+	q.PushPriority("pq", 1, "r")
+	_, err = q.Move("pq", "dst", true, false)
+	assert.Equal(t, ErrPriorityQueue, err)
+}
+
+func TestBMove(t *testing.T) {
+	q := NewQueue()
+	q.Append("src", "a")
+	value, err := q.BMove(context.Background(), "src", "dst", false, false, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+
+	_, err = q.BMove(context.Background(), "src", "dst", false, false, 10*time.Millisecond)
+	assert.Equal(t, ErrQueueEmpty, err)
+
+	// A push wakes the move, whose value wakes a pop blocked on dst
+	popResult := bpop(t, context.Background(), q, 0, "next")
+	result := make(chan interface{}, 1)
+	go func() {
+		value, _ := q.BMove(context.Background(), "src", "next", false, false, 0)
+		result <- value
+	}()
+	require.Eventually(t, func() bool { return q.Stats("src").Waiters == 1 }, time.Second, time.Millisecond)
+
+	q.Append("src", "b")
+	assert.Equal(t, "b", <-result)
+	assert.Equal(t, "b", (<-popResult).value)
+	assert.Equal(t, []interface{}{"a"}, q.LRange("dst", 0, -1))
+	assert.Empty(t, q.waiters)
+}
+
+// A value moved as the move gives up stays in its destination
+func TestBMoveGiveUpAfterHandover(t *testing.T) {
+	q := NewQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &waiter{keys: []string{"src"}, value: make(chan popped, 1), move: &move{dst: "dst"}}
+	q.waiters["src"] = []*waiter{w}
+	q.Append("src", "a")
+	cancel()
+
+	p, err := q.stopWaiting(ctx, w)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", p.value)
+	assert.Equal(t, 0, q.LLen("src"))
+	assert.Equal(t, []interface{}{"a"}, q.LRange("dst", 0, -1))
+}
@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrPriorityQueue = errors.New("operation not supported by priority queues")
+
+// A queue pushed to with a priority becomes a priority queue, popped from
+// highest priority first and in push order within the same priority. Its
+// values are kept in a heap instead of a deque, the values already in it
+// and those pushed without a priority taking priority 0. It goes back to
+// being a plain queue once empty.
+
+// Prioritized is a value of a priority queue with its priority
+type Prioritized struct {
+	Value    interface{}
+	Priority int64
+}
+
+// entry is a value of a priority queue with its position among the values
+// of the same priority
+type entry struct {
+	seq int64
+	item
+}
+
+// priorityQueue is a max-heap of values by priority, then by position
+type priorityQueue struct {
+	entries []entry
+	// Positions of the next values pushed at the back and at the front
+	back, front int64
+}
+
+func (pq *priorityQueue) Len() int { return len(pq.entries) }
+
+func (pq *priorityQueue) Less(i, j int) bool {
+	a, b := pq.entries[i], pq.entries[j]
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
+
+func (pq *priorityQueue) Swap(i, j int) {
+	pq.entries[i], pq.entries[j] = pq.entries[j], pq.entries[i]
+}
+
+func (pq *priorityQueue) Push(x interface{}) { pq.entries = append(pq.entries, x.(entry)) }
+
+func (pq *priorityQueue) Pop() interface{} {
+	last := pq.entries[len(pq.entries)-1]
+	pq.entries[len(pq.entries)-1] = entry{}
+	pq.entries = pq.entries[:len(pq.entries)-1]
+	return last
+}
+
+func (pq *priorityQueue) len() int {
+	return len(pq.entries)
+}
+
+// pushBack adds it after the values of the same priority
+func (pq *priorityQueue) pushBack(it item) {
+	heap.Push(pq, entry{seq: pq.back, item: it})
+	pq.back++
+}
+
+// pushFront adds it before the values of the same priority
+func (pq *priorityQueue) pushFront(it item) {
+	pq.front--
+	heap.Push(pq, entry{seq: pq.front, item: it})
+}
+
+func (pq *priorityQueue) popFront() (item, bool) {
+	if len(pq.entries) == 0 {
+		return item{}, false
+	}
+	return heap.Pop(pq).(entry).item, true
+}
+
+// sorted returns the values in the order they are popped
+func (pq *priorityQueue) sorted() []item {
+	entries := append([]entry{}, pq.entries...)
+	sorted := &priorityQueue{entries: entries}
+	sort.Slice(entries, sorted.Less)
+
+	items := make([]item, len(entries))
+	for i, e := range entries {
+		items[i] = e.item
+	}
+	return items
+}
+
+// PushPriority appends values with the given priority to the queue under
+// key, making it a priority queue, and returns its new length before any
+// blocked pop is served
+func (q *Queue) PushPriority(key string, priority int64, values ...interface{}) (int, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	if len(values) == 0 {
+		return q.length(key), nil
+	}
+	if err := q.room(key, len(values)); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	pq := q.prioritize(key)
+	for _, v := range values {
+		it := newItem(v, now)
+		it.priority = priority
+		pq.pushBack(it)
+		q.count(key).enqueued++
+	}
+	q.shrink(key)
+
+	n := q.length(key)
+	q.serve(key)
+
+	return n, nil
+}
+
+// prioritize turns the queue under key into a priority queue if it is not
+// one yet, and returns it. The caller must hold the lock.
+func (q *Queue) prioritize(key string) *priorityQueue {
+	if pq, ok := q.priorities[key]; ok {
+		return pq
+	}
+
+	pq := &priorityQueue{}
+	if queue, ok := q.queues[key]; ok {
+		for queue.len() > 0 {
+			it, _ := queue.popFront()
+			pq.pushBack(it)
+		}
+		delete(q.queues, key)
+	}
+	q.priorities[key] = pq
+
+	return pq
+}
+
+// prioritized reports whether any of values has a priority
+func prioritized(values []Prioritized) bool {
+	for _, p := range values {
+		if p.Priority != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushPriorityOrder(t *testing.T) {
+	q := NewQueue()
+	q.Append("q", "old")
+	n, err := q.PushPriority("q", 5, "high1", "high2")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = q.PushPriority("q", -1, "low")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	q.Append("q", "plain")
+	n, err = q.LPush("q", "first")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	// Highest priority first, in push order within a priority, the values
+	// pushed without a priority taking priority 0
+	assert.Equal(t, []interface{}{"high1", "high2", "first", "old", "plain", "low"}, q.LRange("q", 0, -1))
+	value, err := q.LIndex("q", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, "low", value)
+
+	for _, want := range []interface{}{"high1", "high2", "first", "old", "plain", "low"} {
+		value, err := q.Pop("q")
+		require.NoError(t, err)
+		assert.Equal(t, want, value)
+	}
+
+	// Once empty it is a plain queue again
+	assert.Empty(t, q.priorities)
+	q.Append("q", "a", "b")
+	value, err = q.RPop("q")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", value)
+}
+
+func TestPriorityQueueListOps(t *testing.T) {
+	q := NewQueue()
+	q.PushPriority("q", 1, "a", "b")
+
+	_, err := q.RPop("q")
+	assert.Equal(t, ErrPriorityQueue, err)
+	assert.Equal(t, ErrPriorityQueue, q.LTrim("q", 0, 0))
+	_, err = q.LInsert("q", true, "a", "x")
+	assert.Equal(t, ErrPriorityQueue, err)
+	assert.Equal(t, 2, q.LLen("q"))
+}
+
+func TestPushPriorityServesBlockedPop(t *testing.T) {
+	q := NewQueue()
+	result := bpop(t, context.Background(), q, 5*time.Second, "q")
+
+	n, err := q.PushPriority("q", 3, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	r := <-result
+	assert.NoError(t, r.err)
+	assert.Equal(t, "a", r.value)
+	assert.Empty(t, q.priorities)
+}
+
+func TestDumpLoadPriorities(t *testing.T) {
+	q := NewQueue()
+	q.PushPriority("q", 2, "a", "b")
+	q.Append("q", "c")
+	now := time.Now()
+
+	// A reserved value keeps its priority, ahead of the others
+	m, _ := q.Reserve("q", now, time.Minute)
+	assert.Equal(t, "a", m.Value)
+	// Even once its queue is empty
+	q.Append("plain", "x")
+	q.Reserve("plain", now, time.Minute)
+	q.PushPriority("other", 1, "y")
+	q.Reserve("other", now, time.Minute)
+
+	s := q.Dump()
+	assert.Equal(t, map[string][]interface{}{"plain": {"x"}}, s.Queues)
+	assert.Equal(t, map[string][]Prioritized{
+		"q":     {{Value: "a", Priority: 2}, {Value: "b", Priority: 2}, {Value: "c"}},
+		"other": {{Value: "y", Priority: 1}},
+	}, s.Priorities)
+
+	loaded := NewQueue()
+	loaded.Load(s)
+	assert.Equal(t, s, loaded.Dump())
+	loaded.PushPriority("q", 2, "d")
+	assert.Equal(t, []interface{}{"a", "b", "d", "c"}, loaded.LRange("q", 0, -1))
+}
@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -11,15 +12,48 @@ var (
 )
 
 type Queue struct {
-	queues    map[string][]interface{}
+	queues    map[string]*deque
 	mu        sync.Mutex
 	pushChan  chan *PushRequest
 	pushBatch int
+	// Pops blocked on each key, in the order they started waiting. A key
+	// only has waiters while its queue is empty, or holds values that pops
+	// just woken are about to take.
+	waiters map[string][]*waiter
+	// Messages reserved from each key and not acked yet
+	leases map[string]*leases
+	// Settings of each key, kept even while its queue is empty
+	configs map[string]Config
+	// Messages moved to each dead-letter queue, oldest first
+	deadLetters map[string][]DeadLetter
+	// Time and sequence number of the last message ID
+	lastMs int64
+	seq    int
+	// Values scheduled to be pushed later, the timer firing when the
+	// first one is due, and their number for each key
+	delayed    schedule
+	delayedSeq uint64
+	timer      *time.Timer
+	pending    map[string]int
+	// Set by HoldDue, due values then waiting for Promote with onDue told
+	// about them
+	hold  bool
+	onDue func(keys []string)
+	// Keys holding a priority queue, which are not in queues
+	priorities map[string]*priorityQueue
+	// Values pushed to and popped from each key
+	counts map[string]*counts
+	// Pushes waiting for room in each full queue, woken by closing their
+	// channel
+	producers map[string][]chan struct{}
 }
 
 type PushRequest struct {
 	Key    string
 	Values []interface{}
+
+	// Closed once the requests before it are applied, for Flush
+	done chan struct{}
 }
 
 const (
@@ -28,13 +62,25 @@ const (
 
 func NewQueue() *Queue {
 	q := &Queue{
-		queues:    make(map[string][]interface{}),
-		pushChan:  make(chan *PushRequest, batchThreshold),
-		pushBatch: 0,
+		queues:      make(map[string]*deque),
+		pushChan:    make(chan *PushRequest, batchThreshold),
+		pushBatch:   0,
+		waiters:     make(map[string][]*waiter),
+		leases:      make(map[string]*leases),
+		configs:     make(map[string]Config),
+		deadLetters: make(map[string][]DeadLetter),
+		priorities:  make(map[string]*priorityQueue),
+		counts:      make(map[string]*counts),
+		producers:   make(map[string][]chan struct{}),
+		pending:     make(map[string]int),
 	}
 
 	go func() {
 		for req := range q.pushChan {
+			if req.done != nil {
+				close(req.done)
+				continue
+			}
 			q.doPush(req.Key, req.Values)
 			q.pushBatch++
 
@@ -49,59 +95,506 @@ func NewQueue() *Queue {
 	return q
 }
 
+// QPush appends values to the queue under key through the push channel,
+// so they are applied shortly after it returns. A queue with a max length
+// is pushed to right away instead, as the push may not fit.
 func (q *Queue) QPush(key string, values ...interface{}) error {
-	q.pushChan <- &PushRequest{Key: key, Values: values}
-	return nil
+	q.mu.Lock()
+	limited := q.configs[key].MaxLen > 0
+	q.mu.Unlock()
+
+	if !limited {
+		q.pushChan <- &PushRequest{Key: key, Values: values}
+		return nil
+	}
+
+	q.lock()
+	defer q.mu.Unlock()
+
+	_, err := q.pushLimited(key, values)
+	return err
+}
+
+// Flush returns once the values QPush handed to the push channel before it
+// are in their queue
+func (q *Queue) Flush() {
+	done := make(chan struct{})
+	q.pushChan <- &PushRequest{done: done}
+	<-done
+}
+
+// Append pushes values synchronously, bypassing the push channel. It is
+// meant for restoring queues, where the order of pushes and pops matters.
+func (q *Queue) Append(key string, values ...interface{}) {
+	q.doPush(key, values)
 }
 
+// doPush applies a push that already went through, which only drops values
+// if the queue is over its max length
 func (q *Queue) doPush(key string, values []interface{}) {
-	q.mu.Lock()
+	q.lock()
 	defer q.mu.Unlock()
 
-	q.queues[key] = append(q.queues[key], values...)
+	q.pushBack(key, values)
+	q.shrink(key)
+}
+
+// pushLimited is pushBack for the push of a producer, which fails with
+// ErrQueueFull or drops values if the queue has no room for values. The
+// caller must hold the lock.
+func (q *Queue) pushLimited(key string, values []interface{}) (int, error) {
+	if err := q.room(key, len(values)); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, v := range values {
+		q.push(key, newItem(v, now))
+	}
+	q.shrink(key)
+
+	n := q.length(key)
+	q.serve(key)
+
+	return n, nil
+}
+
+// pushBack appends values to the queue under key, creating it if needed,
+// and returns its new length before any blocked pop is served. The caller
+// must hold the lock.
+func (q *Queue) pushBack(key string, values []interface{}) int {
+	now := time.Now()
+	for _, v := range values {
+		q.push(key, newItem(v, now))
+	}
+
+	n := q.length(key)
+	q.serve(key)
+
+	return n
+}
+
+// push appends it to the queue under key, creating it if needed, without
+// serving blocked pops. The caller must hold the lock.
+func (q *Queue) push(key string, it item) {
+	q.count(key).enqueued++
+	if _, ok := q.priorities[key]; ok || it.priority != 0 {
+		q.prioritize(key).pushBack(it)
+		return
+	}
+	q.queue(key, true).pushBack(it)
+}
+
+// pushFront puts it back at the head of the queue under key, as when a
+// popped value is given back. The caller must hold the lock.
+func (q *Queue) pushFront(key string, it item) {
+	if _, ok := q.priorities[key]; ok || it.priority != 0 {
+		q.prioritize(key).pushFront(it)
+		return
+	}
+	q.queue(key, true).pushFront(it)
+}
+
+// length returns the number of values in the queue under key. The caller
+// must hold the lock.
+func (q *Queue) length(key string) int {
+	if pq, ok := q.priorities[key]; ok {
+		return pq.len()
+	}
+	if queue, ok := q.queues[key]; ok {
+		return queue.len()
+	}
+	return 0
+}
+
+// queue returns the queue under key, which is only stored in the map if
+// create is set, as the map only holds queues that are not empty. The
+// caller must hold the lock.
+func (q *Queue) queue(key string, create bool) *deque {
+	queue, ok := q.queues[key]
+	if !ok {
+		queue = &deque{}
+		if create {
+			q.queues[key] = queue
+		}
+	}
+
+	return queue
 }
 
 func (q *Queue) Pop(key string) (interface{}, error) {
-	q.mu.Lock()
+	q.lock()
 	defer q.mu.Unlock()
 
-	if queue, ok := q.queues[key]; ok && len(queue) > 0 {
-		value := queue[0]
-		q.queues[key] = queue[1:]
-		return value, nil
+	it, err := q.popFront(key)
+	return it.value, err
+}
+
+// popFront pops the first item of the queue under key, deleting the queue
+// once it is empty. The caller must hold the lock.
+func (q *Queue) popFront(key string) (item, error) {
+	if pq, ok := q.priorities[key]; ok {
+		it, _ := pq.popFront()
+		if pq.len() == 0 {
+			delete(q.priorities, key)
+		}
+		q.count(key).dequeued++
+		q.freed(key)
+		q.forget(key)
+		return it, nil
+	}
+
+	queue, ok := q.queues[key]
+	if !ok {
+		return item{}, ErrQueueEmpty
+	}
+
+	it, _ := queue.popFront()
+	if queue.len() == 0 {
+		delete(q.queues, key)
+	}
+	q.count(key).dequeued++
+	q.freed(key)
+	q.forget(key)
+
+	return it, nil
+}
+
+// popBack pops the last item of the queue under key, deleting the queue
+// once it is empty. Priority queues cannot be popped from the tail. The
+// caller must hold the lock.
+func (q *Queue) popBack(key string) (item, error) {
+	if _, ok := q.priorities[key]; ok {
+		return item{}, ErrPriorityQueue
+	}
+	queue, ok := q.queues[key]
+	if !ok {
+		return item{}, ErrQueueEmpty
+	}
+
+	it, _ := queue.popBack()
+	if queue.len() == 0 {
+		delete(q.queues, key)
+	}
+	q.count(key).dequeued++
+	q.freed(key)
+	q.forget(key)
+
+	return it, nil
+}
+
+// waiter is a pop blocked on one or more empty queues. A value is handed to
+// it directly by the push that ends the wait, so no other pop can take it
+// first.
+type waiter struct {
+	keys  []string
+	value chan popped
+	// Set for a blocked move, whose value is pushed to its destination as
+	// it is popped
+	move *move
+	// Set for a pop that takes the value itself once woken, the value
+	// being left in the queue
+	wake bool
+}
+
+// popped is an item handed to a waiter with the key of its queue, or why
+// it could not be popped
+type popped struct {
+	key string
+	item
+	err error
+}
+
+// BPop pops the first value of the first queue under keys that is not
+// empty, checking them in order, and returns it with its key. If every one
+// is empty, it waits for a value to be pushed to any of them. Pops blocked
+// on the same key are served in the order they started waiting. It gives up
+// with ErrQueueEmpty once timeout has elapsed, a timeout of 0 meaning to
+// wait forever, or with the error of ctx once it is done.
+func (q *Queue) BPop(ctx context.Context, keys []string, timeout time.Duration) (string, interface{}, error) {
+	q.lock()
+	for _, key := range keys {
+		if it, err := q.popFront(key); err == nil {
+			q.mu.Unlock()
+			return key, it.value, nil
+		}
+	}
+
+	w := &waiter{value: make(chan popped, 1)}
+	q.addWaiter(w, keys)
+	q.mu.Unlock()
+
+	p, err := q.wait(ctx, w, timeout)
+	return p.key, p.value, err
+}
+
+// WaitValue waits until one of the queues under keys holds a value, for
+// pops that take it themselves rather than have it handed over, as when
+// they are logged under locks that cannot be held while waiting. Pops
+// waiting on the same key are woken in the order they started waiting, one
+// for each value, and wait again if the value is taken first. It gives up
+// as BPop does.
+func (q *Queue) WaitValue(ctx context.Context, keys []string, timeout time.Duration) error {
+	q.lock()
+	for _, key := range keys {
+		if q.length(key) > 0 {
+			q.mu.Unlock()
+			return nil
+		}
+	}
+
+	w := &waiter{value: make(chan popped, 1), wake: true}
+	q.addWaiter(w, keys)
+	q.mu.Unlock()
+
+	_, err := q.wait(ctx, w, timeout)
+	return err
+}
+
+// addWaiter makes w wait on every key of keys. The caller must hold the
+// lock.
+func (q *Queue) addWaiter(w *waiter, keys []string) {
+	for _, key := range keys {
+		if !contains(w.keys, key) {
+			w.keys = append(w.keys, key)
+			q.waiters[key] = append(q.waiters[key], w)
+		}
+	}
+}
+
+// wait waits for a value to be handed to w, until timeout or until ctx is
+// done
+func (q *Queue) wait(ctx context.Context, w *waiter, timeout time.Duration) (popped, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case p := <-w.value:
+		return p, p.err
+	case <-expired:
+	case <-ctx.Done():
 	}
 
-	return nil, ErrQueueEmpty
+	return q.stopWaiting(ctx, w)
 }
 
-func (q *Queue) BPop(key string, timeout time.Duration) (interface{}, error) {
+// stopWaiting ends the wait of w after it timed out or ctx is done
+func (q *Queue) stopWaiting(ctx context.Context, w *waiter) (popped, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	if queue, ok := q.queues[key]; ok {
-		if len(queue) > 0 {
-			value := queue[0]
-			q.queues[key] = queue[1:]
-			return value, nil
+	if q.removeWaiter(w) {
+		if err := ctx.Err(); err != nil {
+			return popped{}, err
 		}
+		return popped{}, ErrQueueEmpty
 	}
 
-	// Wait for an item to be available or for the timeout to expire
-	c := sync.NewCond(&q.mu)
-	go func() {
-		time.Sleep(timeout)
-		c.Broadcast()
-	}()
-	c.Wait()
+	// A value was handed over while giving up. It is kept if the pop
+	// merely timed out, but nobody is left to receive it once ctx is done,
+	// so it goes back to the head of its queue for the next waiter, as if
+	// it was never popped. A moved value stays where it was moved to, and
+	// the value a woken pop was to take is left for the next one.
+	p := <-w.value
+	if err := ctx.Err(); err != nil && p.err == nil && w.move == nil {
+		if !w.wake {
+			if c, ok := q.counts[p.key]; ok {
+				c.dequeued--
+			} else {
+				// Forgotten as the pop emptied the queue
+				q.count(p.key).enqueued++
+			}
+			q.pushFront(p.key, p.item)
+		}
+		q.serve(p.key)
+		return popped{}, err
+	}
 
-	// Check again after waiting
-	if queue, ok := q.queues[key]; ok {
+	return p, p.err
+}
+
+// serve hands the values of the queue under key to the pops blocked on it,
+// oldest first. The caller must hold the lock.
+func (q *Queue) serve(key string) {
+	// Woken pops leave their value in the queue for now
+	woken := 0
+	for len(q.waiters[key]) > 0 && q.length(key) > woken {
+		// The waiter is removed first, as a move back to key serves it
+		// again
+		w := q.waiters[key][0]
+		q.removeWaiter(w)
+
+		p := popped{key: key}
+		switch {
+		case w.wake:
+			woken++
+		case w.move != nil:
+			p.item, p.err = q.move(key, w.move)
+		default:
+			p.item, _ = q.popFront(key)
+		}
+		w.value <- p
+	}
+}
+
+// removeWaiter stops w from waiting on any of its keys and reports whether
+// it was still waiting. The caller must hold the lock.
+func (q *Queue) removeWaiter(w *waiter) bool {
+	removed := false
+	for _, key := range w.keys {
+		waiters := q.waiters[key]
+		for i := range waiters {
+			if waiters[i] != w {
+				continue
+			}
+
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			if len(waiters) == 0 {
+				delete(q.waiters, key)
+				q.forget(key)
+			} else {
+				q.waiters[key] = waiters
+			}
+			removed = true
+			break
+		}
+	}
+
+	return removed
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// State is a copy of every queue with their settings, dead letters and
+// scheduled values
+type State struct {
+	Queues map[string][]interface{}
+	// Priority queues, in the order their values are popped
+	Priorities  map[string][]Prioritized
+	Configs     map[string]Config
+	DeadLetters map[string][]DeadLetter
+	Delayed     map[string][]Delayed
+}
+
+// Dump returns a copy of every queue. Messages reserved and not acked yet
+// are put back at the head of their queue, to be delivered again once
+// loaded.
+func (q *Queue) Dump() State {
+	q.lock()
+	defer q.mu.Unlock()
+
+	queues := make(map[string][]interface{}, len(q.queues))
+	for key, queue := range q.queues {
+		queues[key] = queue.slice(0, queue.len())
+	}
+	priorities := make(map[string][]Prioritized, len(q.priorities))
+	for key, pq := range q.priorities {
+		for _, it := range pq.sorted() {
+			priorities[key] = append(priorities[key], Prioritized{Value: it.value, Priority: it.priority})
+		}
+	}
+
+	for key := range q.leases {
+		leased := q.leased(key)
+		if _, ok := priorities[key]; !ok && !prioritized(leased) {
+			values := make([]interface{}, len(leased))
+			for i, p := range leased {
+				values[i] = p.Value
+			}
+			queues[key] = append(values, queues[key]...)
+			continue
+		}
+
+		// Leased values with a priority make the queue a priority queue
+		// again, with them ahead of the values of the same priority
+		for _, v := range queues[key] {
+			leased = append(leased, Prioritized{Value: v})
+		}
+		delete(queues, key)
+		priorities[key] = append(leased, priorities[key]...)
+	}
+
+	configs := make(map[string]Config, len(q.configs))
+	for key, c := range q.configs {
+		configs[key] = c
+	}
+
+	deadLetters := make(map[string][]DeadLetter, len(q.deadLetters))
+	for dlq, letters := range q.deadLetters {
+		deadLetters[dlq] = append([]DeadLetter{}, letters...)
+	}
+
+	return State{
+		Queues:      queues,
+		Priorities:  priorities,
+		Configs:     configs,
+		DeadLetters: deadLetters,
+		Delayed:     q.delayedValues(),
+	}
+}
+
+// drop deletes the queue under key with its leases. The caller must hold
+// the lock.
+func (q *Queue) drop(key string) {
+	delete(q.queues, key)
+	delete(q.priorities, key)
+	delete(q.leases, key)
+	q.forget(key)
+}
+
+// Load replaces the queues, settings, dead-letter queues and scheduled
+// values found in s. Scheduled values that are due by now are pushed after
+// the values of their queue.
+func (q *Queue) Load(s State) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for key, c := range s.Configs {
+		q.configs[key] = c
+	}
+	for dlq, letters := range s.DeadLetters {
+		q.deadLetters[dlq] = append([]DeadLetter{}, letters...)
+	}
+	for key := range s.Queues {
+		q.drop(key)
+	}
+	for key := range s.Priorities {
+		q.drop(key)
+	}
+	for key, queue := range s.Queues {
 		if len(queue) > 0 {
-			value := queue[0]
-			q.queues[key] = queue[1:]
-			return value, nil
+			q.pushBack(key, queue)
+		}
+	}
+	now := time.Now()
+	for key, values := range s.Priorities {
+		if len(values) == 0 {
+			continue
+		}
+		pq := q.prioritize(key)
+		for _, p := range values {
+			it := newItem(p.Value, now)
+			it.priority = p.Priority
+			pq.pushBack(it)
+			q.count(key).enqueued++
 		}
 	}
 
-	return nil, ErrQueueEmpty
+	q.unschedule(s.Delayed)
+	for key, values := range s.Delayed {
+		for _, d := range values {
+			q.schedule(key, d.At, []interface{}{d.Value})
+		}
+	}
 }
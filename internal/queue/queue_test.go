@@ -0,0 +1,199 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type popResult struct {
+	key   string
+	value interface{}
+	err   error
+}
+
+// bpop starts a blocking pop and waits until it is registered as a waiter
+func bpop(t *testing.T, ctx context.Context, q *Queue, timeout time.Duration, keys ...string) <-chan popResult {
+	q.mu.Lock()
+	n := len(q.waiters[keys[0]])
+	q.mu.Unlock()
+
+	result := make(chan popResult, 1)
+	go func() {
+		key, value, err := q.BPop(ctx, keys, timeout)
+		result <- popResult{key, value, err}
+	}()
+
+	require.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.waiters[keys[0]]) == n+1
+	}, time.Second, time.Millisecond)
+
+	return result
+}
+
+func TestBPopWakesOnPush(t *testing.T) {
+	q := NewQueue()
+	result := bpop(t, context.Background(), q, 0, "q")
+
+	// Through the push channel, as QPUSH does
+	begin := time.Now()
+	require.NoError(t, q.QPush("q", "a"))
+
+	select {
+	case r := <-result:
+		assert.NoError(t, r.err)
+		assert.Equal(t, "a", r.value)
+		assert.Less(t, time.Since(begin), time.Second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("pop was not woken by the push")
+	}
+}
+
+func TestBPopFIFO(t *testing.T) {
+	q := NewQueue()
+
+	var results []<-chan popResult
+	for i := 0; i < 3; i++ {
+		results = append(results, bpop(t, context.Background(), q, 0, "q"))
+	}
+
+	// The length returned is the one before blocked pops are served
+	n, err := q.RPush("q", "a", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "a", (<-results[0]).value)
+	assert.Equal(t, "b", (<-results[1]).value)
+
+	q.LPush("q", "c")
+	assert.Equal(t, "c", (<-results[2]).value)
+	assert.Empty(t, q.waiters)
+}
+
+func TestBPopMultipleKeys(t *testing.T) {
+	q := NewQueue()
+
+	// Keys are checked in order
+	q.Append("low", "l")
+	q.Append("high", "h")
+	key, value, err := q.BPop(context.Background(), []string{"high", "low"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "high", key)
+	assert.Equal(t, "h", value)
+	key, _, _ = q.BPop(context.Background(), []string{"high", "low"}, 0)
+	assert.Equal(t, "low", key)
+
+	// Woken by whichever queue receives data first, and then no longer
+	// waiting on the others
+	result := bpop(t, context.Background(), q, 0, "high", "low", "high")
+	q.Append("low", "a")
+	r := <-result
+	assert.Equal(t, popResult{"low", "a", nil}, r)
+	q.mu.Lock()
+	assert.Empty(t, q.waiters)
+	q.mu.Unlock()
+
+	q.Append("high", "b")
+	assert.Equal(t, 1, q.LLen("high"))
+}
+
+func TestBPopTimeout(t *testing.T) {
+	q := NewQueue()
+
+	begin := time.Now()
+	_, _, err := q.BPop(context.Background(), []string{"q"}, 50*time.Millisecond)
+	assert.Equal(t, ErrQueueEmpty, err)
+	assert.GreaterOrEqual(t, time.Since(begin), 50*time.Millisecond)
+	assert.Empty(t, q.waiters)
+
+	q.Append("q", "a")
+	_, value, err := q.BPop(context.Background(), []string{"q"}, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+}
+
+func TestBPopCancel(t *testing.T) {
+	q := NewQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	first := bpop(t, ctx, q, 0, "q")
+	second := bpop(t, context.Background(), q, 0, "q")
+
+	cancel()
+	r := <-first
+	assert.Equal(t, context.Canceled, r.err)
+
+	// The cancelled pop no longer takes values
+	q.Append("q", "a")
+	assert.Equal(t, "a", (<-second).value)
+	assert.Empty(t, q.waiters)
+}
+
+// A value handed to a pop as it gives up is kept if it timed out, and goes
+// back to the queue if it was cancelled as nobody is left to receive it
+func TestBPopGiveUpAfterHandover(t *testing.T) {
+	q := NewQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, ctx := range []context.Context{context.Background(), ctx} {
+		w := &waiter{keys: []string{"q"}, value: make(chan popped, 1)}
+		q.waiters["q"] = []*waiter{w}
+		q.Append("q", "a")
+		require.Empty(t, q.queues)
+
+		p, err := q.stopWaiting(ctx, w)
+		if ctx.Err() == nil {
+			assert.NoError(t, err)
+			assert.Equal(t, "q", p.key)
+			assert.Equal(t, "a", p.value)
+		} else {
+			assert.Equal(t, context.Canceled, err)
+			value, _ := q.Pop("q")
+			assert.Equal(t, "a", value)
+		}
+		assert.Empty(t, q.waiters)
+	}
+}
+
+// Woken pops leave the value in the queue, one pop being woken for each
+func TestWaitValue(t *testing.T) {
+	q := NewQueue()
+	results := make([]chan error, 2)
+	for i := range results {
+		results[i] = make(chan error, 1)
+		go func(result chan error) { result <- q.WaitValue(context.Background(), []string{"q"}, 0) }(results[i])
+		require.Eventually(t, func() bool {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			return len(q.waiters["q"]) == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	q.Append("q", "a")
+	assert.NoError(t, <-results[0])
+	assert.Equal(t, 1, q.LLen("q"))
+	q.mu.Lock()
+	assert.Len(t, q.waiters["q"], 1)
+	q.mu.Unlock()
+
+	q.Append("q", "b")
+	assert.NoError(t, <-results[1])
+	assert.NoError(t, q.WaitValue(context.Background(), []string{"other", "q"}, 0))
+	assert.Equal(t, ErrQueueEmpty, q.WaitValue(context.Background(), []string{"other"}, time.Millisecond))
+
+	// A woken pop that gives up leaves the value to the next one
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := &waiter{keys: []string{"other"}, value: make(chan popped, 1), wake: true}
+	next := &waiter{keys: []string{"other"}, value: make(chan popped, 1), wake: true}
+	q.waiters["other"] = []*waiter{w, next}
+	q.Append("other", "c")
+	_, err := q.stopWaiting(ctx, w)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, popped{key: "other"}, <-next.value)
+	assert.Equal(t, 1, q.LLen("other"))
+}
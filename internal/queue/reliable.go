@@ -0,0 +1,251 @@
+package queue
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reserving a value instead of popping it leases it to the consumer as a
+// Message. The message is only deleted once it is acked, and is delivered
+// again to the next reservation if it is nacked or if its lease ends first,
+// so no message is lost when a consumer dies while handling it. If the
+// queue has a max attempts, a message that failed that many deliveries is
+// moved to its dead-letter queue instead.
+//
+// Time is passed in rather than read from the clock, so that replaying the
+// same reservations and acks always leads to the same state.
+
+// Message is a value reserved from a queue
+type Message struct {
+	ID    string
+	Value interface{}
+	// Number of times the message has been delivered, this one included
+	Attempts   int
+	EnqueuedAt time.Time
+	// When the lease ends and the message is delivered again
+	Deadline time.Time
+}
+
+// lease is a reserved message with why its last delivery failed and the
+// priority it had in its queue
+type lease struct {
+	Message
+	reason   string
+	priority int64
+}
+
+// leases holds the messages reserved from the queue under one key
+type leases struct {
+	reserved map[string]*lease
+	// Messages whose lease ended, in the order it did. They are delivered
+	// before the values still in the queue.
+	released []*lease
+}
+
+// id returns a new message ID made of the time in milliseconds and a
+// sequence number, such as 1700000000000-0. IDs are unique across queues,
+// so that they stay unique once moved to a dead-letter queue. The caller
+// must hold the lock.
+func (q *Queue) id(now time.Time) string {
+	ms := now.UnixMilli()
+	if ms > q.lastMs {
+		q.lastMs, q.seq = ms, 0
+	} else {
+		q.seq++
+	}
+
+	return strconv.FormatInt(q.lastMs, 10) + "-" + strconv.Itoa(q.seq)
+}
+
+// expire removes the messages whose lease ended at now from the reserved
+// ones and returns them in the order it did
+func (l *leases) expire(now time.Time) []*lease {
+	var expired []*lease
+	for id, m := range l.reserved {
+		if !m.Deadline.After(now) {
+			expired = append(expired, m)
+			delete(l.reserved, id)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].Deadline.Equal(expired[j].Deadline) {
+			return expired[i].Deadline.Before(expired[j].Deadline)
+		}
+		return lessID(expired[i].ID, expired[j].ID)
+	})
+
+	return expired
+}
+
+func (l *leases) empty() bool {
+	return len(l.reserved) == 0 && len(l.released) == 0
+}
+
+// release makes m, whose delivery failed for reason, to be delivered again,
+// unless it reached the max attempts of the queue under key in which case
+// it is moved to its dead-letter queue. The caller must hold the lock.
+func (q *Queue) release(key string, l *leases, m *lease, reason string, now time.Time) {
+	m.reason = reason
+	if c := q.configs[key]; c.MaxAttempts > 0 && m.Attempts >= c.MaxAttempts {
+		q.deadLetter(key, c.DeadLetter, m, now)
+		return
+	}
+
+	l.released = append(l.released, m)
+}
+
+// Reserve leases the next message of the queue under key until now plus
+// visibility. Messages whose lease ended are delivered first, with their
+// attempts counted, then the values of the queue in order.
+func (q *Queue) Reserve(key string, now time.Time, visibility time.Duration) (Message, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	// Set first for the pop not to forget the counts of the queue
+	l, ok := q.leases[key]
+	if !ok {
+		l = &leases{reserved: make(map[string]*lease)}
+		q.leases[key] = l
+	}
+	for _, m := range l.expire(now) {
+		q.release(key, l, m, ReasonExpired, now)
+	}
+
+	var m *lease
+	if len(l.released) > 0 {
+		m = l.released[0]
+		l.released[0] = nil
+		l.released = l.released[1:]
+	} else {
+		it, err := q.popFront(key)
+		if err != nil {
+			if l.empty() {
+				delete(q.leases, key)
+				q.forget(key)
+			}
+			return Message{}, err
+		}
+		m = &lease{Message: Message{ID: q.id(now), Value: it.value, EnqueuedAt: it.pushed()}, priority: it.priority}
+	}
+
+	m.Attempts++
+	m.Deadline = now.Add(visibility)
+	l.reserved[m.ID] = m
+
+	return m.Message, nil
+}
+
+// Ack deletes the messages reserved from the queue under key with the
+// given IDs and returns how many there were. A message whose lease ended
+// can still be acked until it is reserved again.
+func (q *Queue) Ack(key string, ids ...string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.leases[key]
+	if !ok {
+		return 0
+	}
+
+	n := 0
+	for _, id := range ids {
+		if _, ok := l.reserved[id]; ok {
+			delete(l.reserved, id)
+			n++
+			continue
+		}
+		for i, m := range l.released {
+			if m.ID == id {
+				l.released = append(l.released[:i], l.released[i+1:]...)
+				n++
+				break
+			}
+		}
+	}
+	if l.empty() {
+		delete(q.leases, key)
+		q.forget(key)
+	}
+
+	return n
+}
+
+// Nack ends the lease of the messages reserved from the queue under key
+// with the given IDs, so that they are delivered again right away, and
+// returns how many there were. reason tells why they failed, ReasonNacked
+// if empty, and now when, for those moved to the dead-letter queue.
+func (q *Queue) Nack(key, reason string, now time.Time, ids ...string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.leases[key]
+	if !ok {
+		return 0
+	}
+
+	if reason == "" {
+		reason = ReasonNacked
+	}
+
+	n := 0
+	for _, id := range ids {
+		if m, ok := l.reserved[id]; ok {
+			delete(l.reserved, id)
+			q.release(key, l, m, reason, now)
+			n++
+		}
+	}
+	if l.empty() {
+		delete(q.leases, key)
+		q.forget(key)
+	}
+
+	return n
+}
+
+// leased returns the values of the messages reserved from the queue under
+// key with their priority, those waiting to be delivered again first and the
+// others in the order they were reserved. The caller must hold the lock.
+func (q *Queue) leased(key string) []Prioritized {
+	l, ok := q.leases[key]
+	if !ok {
+		return nil
+	}
+
+	values := make([]Prioritized, 0, len(l.released)+len(l.reserved))
+	for _, m := range l.released {
+		values = append(values, Prioritized{Value: m.Value, Priority: m.priority})
+	}
+
+	reserved := make([]*lease, 0, len(l.reserved))
+	for _, m := range l.reserved {
+		reserved = append(reserved, m)
+	}
+	sort.Slice(reserved, func(i, j int) bool {
+		return lessID(reserved[i].ID, reserved[j].ID)
+	})
+	for _, m := range reserved {
+		values = append(values, Prioritized{Value: m.Value, Priority: m.priority})
+	}
+
+	return values
+}
+
+// lessID reports whether the message ID a was handed out before b
+func lessID(a, b string) bool {
+	ams, aseq := splitID(a)
+	bms, bseq := splitID(b)
+
+	return ams < bms || (ams == bms && aseq < bseq)
+}
+
+func splitID(id string) (int64, int) {
+	ms, seq, _ := strings.Cut(id, "-")
+	m, _ := strconv.ParseInt(ms, 10, 64)
+	n, _ := strconv.Atoi(seq)
+
+	return m, n
+}
@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveAck(t *testing.T) {
+	q := NewQueue()
+	q.Append("jobs", "a", "b")
+	now := time.UnixMilli(1700000000000)
+
+	m, err := q.Reserve("jobs", now, time.Minute)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), m.EnqueuedAt, time.Second)
+	assert.Equal(t, Message{ID: "1700000000000-0", Value: "a", Attempts: 1, EnqueuedAt: m.EnqueuedAt, Deadline: now.Add(time.Minute)}, m)
+	m, _ = q.Reserve("jobs", now, time.Minute)
+	assert.Equal(t, "1700000000000-1", m.ID)
+	assert.Equal(t, "b", m.Value)
+
+	_, err = q.Reserve("jobs", now, time.Minute)
+	assert.Equal(t, ErrQueueEmpty, err)
+
+	// IDs keep increasing even if the clock goes back
+	q.Append("jobs", "c")
+	m, _ = q.Reserve("jobs", now.Add(-time.Second), time.Minute)
+	assert.Equal(t, "1700000000000-2", m.ID)
+
+	assert.Equal(t, 3, q.Ack("jobs", "1700000000000-0", "1700000000000-1", "1700000000000-2", "missing"))
+	assert.Equal(t, 0, q.Ack("jobs", "1700000000000-0"))
+	assert.Empty(t, q.leases)
+}
+
+func TestReserveRedelivery(t *testing.T) {
+	q := NewQueue()
+	q.Append("jobs", "a", "b", "c")
+	now := time.UnixMilli(1700000000000)
+
+	a, _ := q.Reserve("jobs", now, time.Second)
+	b, _ := q.Reserve("jobs", now, 2*time.Second)
+
+	// Nacked messages come back before the rest of the queue
+	assert.Equal(t, 1, q.Nack("jobs", "", time.Now(), b.ID))
+	m, _ := q.Reserve("jobs", now, time.Second)
+	assert.Equal(t, b.ID, m.ID)
+	assert.Equal(t, 2, m.Attempts)
+
+	// So do messages whose lease ended, which can still be acked until
+	// reserved again
+	m, _ = q.Reserve("jobs", now.Add(500*time.Millisecond), time.Second)
+	assert.Equal(t, "c", m.Value)
+	_, err := q.Reserve("jobs", now.Add(999*time.Millisecond), time.Second)
+	assert.Equal(t, ErrQueueEmpty, err)
+
+	m, _ = q.Reserve("jobs", now.Add(time.Second), time.Second)
+	assert.Equal(t, Message{ID: a.ID, Value: "a", Attempts: 2, EnqueuedAt: a.EnqueuedAt, Deadline: now.Add(2 * time.Second)}, m)
+	assert.Equal(t, 0, q.Nack("jobs", "", time.Now(), "missing"))
+}
+
+func TestDumpIncludesLeased(t *testing.T) {
+	q := NewQueue()
+	q.Append("jobs", "a", "b", "c", "d")
+	now := time.UnixMilli(1700000000000)
+
+	a, _ := q.Reserve("jobs", now, time.Second)
+	q.Reserve("jobs", now, time.Second)
+	q.Reserve("jobs", now, time.Second)
+	q.Nack("jobs", "", time.Now(), a.ID)
+
+	assert.Equal(t, map[string][]interface{}{"jobs": {"a", "b", "c", "d"}}, q.Dump().Queues)
+
+	q.Load(q.Dump())
+	assert.Empty(t, q.leases)
+	assert.Equal(t, 4, q.LLen("jobs"))
+}
+
+func TestDeadLetter(t *testing.T) {
+	q := NewQueue()
+	max := 2
+	dlq := "jobs:dead"
+	_, err := q.Configure("jobs", ConfigUpdate{MaxAttempts: &max})
+	assert.Equal(t, ErrNoDeadLetterQueue, err)
+	c, err := q.Configure("jobs", ConfigUpdate{MaxAttempts: &max, DeadLetter: &dlq})
+	require.NoError(t, err)
+	assert.Equal(t, Config{MaxAttempts: 2, DeadLetter: dlq}, c)
+
+	q.Append("jobs", "a", "b")
+	now := time.UnixMilli(1700000000000)
+
+	// A message is dead once its last attempt is nacked or its lease ends
+	a, _ := q.Reserve("jobs", now, time.Second)
+	q.Nack("jobs", "", time.Now(), a.ID)
+	q.Reserve("jobs", now, time.Second)
+	assert.Equal(t, 1, q.Nack("jobs", "boom", time.Now(), a.ID))
+
+	b, _ := q.Reserve("jobs", now, time.Second)
+	q.Reserve("jobs", now.Add(time.Second), time.Second)
+	_, err = q.Reserve("jobs", now.Add(2*time.Second), time.Second)
+	assert.Equal(t, ErrQueueEmpty, err)
+	assert.Empty(t, q.leases)
+
+	letters := q.DeadLetters(dlq, 0, -1)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "jobs/"+a.ID, letters[0].ID)
+	assert.Equal(t, "a", letters[0].Value)
+	assert.Equal(t, "boom", letters[0].Reason)
+	assert.Equal(t, 2, letters[0].Attempts)
+	assert.Equal(t, a.EnqueuedAt, letters[0].EnqueuedAt)
+	assert.Equal(t, DeadLetter{
+		ID:         "jobs/" + b.ID,
+		Value:      "b",
+		Queue:      "jobs",
+		Attempts:   2,
+		Reason:     ReasonExpired,
+		EnqueuedAt: b.EnqueuedAt,
+		DeadAt:     now.Add(2 * time.Second),
+	}, letters[1])
+
+	d, err := q.DeadLetter(dlq, "jobs/"+b.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, letters[1], d)
+	_, err = q.DeadLetter(dlq, "missing")
+	assert.Equal(t, ErrMessageNotFound, err)
+
+	// Requeued messages start over
+	assert.Equal(t, 1, q.Requeue(dlq, "jobs/"+a.ID, "missing"))
+	m, _ := q.Reserve("jobs", now.Add(3*time.Second), time.Second)
+	assert.Equal(t, "a", m.Value)
+	assert.Equal(t, 1, m.Attempts)
+
+	assert.Equal(t, 1, q.Purge(dlq))
+	assert.Empty(t, q.deadLetters)
+}
+
+func TestDumpLoadState(t *testing.T) {
+	q := NewQueue()
+	max := 1
+	dlq := "dead"
+	q.Configure("jobs", ConfigUpdate{MaxAttempts: &max, DeadLetter: &dlq})
+	q.Append("jobs", "a", "b")
+	m, _ := q.Reserve("jobs", time.Now(), time.Minute)
+	q.Nack("jobs", "", time.Now(), m.ID)
+
+	s := q.Dump()
+	assert.Equal(t, map[string][]interface{}{"jobs": {"b"}}, s.Queues)
+	assert.Equal(t, map[string]Config{"jobs": {MaxAttempts: 1, DeadLetter: "dead"}}, s.Configs)
+	require.Len(t, s.DeadLetters["dead"], 1)
+
+	loaded := NewQueue()
+	loaded.Load(s)
+	assert.Equal(t, s, loaded.Dump())
+}
@@ -0,0 +1,156 @@
+package queue
+
+import "time"
+
+// Stats describes the queue under a key
+type Stats struct {
+	Len int
+	// Values pushed to and popped from the queue since it was created,
+	// reservations counting as pops. A queue without settings starts over
+	// once it is empty with nothing reserved from it.
+	Enqueued uint64
+	Dequeued uint64
+	// When the value pushed longest ago that is still in the queue was
+	// pushed, zero if the queue is empty
+	OldestPushedAt time.Time
+	// Pops blocked on the queue
+	Waiters int
+	// Pushes refused because the queue was full, and values dropped to
+	// make room, counted as Enqueued is
+	Rejected uint64
+	Dropped  uint64
+}
+
+// counts holds the number of values pushed to and popped from a queue, and
+// of those that did not fit. They are kept while the queue holds values,
+// has messages reserved, settings or pops blocked on it, so that they keep
+// adding up without piling up for every key ever pushed to.
+type counts struct {
+	enqueued uint64
+	dequeued uint64
+	rejected uint64
+	dropped  uint64
+}
+
+// count returns the counts of the queue under key. The caller must hold
+// the lock.
+func (q *Queue) count(key string) *counts {
+	c, ok := q.counts[key]
+	if !ok {
+		c = &counts{}
+		q.counts[key] = c
+	}
+
+	return c
+}
+
+// forget drops the counts of the queue under key once there is nothing
+// left to keep them for. The caller must hold the lock.
+func (q *Queue) forget(key string) {
+	if q.length(key) > 0 || len(q.waiters[key]) > 0 {
+		return
+	}
+	if _, ok := q.leases[key]; ok {
+		return
+	}
+	if _, ok := q.configs[key]; ok {
+		return
+	}
+
+	delete(q.counts, key)
+}
+
+// Peek returns the value at the head of the queue under key without
+// popping it
+func (q *Queue) Peek(key string) (interface{}, error) {
+	q.lock()
+	defer q.mu.Unlock()
+
+	it, ok := q.head(key)
+	if !ok {
+		return nil, ErrQueueEmpty
+	}
+
+	return it.value, nil
+}
+
+// head returns the item popped next from the queue under key. The caller
+// must hold the lock.
+func (q *Queue) head(key string) (item, bool) {
+	if pq, ok := q.priorities[key]; ok {
+		return pq.entries[0].item, true
+	}
+	if queue, ok := q.queues[key]; ok {
+		return queue.at(0), true
+	}
+
+	return item{}, false
+}
+
+// oldest returns the value pushed longest ago to the queue under key with
+// its index, in the heap of a priority queue. Values pushed at the head and
+// inserted ones leave it anywhere, in which case they are all looked at.
+// The caller must hold the lock.
+func (q *Queue) oldest(key string) (int, item, bool) {
+	if pq, ok := q.priorities[key]; ok {
+		i := 0
+		for j, e := range pq.entries {
+			if e.pushedAt < pq.entries[i].pushedAt {
+				i = j
+			}
+		}
+		return i, pq.entries[i].item, true
+	}
+
+	queue, ok := q.queues[key]
+	if !ok {
+		return 0, item{}, false
+	}
+	i := 0
+	for j := 1; queue.unordered && j < queue.len(); j++ {
+		if queue.at(j).pushedAt < queue.at(i).pushedAt {
+			i = j
+		}
+	}
+
+	return i, queue.at(i), true
+}
+
+// Stats returns the stats of the queue under key
+func (q *Queue) Stats(key string) Stats {
+	q.lock()
+	defer q.mu.Unlock()
+
+	return q.stats(key)
+}
+
+// AllStats returns the stats of every queue that holds values, has pops
+// blocked on it or has counts kept
+func (q *Queue) AllStats() map[string]Stats {
+	q.lock()
+	defer q.mu.Unlock()
+
+	stats := make(map[string]Stats, len(q.counts))
+	for key := range q.counts {
+		stats[key] = q.stats(key)
+	}
+	for key := range q.waiters {
+		stats[key] = q.stats(key)
+	}
+
+	return stats
+}
+
+// stats is Stats for a caller holding the lock
+func (q *Queue) stats(key string) Stats {
+	s := Stats{Len: q.length(key), Waiters: len(q.waiters[key])}
+	if c, ok := q.counts[key]; ok {
+		s.Enqueued, s.Dequeued = c.enqueued, c.dequeued
+		s.Rejected, s.Dropped = c.rejected, c.dropped
+	}
+	if _, it, ok := q.oldest(key); ok {
+		s.OldestPushedAt = it.pushed()
+	}
+
+	return s
+}
@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeek(t *testing.T) {
+	q := NewQueue()
+	_, err := q.Peek("q")
+	assert.Equal(t, ErrQueueEmpty, err)
+
+	q.Append("q", "a", "b")
+	value, err := q.Peek("q")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+	assert.Equal(t, 2, q.LLen("q"))
+
+	q.PushPriority("q", 1, "c")
+	value, _ = q.Peek("q")
+	assert.Equal(t, "c", value)
+}
+
+func TestStats(t *testing.T) {
+	q := NewQueue()
+	assert.Equal(t, Stats{}, q.Stats("q"))
+
+	begin := time.Now()
+	q.Append("q", "a", "b")
+	q.LPush("q", "c")
+	q.Pop("q")
+	q.RPop("q")
+	m, _ := q.Reserve("q", time.Now(), time.Minute)
+	q.PushPriority("q", 1, "d")
+
+	s := q.Stats("q")
+	assert.Equal(t, 1, s.Len)
+	assert.Equal(t, uint64(4), s.Enqueued)
+	assert.Equal(t, uint64(3), s.Dequeued)
+	assert.False(t, s.OldestPushedAt.Before(begin))
+
+	// Counts are kept once the queue is empty while a message is reserved
+	// from it
+	q.Pop("q")
+	assert.Equal(t, Stats{Enqueued: 4, Dequeued: 4}, q.Stats("q"))
+
+	result := bpop(t, context.Background(), q, 0, "other")
+	assert.Equal(t, map[string]Stats{
+		"q":     {Enqueued: 4, Dequeued: 4},
+		"other": {Waiters: 1},
+	}, q.AllStats())
+	q.Append("other", "e")
+	<-result
+
+	// and forgotten once nothing is left
+	assert.Equal(t, Stats{}, q.Stats("other"))
+	q.Ack("q", m.ID)
+	assert.Empty(t, q.AllStats())
+	assert.Empty(t, q.counts)
+
+	// unless the queue has settings
+	limit(t, q, "q", 10, OverflowReject, 0)
+	q.Append("q", "f")
+	q.Pop("q")
+	assert.Equal(t, Stats{Enqueued: 1, Dequeued: 1}, q.Stats("q"))
+	limit(t, q, "q", 0, "", 0)
+	assert.Empty(t, q.counts)
+}
+
+// The oldest value is not always at the head
+func TestStatsOldest(t *testing.T) {
+	q := NewQueue()
+	q.RPush("q", "a")
+	pushedAt := q.Stats("q").OldestPushedAt
+	time.Sleep(time.Millisecond)
+	q.LPush("q", "b")
+	q.LInsert("q", false, "b", "c")
+	assert.Equal(t, pushedAt, q.Stats("q").OldestPushedAt)
+
+	q.PushPriority("p", 1, "low")
+	pushedAt = q.Stats("p").OldestPushedAt
+	time.Sleep(time.Millisecond)
+	q.PushPriority("p", 5, "high")
+	assert.Equal(t, pushedAt, q.Stats("p").OldestPushedAt)
+}
+
+// A value handed to a pop that gives up goes back to its queue uncounted
+func TestStatsCancelledPop(t *testing.T) {
+	q := NewQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &waiter{keys: []string{"q"}, value: make(chan popped, 1)}
+	q.waiters["q"] = []*waiter{w}
+	q.Append("q", "a")
+	cancel()
+
+	_, err := q.stopWaiting(ctx, w)
+	assert.Equal(t, context.Canceled, err)
+	s := q.Stats("q")
+	assert.Equal(t, 1, s.Len)
+	assert.Equal(t, uint64(1), s.Enqueued)
+	assert.Equal(t, uint64(0), s.Dequeued)
+}
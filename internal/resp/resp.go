@@ -0,0 +1,223 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrProtocol = errors.New("protocol error")
+)
+
+const (
+	maxBulkLen   = 512 * 1024 * 1024
+	maxArrayLen  = 1024 * 1024
+	maxInlineLen = 64 * 1024
+	// Most memory set aside for a bulk string or an array before its
+	// contents arrive, so that a length announced by a client only costs
+	// memory once it is sent
+	maxPrealloc = 64 * 1024
+)
+
+// Reader reads RESP2 commands, either as arrays of bulk strings or as inline
+// commands separated by spaces.
+type Reader struct {
+	rd *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{rd: bufio.NewReader(r)}
+}
+
+// Buffered returns the number of bytes that have already been received but
+// not consumed, which is non-zero when a client pipelines its requests.
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+// ReadCommand returns the next command with its arguments. Empty inline
+// lines are skipped.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] != '*' {
+			args := strings.Fields(line)
+			if len(args) == 0 {
+				continue
+			}
+			return args, nil
+		}
+
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxArrayLen {
+			return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+		}
+		if n <= 0 {
+			continue
+		}
+
+		args := make([]string, 0, prealloc(n))
+		for len(args) < n {
+			arg, err := r.readBulk()
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+
+		return args, nil
+	}
+}
+
+func (r *Reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("%w: expected '$', got '%.1s'", ErrProtocol, line)
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxBulkLen {
+		return "", fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+
+	// The buffer grows as the data arrives
+	var buf bytes.Buffer
+	buf.Grow(prealloc(n + 2))
+	if _, err := io.CopyN(&buf, r.rd, int64(n+2)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	b := buf.Bytes()
+	if b[n] != '\r' || b[n+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+
+	return string(b[:n]), nil
+}
+
+// prealloc returns how much of a length announced by a client to set aside
+// up front
+func prealloc(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
+// readLine returns the next line without its line ending. It fails as soon
+// as the line is longer than maxInlineLen, rather than buffering it whole.
+func (r *Reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.rd.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInlineLen+2 {
+			return "", fmt.Errorf("%w: too big inline request", ErrProtocol)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+
+		return strings.TrimSuffix(string(line[:len(line)-1]), "\r"), nil
+	}
+}
+
+// Writer writes RESP2 replies. Replies are buffered until Flush is called.
+type Writer struct {
+	wr *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{wr: bufio.NewWriter(w)}
+}
+
+func (w *Writer) WriteSimpleString(s string) error {
+	_, err := w.wr.WriteString("+" + s + "\r\n")
+	return err
+}
+
+// WriteError writes an error reply. The message should start with an error
+// prefix such as ERR or WRONGTYPE.
+func (w *Writer) WriteError(msg string) error {
+	// Error replies cannot span lines
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	_, err := w.wr.WriteString("-" + msg + "\r\n")
+	return err
+}
+
+func (w *Writer) WriteInteger(n int64) error {
+	_, err := w.wr.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+	return err
+}
+
+func (w *Writer) WriteBulkString(s string) error {
+	_, err := w.wr.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+	return err
+}
+
+// WriteNull writes the null bulk string
+func (w *Writer) WriteNull() error {
+	_, err := w.wr.WriteString("$-1\r\n")
+	return err
+}
+
+// WriteNullArray writes the null array, used for timed out blocking
+// operations
+func (w *Writer) WriteNullArray() error {
+	_, err := w.wr.WriteString("*-1\r\n")
+	return err
+}
+
+// WriteArrayHeader starts an array of n elements, which must be written
+// right after it.
+func (w *Writer) WriteArrayHeader(n int) error {
+	_, err := w.wr.WriteString("*" + strconv.Itoa(n) + "\r\n")
+	return err
+}
+
+// WriteCommand writes args as an array of bulk strings, which is how
+// commands are sent to a RESP server.
+func (w *Writer) WriteCommand(args ...string) error {
+	if err := w.WriteArrayHeader(len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := w.WriteBulkString(arg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Writer) Flush() error {
+	return w.wr.Flush()
+}
@@ -0,0 +1,59 @@
+package resp
+
+import (
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCommand(t *testing.T) {
+	r := NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$0\r\n\r\nPING  a\r\n"))
+
+	args, err := r.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET", ""}, args)
+	args, err = r.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PING", "a"}, args)
+	_, err = r.ReadCommand()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = NewReader(strings.NewReader("*1\r\n$3\r\nGETX\r\n")).ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+// Announced lengths only cost memory once the data is sent
+func TestReadCommandTruncated(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	_, err := NewReader(strings.NewReader("*1000000\r\n$536870912\r\nabc")).ReadCommand()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	runtime.ReadMemStats(&after)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(4<<20))
+}
+
+// endless is a client that sends a line that never ends
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestReadCommandLineTooLong(t *testing.T) {
+	_, err := NewReader(endless{}).ReadCommand()
+	assert.ErrorIs(t, err, ErrProtocol)
+
+	line := strings.Repeat("a", maxInlineLen)
+	args, err := NewReader(strings.NewReader(line + "\r\n")).ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{line}, args)
+}
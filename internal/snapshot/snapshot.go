@@ -0,0 +1,674 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+)
+
+var (
+	ErrBadMagic    = errors.New("not a snapshot file")
+	ErrBadVersion  = errors.New("unsupported snapshot version")
+	ErrBadChecksum = errors.New("snapshot checksum mismatch")
+	ErrNoSnapshot  = errors.New("no valid snapshot found")
+)
+
+const (
+	magic   = "GOKVSNAP"
+	version = 1
+
+	filePrefix = "snapshot-"
+	fileSuffix = ".gkvs"
+
+	// Guards against allocating huge slices for corrupt length fields
+	maxLen = 1 << 30
+)
+
+// Value tags
+const (
+	tagNil byte = iota
+	tagString
+	tagFloat
+	tagInt
+	tagTrue
+	tagFalse
+	tagArray
+	tagObject
+	tagHash
+	tagSet
+	tagZSet
+)
+
+// Snapshot is a point-in-time copy of the keyspace and of every queue
+type Snapshot struct {
+	CreatedAt    time.Time
+	Keys         map[string]kvstore.KeyValue
+	Queues       map[string][]interface{}
+	QueueConfigs map[string]queue.Config
+	DeadLetters  map[string][]queue.DeadLetter
+	Delayed      map[string][]queue.Delayed
+	Priorities   map[string][]queue.Prioritized
+}
+
+// Write encodes s. The encoding is:
+//
+//	magic | version | created at | #keys | keys | #queues | queues |
+//	#queue configs | queue configs | #dead-letter queues | dead letters |
+//	#delayed queues | delayed values | #priority queues | priority queues |
+//	crc32
+//
+// where numbers are varints, strings are length prefixed and every value
+// starts with a one byte tag. The CRC32 covers everything before it.
+func Write(w io.Writer, s *Snapshot) error {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	e := &encoder{w: bw}
+
+	e.raw([]byte(magic))
+	e.raw([]byte{version})
+	e.varint(s.CreatedAt.UnixNano())
+
+	e.uvarint(uint64(len(s.Keys)))
+	for key, kv := range s.Keys {
+		e.string(key)
+		if kv.ExpiresAt.IsZero() {
+			e.varint(0)
+		} else {
+			e.varint(kv.ExpiresAt.UnixNano())
+		}
+		e.value(kv.Value)
+	}
+
+	e.uvarint(uint64(len(s.Queues)))
+	for key, values := range s.Queues {
+		e.string(key)
+		e.uvarint(uint64(len(values)))
+		for _, v := range values {
+			e.value(v)
+		}
+	}
+
+	e.uvarint(uint64(len(s.QueueConfigs)))
+	for key, c := range s.QueueConfigs {
+		e.string(key)
+		e.uvarint(uint64(c.MaxAttempts))
+		e.string(c.DeadLetter)
+		e.uvarint(uint64(c.MaxLen))
+		e.string(string(c.Overflow))
+		e.varint(int64(c.BlockTimeout))
+	}
+
+	e.uvarint(uint64(len(s.DeadLetters)))
+	for dlq, letters := range s.DeadLetters {
+		e.string(dlq)
+		e.uvarint(uint64(len(letters)))
+		for _, d := range letters {
+			e.string(d.ID)
+			e.value(d.Value)
+			e.string(d.Queue)
+			e.uvarint(uint64(d.Attempts))
+			e.string(d.Reason)
+			e.time(d.EnqueuedAt)
+			e.time(d.DeadAt)
+		}
+	}
+
+	e.uvarint(uint64(len(s.Delayed)))
+	for key, values := range s.Delayed {
+		e.string(key)
+		e.uvarint(uint64(len(values)))
+		for _, d := range values {
+			e.time(d.At)
+			e.value(d.Value)
+		}
+	}
+
+	e.uvarint(uint64(len(s.Priorities)))
+	for key, values := range s.Priorities {
+		e.string(key)
+		e.uvarint(uint64(len(values)))
+		for _, p := range values {
+			e.varint(p.Priority)
+			e.value(p.Value)
+		}
+	}
+
+	if e.err != nil {
+		return e.err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// Read decodes a snapshot written by Write. Keys that have already expired
+// are dropped.
+func Read(r io.Reader) (*Snapshot, error) {
+	d := &decoder{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+
+	head := d.raw(len(magic) + 1)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, ErrBadMagic
+	}
+	if head[len(magic)] != version {
+		return nil, ErrBadVersion
+	}
+
+	s := &Snapshot{
+		CreatedAt:    time.Unix(0, d.varint()),
+		Keys:         make(map[string]kvstore.KeyValue),
+		Queues:       make(map[string][]interface{}),
+		QueueConfigs: make(map[string]queue.Config),
+		DeadLetters:  make(map[string][]queue.DeadLetter),
+		Delayed:      make(map[string][]queue.Delayed),
+		Priorities:   make(map[string][]queue.Prioritized),
+	}
+	now := time.Now()
+
+	for n := d.length(); n > 0 && d.err == nil; n-- {
+		key := d.string()
+		var expiresAt time.Time
+		if nanos := d.varint(); nanos != 0 {
+			expiresAt = time.Unix(0, nanos)
+		}
+		value := d.value()
+
+		if !expiresAt.IsZero() && !now.Before(expiresAt) {
+			continue
+		}
+		s.Keys[key] = kvstore.KeyValue{Value: value, ExpiresAt: expiresAt}
+	}
+
+	for n := d.length(); n > 0 && d.err == nil; n-- {
+		key := d.string()
+		m := d.length()
+		values := make([]interface{}, 0, capHint(m))
+		for ; m > 0 && d.err == nil; m-- {
+			values = append(values, d.value())
+		}
+		s.Queues[key] = values
+	}
+
+	d.queueConfigs(s)
+	d.deadLetters(s)
+	d.delayed(s)
+	d.priorities(s)
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	want := d.crc.Sum32()
+
+	var sum [4]byte
+	if _, err := io.ReadFull(d.r, sum[:]); err != nil {
+		return nil, unexpected(err)
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != want {
+		return nil, ErrBadChecksum
+	}
+
+	return s, nil
+}
+
+// Save writes s to a new file in dir and removes all but the keep most
+// recent snapshots. The file is written under a temporary name and renamed
+// once synced, so a crash never leaves a partial snapshot behind.
+func Save(dir string, s *Snapshot, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s%020d%s", filePrefix, s.CreatedAt.UnixNano(), fileSuffix))
+
+	f, err := os.CreateTemp(dir, filePrefix+"*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	if err := Write(f, s); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	if keep > 0 {
+		paths, _ := list(dir)
+		for len(paths) > keep {
+			os.Remove(paths[0])
+			paths = paths[1:]
+		}
+	}
+
+	return path, nil
+}
+
+// LoadLatest reads the most recent valid snapshot in dir, skipping over
+// files that are corrupt. It returns ErrNoSnapshot if there is none.
+func LoadLatest(dir string) (*Snapshot, string, error) {
+	paths, err := list(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", ErrNoSnapshot
+		}
+		return nil, "", err
+	}
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		s, err := load(paths[i])
+		if err != nil {
+			log.Printf("snapshot: skipping %s: %v", paths[i], err)
+			continue
+		}
+		return s, paths[i], nil
+	}
+
+	return nil, "", ErrNoSnapshot
+}
+
+func load(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// list returns the snapshot files in dir, oldest first
+func list(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (e *encoder) raw(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *encoder) tag(t byte) {
+	if e.err == nil {
+		e.err = e.w.WriteByte(t)
+	}
+}
+
+func (e *encoder) uvarint(n uint64) {
+	e.raw(e.buf[:binary.PutUvarint(e.buf[:], n)])
+}
+
+func (e *encoder) varint(n int64) {
+	e.raw(e.buf[:binary.PutVarint(e.buf[:], n)])
+}
+
+func (e *encoder) float(f float64) {
+	binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(f))
+	e.raw(e.buf[:8])
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+// time encodes t in nanoseconds, the zero time as 0
+func (e *encoder) time(t time.Time) {
+	if t.IsZero() {
+		e.varint(0)
+	} else {
+		e.varint(t.UnixNano())
+	}
+}
+
+func (e *encoder) value(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.tag(tagNil)
+	case string:
+		e.tag(tagString)
+		e.string(v)
+	case float64:
+		e.tag(tagFloat)
+		e.float(v)
+	case int:
+		e.tag(tagInt)
+		e.varint(int64(v))
+	case int64:
+		e.tag(tagInt)
+		e.varint(v)
+	case bool:
+		if v {
+			e.tag(tagTrue)
+		} else {
+			e.tag(tagFalse)
+		}
+	case []interface{}:
+		e.tag(tagArray)
+		e.uvarint(uint64(len(v)))
+		for _, elem := range v {
+			e.value(elem)
+		}
+	case map[string]interface{}:
+		e.tag(tagObject)
+		e.uvarint(uint64(len(v)))
+		for key, elem := range v {
+			e.string(key)
+			e.value(elem)
+		}
+	case kvstore.Hash:
+		e.tag(tagHash)
+		e.uvarint(uint64(len(v)))
+		for field, value := range v {
+			e.string(field)
+			e.string(value)
+		}
+	case kvstore.Set:
+		e.tag(tagSet)
+		e.uvarint(uint64(len(v)))
+		for member := range v {
+			e.string(member)
+		}
+	case *kvstore.ZSet:
+		e.tag(tagZSet)
+		e.uvarint(uint64(v.Len()))
+		for _, m := range v.Members() {
+			e.string(m.Member)
+			e.float(m.Score)
+		}
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("snapshot: cannot encode value of type %T", v)
+		}
+	}
+}
+
+type decoder struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (d *decoder) raw(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = unexpected(err)
+		return nil
+	}
+	d.crc.Write(b)
+
+	return b
+}
+
+func (d *decoder) ReadByte() (byte, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.err = unexpected(err)
+		return 0, d.err
+	}
+	d.crc.Write([]byte{b})
+
+	return b, nil
+}
+
+func (d *decoder) byte() byte {
+	b, _ := d.ReadByte()
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, err := binary.ReadUvarint(d)
+	if err != nil && d.err == nil {
+		d.err = unexpected(err)
+	}
+	return n
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, err := binary.ReadVarint(d)
+	if err != nil && d.err == nil {
+		d.err = unexpected(err)
+	}
+	return n
+}
+
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > maxLen && d.err == nil {
+		d.err = fmt.Errorf("snapshot: length %d out of range", n)
+	}
+	if d.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) float() float64 {
+	b := d.raw(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (d *decoder) string() string {
+	return string(d.raw(d.length()))
+}
+
+func (d *decoder) time() time.Time {
+	if nanos := d.varint(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+func (d *decoder) queueConfigs(s *Snapshot) {
+	for n := d.length(); n > 0 && d.err == nil; n-- {
+		key := d.string()
+		c := queue.Config{MaxAttempts: d.length()}
+		c.DeadLetter = d.string()
+		c.MaxLen = d.length()
+		c.Overflow = queue.Overflow(d.string())
+		c.BlockTimeout = time.Duration(d.varint())
+		s.QueueConfigs[key] = c
+	}
+}
+
+func (d *decoder) deadLetters(s *Snapshot) {
+	for n := d.length(); n > 0 && d.err == nil; n-- {
+		dlq := d.string()
+		m := d.length()
+		letters := make([]queue.DeadLetter, 0, capHint(m))
+		for ; m > 0 && d.err == nil; m-- {
+			var l queue.DeadLetter
+			l.ID = d.string()
+			l.Value = d.value()
+			l.Queue = d.string()
+			l.Attempts = d.length()
+			l.Reason = d.string()
+			l.EnqueuedAt = d.time()
+			l.DeadAt = d.time()
+			letters = append(letters, l)
+		}
+		s.DeadLetters[dlq] = letters
+	}
+}
+
+func (d *decoder) delayed(s *Snapshot) {
+	for n := d.length(); n > 0 && d.err == nil; n-- {
+		key := d.string()
+		m := d.length()
+		values := make([]queue.Delayed, 0, capHint(m))
+		for ; m > 0 && d.err == nil; m-- {
+			at := d.time()
+			values = append(values, queue.Delayed{At: at, Value: d.value()})
+		}
+		s.Delayed[key] = values
+	}
+}
+
+func (d *decoder) priorities(s *Snapshot) {
+	for n := d.length(); n > 0 && d.err == nil; n-- {
+		key := d.string()
+		m := d.length()
+		values := make([]queue.Prioritized, 0, capHint(m))
+		for ; m > 0 && d.err == nil; m-- {
+			priority := d.varint()
+			values = append(values, queue.Prioritized{Priority: priority, Value: d.value()})
+		}
+		s.Priorities[key] = values
+	}
+}
+
+func (d *decoder) value() interface{} {
+	switch tag := d.byte(); tag {
+	case tagNil:
+		return nil
+	case tagString:
+		return d.string()
+	case tagFloat:
+		f := d.float()
+		if d.err != nil {
+			return nil
+		}
+		return f
+	case tagInt:
+		return d.varint()
+	case tagTrue:
+		return true
+	case tagFalse:
+		return false
+	case tagArray:
+		n := d.length()
+		values := make([]interface{}, 0, capHint(n))
+		for ; n > 0 && d.err == nil; n-- {
+			values = append(values, d.value())
+		}
+		return values
+	case tagObject:
+		n := d.length()
+		values := make(map[string]interface{})
+		for ; n > 0 && d.err == nil; n-- {
+			key := d.string()
+			values[key] = d.value()
+		}
+		return values
+	case tagHash:
+		n := d.length()
+		h := make(kvstore.Hash, capHint(n))
+		for ; n > 0 && d.err == nil; n-- {
+			field := d.string()
+			h[field] = d.string()
+		}
+		return h
+	case tagSet:
+		n := d.length()
+		set := make(kvstore.Set, capHint(n))
+		for ; n > 0 && d.err == nil; n-- {
+			set[d.string()] = struct{}{}
+		}
+		return set
+	case tagZSet:
+		n := d.length()
+		members := make([]kvstore.ZMember, 0, capHint(n))
+		for ; n > 0 && d.err == nil; n-- {
+			member := d.string()
+			members = append(members, kvstore.ZMember{Member: member, Score: d.float()})
+		}
+		return kvstore.NewZSet(members...)
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("snapshot: unknown value tag %d", tag)
+		}
+		return nil
+	}
+}
+
+// capHint bounds preallocations by a length read from the file, which may be
+// corrupt
+func capHint(n int) int {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package snapshot
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSnapshot(createdAt time.Time) *Snapshot {
+	return &Snapshot{
+		CreatedAt: createdAt,
+		Keys: map[string]kvstore.KeyValue{
+			"plain":   {Value: "value1"},
+			"expires": {Value: "value2", ExpiresAt: time.Now().Add(time.Hour).Round(0)},
+			"expired": {Value: "value3", ExpiresAt: time.Now().Add(-time.Second)},
+			"hash":    {Value: kvstore.Hash{"field": "value4"}},
+			"set":     {Value: kvstore.Set{"a": {}, "b": {}}},
+			"zset":    {Value: kvstore.NewZSet(kvstore.ZMember{Member: "a", Score: 2}, kvstore.ZMember{Member: "b", Score: -1.5})},
+		},
+		Queues: map[string][]interface{}{
+			"queue1": {"a", 1.5, true, nil, []interface{}{"b"}, map[string]interface{}{"c": false}},
+		},
+		QueueConfigs: map[string]queue.Config{
+			"queue1": {MaxAttempts: 3, DeadLetter: "dead"},
+			"queue2": {MaxLen: 10, Overflow: queue.OverflowBlock, BlockTimeout: time.Second},
+		},
+		DeadLetters: map[string][]queue.DeadLetter{
+			"dead": {{
+				ID:         "queue1/1700000000000-0",
+				Value:      "d",
+				Queue:      "queue1",
+				Attempts:   3,
+				Reason:     queue.ReasonExpired,
+				EnqueuedAt: time.UnixMilli(1700000000000),
+				DeadAt:     time.UnixMilli(1700000003000),
+			}},
+		},
+		Delayed: map[string][]queue.Delayed{
+			"queue1": {{Value: "e", At: time.UnixMilli(1700000004000)}},
+		},
+		Priorities: map[string][]queue.Prioritized{
+			"queue2": {{Value: "f", Priority: 5}, {Value: "g", Priority: -1}},
+		},
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	in := testSnapshot(time.Unix(0, 1234))
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, in))
+
+	out, err := Read(&buf)
+	require.NoError(t, err)
+
+	assert.True(t, in.CreatedAt.Equal(out.CreatedAt))
+	assert.Equal(t, in.Queues, out.Queues)
+	assert.Equal(t, in.QueueConfigs, out.QueueConfigs)
+	assert.Equal(t, in.DeadLetters, out.DeadLetters)
+	assert.Equal(t, in.Delayed, out.Delayed)
+	assert.Equal(t, in.Priorities, out.Priorities)
+	assert.Len(t, out.Keys, 5)
+	assert.Equal(t, in.Keys["plain"], out.Keys["plain"])
+	assert.Equal(t, in.Keys["hash"], out.Keys["hash"])
+	assert.Equal(t, in.Keys["set"], out.Keys["set"])
+	assert.Equal(t, in.Keys["zset"].Value.(*kvstore.ZSet).Members(), out.Keys["zset"].Value.(*kvstore.ZSet).Members())
+	assert.Equal(t, "value2", out.Keys["expires"].Value)
+	assert.True(t, in.Keys["expires"].ExpiresAt.Equal(out.Keys["expires"].ExpiresAt))
+	assert.NotContains(t, out.Keys, "expired")
+}
+
+func TestReadCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, testSnapshot(time.Now())))
+	data := buf.Bytes()
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)/2] ^= 0xff
+	_, err := Read(bytes.NewReader(flipped))
+	assert.Error(t, err)
+
+	_, err = Read(bytes.NewReader(data[:len(data)-1]))
+	assert.Error(t, err)
+
+	_, err = Read(bytes.NewReader([]byte("not a snapshot at all")))
+	assert.Equal(t, ErrBadMagic, err)
+
+	newer := append([]byte(nil), data...)
+	newer[len(magic)]++
+	_, err = Read(bytes.NewReader(newer))
+	assert.Equal(t, ErrBadVersion, err)
+}
+
+func TestSaveLoadLatest(t *testing.T) {
+	dir := t.TempDir()
+
+	_, _, err := LoadLatest(dir)
+	assert.Equal(t, ErrNoSnapshot, err)
+
+	older := testSnapshot(time.Unix(100, 0))
+	_, err = Save(dir, older, 2)
+	require.NoError(t, err)
+
+	newer := testSnapshot(time.Unix(200, 0))
+	newer.Queues["queue2"] = []interface{}{"x"}
+	path, err := Save(dir, newer, 2)
+	require.NoError(t, err)
+
+	snap, loaded, err := LoadLatest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, path, loaded)
+	assert.Contains(t, snap.Queues, "queue2")
+
+	// A corrupt latest snapshot falls back to the previous one
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0644))
+	snap, _, err = LoadLatest(dir)
+	require.NoError(t, err)
+	assert.NotContains(t, snap.Queues, "queue2")
+
+	// Only the two most recent snapshots are kept
+	_, err = Save(dir, testSnapshot(time.Unix(300, 0)), 2)
+	require.NoError(t, err)
+	paths, err := list(dir)
+	require.NoError(t, err)
+	assert.Len(t, paths, 2)
+}
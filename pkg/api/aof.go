@@ -0,0 +1,944 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/aof"
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/sprectza/go-kvstore/pkg/model"
+)
+
+const numAOFLocks = 256
+
+// aofMiddleware records every mutating call in an append-only file. Calls
+// that only read are served by the embedded Service.
+type aofMiddleware struct {
+	Service
+	log *aof.AOF
+
+	// A mutation and its log entry happen under the lock of the key, so
+	// that the file has the same order of operations as the store
+	locks [numAOFLocks]sync.Mutex
+}
+
+func NewAOFMiddleware(log *aof.AOF, next Service) Service {
+	mw := &aofMiddleware{
+		Service: next,
+		log:     log,
+	}
+
+	// Scheduled values are pushed and expired keys deleted through the
+	// middleware, so that the file records when among the other writes
+	if svc, ok := next.(*service); ok {
+		svc.qs.HoldDue(mw.promoteDue)
+		svc.store.OnExpire(mw.lockKey, mw.logExpired)
+	}
+
+	return mw
+}
+
+// promoteDue pushes the scheduled values that are due for keys
+func (mw *aofMiddleware) promoteDue(keys []string) {
+	for _, key := range keys {
+		if _, err := mw.QPromote(key, 0, time.Now()); err != nil {
+			log.Printf("aof: failed to log QPROMOTE %s: %v", key, err)
+		}
+	}
+}
+
+// logExpired logs the deletion of a key that expired, which replaying the
+// file does not expire on its own
+func (mw *aofMiddleware) logExpired(key string) {
+	if err := mw.log.Append("DEL", key); err != nil {
+		log.Printf("aof: failed to log DEL %s: %v", key, err)
+	}
+}
+
+func (mw *aofMiddleware) lockKey(key string) func() {
+	return mw.lock(key).Unlock
+}
+
+func (mw *aofMiddleware) lock(key string) *sync.Mutex {
+	mu := &mw.locks[kvstore.Murmur3([]byte(key), 0)%numAOFLocks]
+	mu.Lock()
+	return mu
+}
+
+// lockAll locks every key, each lock once and in index order so that
+// concurrent calls cannot deadlock, and returns a function unlocking them
+func (mw *aofMiddleware) lockAll(keys ...string) func() {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, int(kvstore.Murmur3([]byte(key), 0)%numAOFLocks))
+	}
+	sort.Ints(indexes)
+
+	locked := indexes[:0]
+	for i, idx := range indexes {
+		if i == 0 || idx != indexes[i-1] {
+			mw.locks[idx].Lock()
+			locked = append(locked, idx)
+		}
+	}
+
+	return func() {
+		for _, idx := range locked {
+			mw.locks[idx].Unlock()
+		}
+	}
+}
+
+// Applied sets are logged without their condition, which held
+func (mw *aofMiddleware) Set(key string, value string, expiresAt time.Time, condition string, keepTTL bool) (bool, error) {
+	defer mw.lock(key).Unlock()
+
+	applied, err := mw.Service.Set(key, value, expiresAt, condition, keepTTL)
+	if err != nil || !applied {
+		return applied, err
+	}
+
+	// Expiry is logged as an absolute time so that replaying the file
+	// later does not extend it, the one kept by KEEPTTL included
+	if keepTTL {
+		return applied, mw.logValue(key, value)
+	}
+
+	return applied, mw.log.Append(withPXAT([]string{"SET", key, value}, expiresAt)...)
+}
+
+// logValue logs key as set to value with the expiry it has
+func (mw *aofMiddleware) logValue(key, value string) error {
+	expiresAt, _ := mw.Service.ExpiresAt(key)
+	return mw.log.Append(withPXAT([]string{"SET", key, value}, expiresAt)...)
+}
+
+// Counters are logged as set to the result, so that replaying the file
+// does not depend on whether the key expired by then
+func (mw *aofMiddleware) IncrBy(key string, delta int64, expiresAt time.Time) (int64, error) {
+	defer mw.lock(key).Unlock()
+
+	n, err := mw.Service.IncrBy(key, delta, expiresAt)
+	if err != nil {
+		return n, err
+	}
+
+	return n, mw.logValue(key, strconv.FormatInt(n, 10))
+}
+
+func (mw *aofMiddleware) IncrByFloat(key string, delta float64, expiresAt time.Time) (float64, error) {
+	defer mw.lock(key).Unlock()
+
+	f, err := mw.Service.IncrByFloat(key, delta, expiresAt)
+	if err != nil {
+		return f, err
+	}
+
+	return f, mw.logValue(key, kvstore.FormatFloat(f))
+}
+
+// withPXAT appends the PXAT option for expiresAt to args, unless it is zero
+func withPXAT(args []string, expiresAt time.Time) []string {
+	if expiresAt.IsZero() {
+		return args
+	}
+
+	return append(args, "PXAT", strconv.FormatInt(expiresAt.UnixMilli(), 10))
+}
+
+func (mw *aofMiddleware) HSet(key string, fields map[string]string) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	added, err := mw.Service.HSet(key, fields)
+	if err != nil {
+		return added, err
+	}
+
+	args := make([]string, 0, 2+2*len(fields))
+	args = append(args, "HSET", key)
+	for field, value := range fields {
+		args = append(args, field, value)
+	}
+
+	return added, mw.log.Append(args...)
+}
+
+func (mw *aofMiddleware) HDel(key string, fields ...string) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	deleted, err := mw.Service.HDel(key, fields...)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	return deleted, mw.log.Append(append([]string{"HDEL", key}, fields...)...)
+}
+
+func (mw *aofMiddleware) HIncrBy(key, field string, delta int64) (int64, error) {
+	defer mw.lock(key).Unlock()
+
+	n, err := mw.Service.HIncrBy(key, field, delta)
+	if err != nil {
+		return n, err
+	}
+
+	return n, mw.log.Append("HINCRBY", key, field, strconv.FormatInt(delta, 10))
+}
+
+func (mw *aofMiddleware) SAdd(key string, members ...string) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	added, err := mw.Service.SAdd(key, members...)
+	if err != nil || added == 0 {
+		return added, err
+	}
+
+	return added, mw.log.Append(append([]string{"SADD", key}, members...)...)
+}
+
+func (mw *aofMiddleware) SRem(key string, members ...string) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	removed, err := mw.Service.SRem(key, members...)
+	if err != nil || removed == 0 {
+		return removed, err
+	}
+
+	return removed, mw.log.Append(append([]string{"SREM", key}, members...)...)
+}
+
+// ZADD is logged with its options, which select the same members when
+// replayed against the same sorted set
+func (mw *aofMiddleware) ZAdd(key string, scores map[string]float64, condition, comparison string) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	added, err := mw.Service.ZAdd(key, scores, condition, comparison)
+	if err != nil {
+		return added, err
+	}
+
+	args := make([]string, 0, 4+2*len(scores))
+	args = append(args, "ZADD", key)
+	if condition != "" {
+		args = append(args, condition)
+	}
+	if comparison != "" {
+		args = append(args, comparison)
+	}
+	for member, score := range scores {
+		args = append(args, kvstore.FormatFloat(score), member)
+	}
+
+	return added, mw.log.Append(args...)
+}
+
+func (mw *aofMiddleware) ZIncrBy(key string, delta float64, member string) (float64, error) {
+	defer mw.lock(key).Unlock()
+
+	score, err := mw.Service.ZIncrBy(key, delta, member)
+	if err != nil {
+		return score, err
+	}
+
+	return score, mw.log.Append("ZINCRBY", key, kvstore.FormatFloat(delta), member)
+}
+
+func (mw *aofMiddleware) ZRem(key string, members ...string) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	removed, err := mw.Service.ZRem(key, members...)
+	if err != nil || removed == 0 {
+		return removed, err
+	}
+
+	return removed, mw.log.Append(append([]string{"ZREM", key}, members...)...)
+}
+
+func (mw *aofMiddleware) ZRemRangeByScore(key, min, max string) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	removed, err := mw.Service.ZRemRangeByScore(key, min, max)
+	if err != nil || removed == 0 {
+		return removed, err
+	}
+
+	return removed, mw.log.Append("ZREMRANGEBYSCORE", key, min, max)
+}
+
+// The destination and every source key stay locked until the command is
+// logged, so that no write to a source can be logged in between
+func (mw *aofMiddleware) SetOperationStore(op, dest string, keys ...string) (int, error) {
+	all := append([]string{dest}, keys...)
+	defer mw.lockAll(all...)()
+
+	n, err := mw.Service.SetOperationStore(op, dest, keys...)
+	if err != nil {
+		return n, err
+	}
+
+	return n, mw.log.Append(append([]string{"S" + op + "STORE"}, all...)...)
+}
+
+// Applied expiries are logged without their condition, which held
+func (mw *aofMiddleware) Expire(key string, expiresAt time.Time, condition string) (bool, error) {
+	defer mw.lock(key).Unlock()
+
+	applied, err := mw.Service.Expire(key, expiresAt, condition)
+	if err != nil || !applied {
+		return applied, err
+	}
+
+	return applied, mw.log.Append("PEXPIREAT", key, strconv.FormatInt(expiresAt.UnixMilli(), 10))
+}
+
+func (mw *aofMiddleware) Persist(key string) (bool, error) {
+	defer mw.lock(key).Unlock()
+
+	applied, err := mw.Service.Persist(key)
+	if err != nil || !applied {
+		return applied, err
+	}
+
+	return applied, mw.log.Append("PERSIST", key)
+}
+
+// Keys are logged one by one since they may belong to different locks
+func (mw *aofMiddleware) Del(keys ...string) int {
+	deleted := 0
+	for _, key := range keys {
+		mu := mw.lock(key)
+		n := mw.Service.Del(key)
+		if n > 0 {
+			if err := mw.log.Append("DEL", key); err != nil {
+				log.Printf("aof: failed to log DEL %s: %v", key, err)
+			}
+		}
+		mu.Unlock()
+		deleted += n
+	}
+
+	return deleted
+}
+
+// Sync writes the file to disk, making every logged write durable
+func (mw *aofMiddleware) Sync() error {
+	return mw.log.Sync()
+}
+
+// Durable reports that writes can be synced to disk
+func (mw *aofMiddleware) Durable() bool {
+	return true
+}
+
+// QPush waits for the values to be in the queue before logging them, so
+// that a later write to it is not applied ahead of the push
+func (mw *aofMiddleware) QPush(ctx context.Context, key string, values ...interface{}) error {
+	defer mw.lock(key).Unlock()
+
+	if err := mw.Service.QPush(ctx, key, values...); err != nil {
+		return err
+	}
+	mw.Service.QFlush()
+
+	args, err := appendValues([]string{"QPUSH", key}, values)
+	if err != nil {
+		return err
+	}
+
+	return mw.log.Append(args...)
+}
+
+// QPushAt logs the time the values are due, so replaying the file later
+// does not delay them further
+func (mw *aofMiddleware) QPushAt(key string, at time.Time, values ...interface{}) error {
+	args, err := appendValues([]string{"QPUSHAT", key, strconv.FormatInt(at.UnixMilli(), 10)}, values)
+	if err != nil {
+		return err
+	}
+
+	defer mw.lock(key).Unlock()
+
+	if err := mw.Service.QPushAt(key, at, values...); err != nil {
+		return err
+	}
+	if err := mw.log.Append(args...); err != nil {
+		return err
+	}
+
+	// Values already due are pushed right away
+	_, err = mw.promote(key, 0, time.Now())
+	return err
+}
+
+func (mw *aofMiddleware) QPushPriority(key string, priority int64, values ...interface{}) error {
+	args, err := appendValues([]string{"QPUSHPRI", key, strconv.FormatInt(priority, 10)}, values)
+	if err != nil {
+		return err
+	}
+
+	defer mw.lock(key).Unlock()
+
+	if err := mw.Service.QPushPriority(key, priority, values...); err != nil {
+		return err
+	}
+
+	return mw.log.Append(args...)
+}
+
+// appendValues appends values to args in the form the Redis protocol
+// replies with
+func appendValues(args []string, values []interface{}) ([]string, error) {
+	for _, v := range values {
+		s, err := formatValue(v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, s)
+	}
+
+	return args, nil
+}
+
+func (mw *aofMiddleware) QPop(key string) (interface{}, error) {
+	defer mw.lock(key).Unlock()
+
+	value, err := mw.Service.QPop(key)
+	if err != nil {
+		return value, err
+	}
+
+	return value, mw.log.Append("QPOP", key)
+}
+
+// BQPop pops as QPop does, so that the pop and its log entry happen under
+// the same lock. The keys cannot stay locked while waiting, pushes to them
+// would never get through, so it waits for a value with them unlocked and
+// pops it once woken.
+func (mw *aofMiddleware) BQPop(ctx context.Context, keys []string, timeout time.Duration) (string, interface{}, error) {
+	deadline := deadlineOf(timeout)
+	for {
+		key, value, err := mw.popFirst(keys)
+		if err != queue.ErrQueueEmpty {
+			return key, value, err
+		}
+		if err := mw.waitValue(ctx, keys, deadline); err != nil {
+			return "", nil, err
+		}
+	}
+}
+
+// popFirst pops from the first queue of keys that is not empty
+func (mw *aofMiddleware) popFirst(keys []string) (string, interface{}, error) {
+	defer mw.lockAll(keys...)()
+
+	for _, key := range keys {
+		value, err := mw.Service.QPop(key)
+		if err == queue.ErrQueueEmpty {
+			continue
+		}
+		if err != nil {
+			return key, value, err
+		}
+		return key, value, mw.log.Append("QPOP", key)
+	}
+
+	return "", nil, queue.ErrQueueEmpty
+}
+
+// waitValue waits for a value in one of the queues under keys until
+// deadline, forever if it is zero
+func (mw *aofMiddleware) waitValue(ctx context.Context, keys []string, deadline time.Time) error {
+	var timeout time.Duration
+	if !deadline.IsZero() {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return queue.ErrQueueEmpty
+		}
+	}
+
+	return mw.Service.QWaitValue(ctx, keys, timeout)
+}
+
+// deadlineOf returns when a wait of timeout started now ends, zero for a
+// timeout of 0 which waits forever
+func deadlineOf(timeout time.Duration) time.Time {
+	if timeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// QPopN logs how many values were popped, so that replaying the file pops
+// the same ones
+func (mw *aofMiddleware) QPopN(key string, count int) ([]interface{}, error) {
+	defer mw.lock(key).Unlock()
+
+	values, err := mw.Service.QPopN(key, count)
+	if err != nil || len(values) == 0 {
+		return values, err
+	}
+
+	return values, mw.log.Append("QPOPN", key, strconv.Itoa(len(values)))
+}
+
+// QPromote logs how many values were pushed, so that replaying the file
+// pushes the same ones at the same point
+func (mw *aofMiddleware) QPromote(key string, count int, until time.Time) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	return mw.promote(key, count, until)
+}
+
+// promote is QPromote for a caller holding the lock of key
+func (mw *aofMiddleware) promote(key string, count int, until time.Time) (int, error) {
+	n, err := mw.Service.QPromote(key, count, until)
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	return n, mw.log.Append("QPROMOTE", key, strconv.Itoa(n))
+}
+
+// QMove locks both queues, so that the file orders the move the same way
+// as the writes to either of them
+func (mw *aofMiddleware) QMove(src, dst string, fromTail, toHead bool) (interface{}, error) {
+	defer mw.lockAll(src, dst)()
+
+	value, err := mw.Service.QMove(src, dst, fromTail, toHead)
+	if err != nil {
+		return value, err
+	}
+
+	return value, mw.log.Append(moveArgs(src, dst, fromTail, toHead)...)
+}
+
+// BQMove moves as QMove does, waiting for a value as BQPop does
+func (mw *aofMiddleware) BQMove(ctx context.Context, src, dst string, fromTail, toHead bool, timeout time.Duration) (interface{}, error) {
+	deadline := deadlineOf(timeout)
+	for {
+		value, err := mw.QMove(src, dst, fromTail, toHead)
+		if err != queue.ErrQueueEmpty {
+			return value, err
+		}
+		if err := mw.waitValue(ctx, []string{src}, deadline); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// moveArgs returns the QMOVE command for a move
+func moveArgs(src, dst string, fromTail, toHead bool) []string {
+	args := []string{"QMOVE", src, dst, "LEFT", "RIGHT"}
+	if fromTail {
+		args[3] = "RIGHT"
+	}
+	if toHead {
+		args[4] = "LEFT"
+	}
+
+	return args
+}
+
+func (mw *aofMiddleware) LPush(key string, values ...interface{}) (int, error) {
+	return mw.push("LPUSH", key, values, mw.Service.LPush)
+}
+
+func (mw *aofMiddleware) RPush(key string, values ...interface{}) (int, error) {
+	return mw.push("RPUSH", key, values, mw.Service.RPush)
+}
+
+func (mw *aofMiddleware) push(name, key string, values []interface{}, push func(string, ...interface{}) (int, error)) (int, error) {
+	// Values that cannot be logged are refused before being pushed
+	args, err := appendValues([]string{name, key}, values)
+	if err != nil {
+		return 0, err
+	}
+
+	defer mw.lock(key).Unlock()
+
+	n, err := push(key, values...)
+	if err != nil {
+		return n, err
+	}
+
+	return n, mw.log.Append(args...)
+}
+
+func (mw *aofMiddleware) LPop(key string) (interface{}, error) {
+	defer mw.lock(key).Unlock()
+
+	value, err := mw.Service.LPop(key)
+	if err != nil {
+		return value, err
+	}
+
+	return value, mw.log.Append("LPOP", key)
+}
+
+func (mw *aofMiddleware) RPop(key string) (interface{}, error) {
+	defer mw.lock(key).Unlock()
+
+	value, err := mw.Service.RPop(key)
+	if err != nil {
+		return value, err
+	}
+
+	return value, mw.log.Append("RPOP", key)
+}
+
+func (mw *aofMiddleware) LTrim(key string, start, stop int) error {
+	defer mw.lock(key).Unlock()
+
+	if err := mw.Service.LTrim(key, start, stop); err != nil {
+		return err
+	}
+
+	return mw.log.Append("LTRIM", key, strconv.Itoa(start), strconv.Itoa(stop))
+}
+
+func (mw *aofMiddleware) LInsert(key string, before bool, pivot, value interface{}) (int, error) {
+	args, err := appendValues([]string{"LINSERT", key, "AFTER"}, []interface{}{pivot, value})
+	if err != nil {
+		return 0, err
+	}
+	if before {
+		args[2] = "BEFORE"
+	}
+
+	defer mw.lock(key).Unlock()
+
+	n, err := mw.Service.LInsert(key, before, pivot, value)
+	if err != nil || n <= 0 {
+		return n, err
+	}
+
+	return n, mw.log.Append(args...)
+}
+
+// QReserve logs the deadline of the lease, from which replaying tells when
+// it was taken and so which leases had ended by then
+func (mw *aofMiddleware) QReserve(key string, visibility time.Duration, deadline time.Time) (queue.Message, error) {
+	defer mw.lockRelated(key, mw.deadLetterQueue(key))()
+
+	m, err := mw.Service.QReserve(key, visibility, deadline)
+	if err != nil {
+		return m, err
+	}
+
+	seconds := strconv.FormatFloat(visibility.Seconds(), 'f', -1, 64)
+	return m, mw.log.Append("QRESERVE", key, seconds, "PXAT", strconv.FormatInt(m.Deadline.UnixMilli(), 10))
+}
+
+func (mw *aofMiddleware) QAck(key string, ids ...string) (int, error) {
+	return mw.ack("QACK", key, ids, mw.Service.QAck)
+}
+
+func (mw *aofMiddleware) ack(name, key string, ids []string, ack func(string, ...string) (int, error)) (int, error) {
+	defer mw.lock(key).Unlock()
+
+	n, err := ack(key, ids...)
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	return n, mw.log.Append(append([]string{name, key}, ids...)...)
+}
+
+// QNack logs when the messages failed, which dead letters keep, truncated
+// to the millisecond as that is what is logged
+func (mw *aofMiddleware) QNack(key, reason string, at time.Time, ids ...string) (int, error) {
+	defer mw.lockRelated(key, mw.deadLetterQueue(key))()
+
+	if at.IsZero() {
+		at = time.Now().Truncate(time.Millisecond)
+	}
+	n, err := mw.Service.QNack(key, reason, at, ids...)
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	args := append([]string{"QNACK", key}, ids...)
+	if reason != "" {
+		args = append(args, "REASON", reason)
+	}
+
+	return n, mw.log.Append(append(args, "PXAT", strconv.FormatInt(at.UnixMilli(), 10))...)
+}
+
+// QConfig logs the whole settings once changed
+func (mw *aofMiddleware) QConfig(key string, update queue.ConfigUpdate) (queue.Config, error) {
+	defer mw.lock(key).Unlock()
+
+	c, err := mw.Service.QConfig(key, update)
+	if err != nil || update.Empty() {
+		return c, err
+	}
+
+	return c, mw.log.Append("QCONFIG", key, "MAXATTEMPTS", strconv.Itoa(c.MaxAttempts), "DEADLETTER", c.DeadLetter,
+		"MAXLEN", strconv.Itoa(c.MaxLen), "OVERFLOW", c.Overflow.String(),
+		"BLOCKTIMEOUT", strconv.FormatFloat(c.BlockTimeout.Seconds(), 'f', -1, 64))
+}
+
+func (mw *aofMiddleware) QDLRequeue(dlq string, ids ...string) (int, error) {
+	defer mw.lockRelated(dlq, mw.sourceQueues(dlq, ids))()
+
+	return mw.deadLetters("QDLREQUEUE", dlq, ids, mw.Service.QDLRequeue)
+}
+
+func (mw *aofMiddleware) QDLPurge(dlq string, ids ...string) (int, error) {
+	defer mw.lock(dlq).Unlock()
+
+	return mw.deadLetters("QDLPURGE", dlq, ids, mw.Service.QDLPurge)
+}
+
+// deadLetters applies remove, which must be called with the locks held, and
+// logs it. No ID means every message, which replaying finds the same.
+func (mw *aofMiddleware) deadLetters(name, dlq string, ids []string, remove func(string, ...string) (int, error)) (int, error) {
+	n, err := remove(dlq, ids...)
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	return n, mw.log.Append(append([]string{name, dlq}, ids...)...)
+}
+
+// lockRelated locks key along with the keys that related returns, which a
+// call on key changes too, and returns a function unlocking them. The
+// related keys are read before they are locked, so they are read again once
+// locked and the locks taken over if they changed meanwhile.
+func (mw *aofMiddleware) lockRelated(key string, related func() []string) func() {
+	keys := related()
+	for {
+		unlock := mw.lockAll(append([]string{key}, keys...)...)
+
+		current := related()
+		changed := false
+		for _, k := range current {
+			changed = changed || !contains(keys, k)
+		}
+		if !changed {
+			return unlock
+		}
+
+		unlock()
+		keys = current
+	}
+}
+
+// deadLetterQueue returns the dead-letter queue of the queue under key,
+// which reserving and nacking move messages to
+func (mw *aofMiddleware) deadLetterQueue(key string) func() []string {
+	return func() []string {
+		c, _ := mw.Service.QConfig(key, queue.ConfigUpdate{})
+		if c.DeadLetter == "" {
+			return nil
+		}
+		return []string{c.DeadLetter}
+	}
+}
+
+// sourceQueues returns the queues that the messages of the dead-letter
+// queue dlq with the given IDs, or all of them, came from
+func (mw *aofMiddleware) sourceQueues(dlq string, ids []string) func() []string {
+	return func() []string {
+		var queues []string
+		for _, d := range mw.Service.QDLList(dlq, 0, -1) {
+			if (len(ids) == 0 || contains(ids, d.ID)) && !contains(queues, d.Queue) {
+				queues = append(queues, d.Queue)
+			}
+		}
+		return queues
+	}
+}
+
+// ReplayAOF applies the commands recorded in the append-only file at path to
+// s, which must have been created by NewService and not be serving requests
+// yet. It returns the number of commands replayed.
+func ReplayAOF(path string, s Service) (int, error) {
+	svc, ok := s.(*service)
+	if !ok {
+		return 0, errors.New("append-only file can only be replayed into the service returned by NewService")
+	}
+
+	// Scheduled values are pushed and keys deleted where the file says
+	// they were, not when they are due
+	svc.qs.HoldDue(nil)
+	svc.store.HoldExpiry(true)
+	defer svc.store.HoldExpiry(false)
+
+	return aof.Replay(path, svc.applyCommand)
+}
+
+// applyCommand executes a logged command directly against the stores. The
+// Service methods apply writes asynchronously, which could reorder them.
+func (s *service) applyCommand(args []string) error {
+	req, err := ParseCommand(args)
+	if err != nil {
+		return err
+	}
+
+	switch req := req.(type) {
+	case model.SetRequest:
+		_, err := s.store.Set(req.Key, req.Value, req.ExpiresAt, req.Condition, req.KeepTTL)
+		return err
+
+	case model.IncrRequest:
+		_, err := s.store.IncrBy(req.Key, req.Delta, req.ExpiresAt)
+		return err
+
+	case model.IncrByFloatRequest:
+		_, err := s.store.IncrByFloat(req.Key, req.Delta, req.ExpiresAt)
+		return err
+
+	case model.HSetRequest:
+		_, err := s.store.HSet(req.Key, req.Fields)
+		return err
+
+	case model.HDelRequest:
+		_, err := s.store.HDel(req.Key, req.Fields...)
+		return err
+
+	case model.HIncrByRequest:
+		_, err := s.store.HIncrBy(req.Key, req.Field, req.Delta)
+		return err
+
+	case model.SAddRequest:
+		_, err := s.store.SAdd(req.Key, req.Members...)
+		return err
+
+	case model.SRemRequest:
+		_, err := s.store.SRem(req.Key, req.Members...)
+		return err
+
+	case model.ZAddRequest:
+		_, err := s.store.ZAdd(req.Key, req.Members, req.Condition, req.Comparison)
+		return err
+
+	case model.ZIncrByRequest:
+		_, err := s.store.ZIncrBy(req.Key, req.Delta, req.Member)
+		return err
+
+	case model.ZRemRequest:
+		_, err := s.store.ZRem(req.Key, req.Members...)
+		return err
+
+	case model.ZRemRangeByScoreRequest:
+		_, err := s.store.ZRemRangeByScore(req.Key, req.Min, req.Max)
+		return err
+
+	case model.SetOperationStoreRequest:
+		_, err := s.store.SetOperationStore(req.Op, req.Destination, req.Keys...)
+		return err
+
+	case model.ExpireRequest:
+		_, err := s.store.Expire(req.Key, req.ExpiresAt, req.Condition)
+		return err
+
+	case model.PersistRequest:
+		_, err := s.store.Persist(req.Key)
+		return err
+
+	case model.DelRequest:
+		s.store.Delete(req.Keys...)
+		return nil
+
+	case model.QPushRequest:
+		switch {
+		case req.Priority != nil:
+			s.qs.PushPriority(req.Key, *req.Priority, req.Values...)
+		case !req.NotBefore.IsZero():
+			s.qs.Schedule(req.Key, req.NotBefore, req.Values...)
+		default:
+			s.qs.Append(req.Key, req.Values...)
+		}
+		return nil
+
+	case model.ListPushRequest:
+		if req.Left {
+			s.qs.LPush(req.Key, req.Values...)
+		} else {
+			s.qs.RPush(req.Key, req.Values...)
+		}
+		return nil
+
+	case model.ListPopRequest:
+		pop := s.qs.RPop
+		if req.Left {
+			pop = s.qs.LPop
+		}
+		if _, err := pop(req.Key); err != nil && err != queue.ErrQueueEmpty {
+			return err
+		}
+		return nil
+
+	case model.LTrimRequest:
+		return s.qs.LTrim(req.Key, req.Start, req.Stop)
+
+	case model.LInsertRequest:
+		_, err := s.qs.LInsert(req.Key, req.Before, req.Pivot, req.Value)
+		return err
+
+	case model.QReserveRequest:
+		if _, err := s.QReserve(req.Key, req.Visibility, req.Deadline); err != nil && err != queue.ErrQueueEmpty {
+			return err
+		}
+		return nil
+
+	case model.QAckRequest:
+		if req.Nack {
+			s.QNack(req.Key, req.Reason, req.At, req.IDs...)
+		} else {
+			s.qs.Ack(req.Key, req.IDs...)
+		}
+		return nil
+
+	case model.QConfigRequest:
+		_, err := s.qs.Configure(req.Key, configUpdate(req))
+		return err
+
+	case model.QDLRequeueRequest:
+		if req.Purge {
+			s.qs.Purge(req.Key, req.IDs...)
+		} else {
+			s.qs.Requeue(req.Key, req.IDs...)
+		}
+		return nil
+
+	case model.QPopRequest:
+		if _, err := s.qs.Pop(req.Key); err != nil && err != queue.ErrQueueEmpty {
+			return err
+		}
+		return nil
+
+	case model.QPopNRequest:
+		s.qs.PopN(req.Key, req.Count)
+		return nil
+
+	case model.QPromoteRequest:
+		s.qs.Promote(req.Key, req.Count, time.Time{})
+		return nil
+
+	case model.QMoveRequest:
+		if _, err := s.qs.Move(req.Source, req.Destination, req.FromTail, req.ToHead); err != nil && err != queue.ErrQueueEmpty {
+			return err
+		}
+		return nil
+	}
+
+	return fmt.Errorf("command %s cannot be replayed", args[0])
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
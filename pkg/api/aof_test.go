@@ -0,0 +1,271 @@
+package kvstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/aof"
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAOFService(t *testing.T, path string) Service {
+	s := NewService(kvstore.NewShardedKVStore(0), queue.NewQueue())
+	_, err := ReplayAOF(path, s)
+	require.NoError(t, err)
+
+	log, err := aof.Open(path, aof.FsyncAlways)
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Close() })
+
+	return NewAOFMiddleware(log, s)
+}
+
+func replayedService(t *testing.T, path string) Service {
+	s := NewService(kvstore.NewShardedKVStore(0), queue.NewQueue())
+	_, err := ReplayAOF(path, s)
+	require.NoError(t, err)
+
+	return s
+}
+
+// stateOf returns every key and queue of s in a form that can be compared
+func stateOf(s Service) (map[string]kvstore.KeyValue, queue.State) {
+	if mw, ok := s.(*aofMiddleware); ok {
+		s = mw.Service
+	}
+	svc := s.(*service)
+
+	keys := make(map[string]kvstore.KeyValue)
+	svc.store.Dump(keys)
+	for key, kv := range keys {
+		// Skiplists are built with random levels
+		if z, ok := kv.Value.(*kvstore.ZSet); ok {
+			kv.Value = z.Members()
+			keys[key] = kv
+		}
+	}
+
+	// Push times are not logged, so dead letters only have the replayed ones
+	queues := svc.qs.Dump()
+	for _, dead := range queues.DeadLetters {
+		for i := range dead {
+			dead[i].EnqueuedAt = time.Time{}
+		}
+	}
+
+	return keys, queues
+}
+
+func TestAOFReplay(t *testing.T) {
+	ctx := context.Background()
+	// Times are logged to the millisecond
+	later := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	intPtr := func(n int) *int { return &n }
+	strPtr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Service)
+	}{
+		{"strings", func(t *testing.T, s Service) {
+			_, err := s.Set("a", "1", later, "", false)
+			require.NoError(t, err)
+			_, err = s.Set("a", "2", time.Time{}, "XX", true)
+			require.NoError(t, err)
+			_, err = s.Set("b", "skipped", time.Time{}, "XX", false)
+			require.NoError(t, err)
+			_, err = s.IncrBy("n", 5, time.Time{})
+			require.NoError(t, err)
+			_, err = s.IncrByFloat("f", 1.5, later)
+			require.NoError(t, err)
+		}},
+		{"hashes", func(t *testing.T, s Service) {
+			_, err := s.HSet("h", map[string]string{"a": "1", "b": "2", "c": "3"})
+			require.NoError(t, err)
+			_, err = s.HDel("h", "b")
+			require.NoError(t, err)
+			_, err = s.HIncrBy("h", "c", 4)
+			require.NoError(t, err)
+		}},
+		{"sets", func(t *testing.T, s Service) {
+			_, err := s.SAdd("s1", "a", "b", "c")
+			require.NoError(t, err)
+			_, err = s.SAdd("s2", "b", "c", "d")
+			require.NoError(t, err)
+			_, err = s.SRem("s1", "c")
+			require.NoError(t, err)
+			_, err = s.SetOperationStore(kvstore.SetUnion, "union", "s1", "s2")
+			require.NoError(t, err)
+		}},
+		{"sorted sets", func(t *testing.T, s Service) {
+			_, err := s.ZAdd("z", map[string]float64{"a": 1, "b": 2, "c": 3, "d": 4}, "", "")
+			require.NoError(t, err)
+			_, err = s.ZAdd("z", map[string]float64{"a": 0, "b": 5}, "", "GT")
+			require.NoError(t, err)
+			_, err = s.ZIncrBy("z", 2.5, "c")
+			require.NoError(t, err)
+			_, err = s.ZRem("z", "d")
+			require.NoError(t, err)
+			_, err = s.ZRemRangeByScore("z", "5", "+inf")
+			require.NoError(t, err)
+		}},
+		{"expiry and deletes", func(t *testing.T, s Service) {
+			for _, key := range []string{"a", "b", "c"} {
+				_, err := s.Set(key, key, time.Time{}, "", false)
+				require.NoError(t, err)
+			}
+			_, err := s.Expire("a", later, "")
+			require.NoError(t, err)
+			_, err = s.Expire("b", later, "")
+			require.NoError(t, err)
+			_, err = s.Persist("b")
+			require.NoError(t, err)
+			s.Del("c", "missing")
+		}},
+		{"writes to keys that expire", func(t *testing.T, s Service) {
+			soon := time.Now().Add(50 * time.Millisecond).Truncate(time.Millisecond)
+			_, err := s.Set("c", "5", soon, "", false)
+			require.NoError(t, err)
+			_, err = s.IncrBy("c", 1, time.Time{})
+			require.NoError(t, err)
+			_, err = s.IncrByFloat("f", 1.5, soon)
+			require.NoError(t, err)
+			_, err = s.Set("a", "1", soon, "", false)
+			require.NoError(t, err)
+			_, err = s.Set("a", "2", time.Time{}, "", true)
+			require.NoError(t, err)
+			_, err = s.Set("kept", "1", later, "", false)
+			require.NoError(t, err)
+			_, err = s.IncrBy("kept", 1, time.Time{})
+			require.NoError(t, err)
+			_, err = s.HSet("h", map[string]string{"old": "1"})
+			require.NoError(t, err)
+			_, err = s.Expire("h", soon, "")
+			require.NoError(t, err)
+			_, err = s.HSet("h", map[string]string{"more": "1"})
+			require.NoError(t, err)
+			_, err = s.SAdd("untouched", "a")
+			require.NoError(t, err)
+			_, err = s.Expire("untouched", soon, "")
+			require.NoError(t, err)
+			_, err = s.SAdd("untouched", "b")
+			require.NoError(t, err)
+
+			// Replaying the file later does not bring the keys back, nor
+			// does it apply later writes to the expired keys
+			time.Sleep(time.Until(soon) + 10*time.Millisecond)
+			_, err = s.HSet("h", map[string]string{"new": "1"})
+			require.NoError(t, err)
+			_, err = s.IncrBy("f", 1, time.Time{})
+			require.NoError(t, err)
+		}},
+		{"queue pushes and pops", func(t *testing.T, s Service) {
+			require.NoError(t, s.QPush(ctx, "q", "a", "b", "c", "d", "e"))
+			_, err := s.QPop("q")
+			require.NoError(t, err)
+			_, err = s.QPopN("q", 2)
+			require.NoError(t, err)
+			_, _, err = s.BQPop(ctx, []string{"empty", "q"}, time.Second)
+			require.NoError(t, err)
+			require.NoError(t, s.QPushPriority("pri", 5, "high"))
+			require.NoError(t, s.QPushPriority("pri", 1, "low", "lower"))
+			require.NoError(t, s.QPush(ctx, "pri", "none"))
+		}},
+		{"scheduled pushes", func(t *testing.T, s Service) {
+			require.NoError(t, s.QPush(ctx, "q", "a"))
+			require.NoError(t, s.QPushAt("q", later, "later", "latest"))
+			require.NoError(t, s.QPushAt("q", later.Add(-2*time.Hour), "due"))
+			require.NoError(t, s.QPush(ctx, "q", "b"))
+			_, err := s.QPromote("q", 1, time.Time{})
+			require.NoError(t, err)
+			require.NoError(t, s.QPushAt("other", later, "x"))
+		}},
+		{"lists", func(t *testing.T, s Service) {
+			_, err := s.RPush("l", "a", "b", "c", "d", "e")
+			require.NoError(t, err)
+			_, err = s.LPush("l", "x", "y")
+			require.NoError(t, err)
+			_, err = s.LPop("l")
+			require.NoError(t, err)
+			_, err = s.RPop("l")
+			require.NoError(t, err)
+			require.NoError(t, s.LTrim("l", 1, -1))
+			_, err = s.LInsert("l", true, "c", "before-c")
+			require.NoError(t, err)
+		}},
+		{"moves", func(t *testing.T, s Service) {
+			require.NoError(t, s.QPush(ctx, "src", "a", "b", "c"))
+			_, err := s.QMove("src", "dst", false, false)
+			require.NoError(t, err)
+			_, err = s.QMove("src", "dst", true, true)
+			require.NoError(t, err)
+			_, err = s.BQMove(ctx, "src", "dst", false, true, time.Second)
+			require.NoError(t, err)
+		}},
+		{"reliable queues", func(t *testing.T, s Service) {
+			_, err := s.QConfig("jobs", queue.ConfigUpdate{MaxAttempts: intPtr(1), DeadLetter: strPtr("jobs:dead")})
+			require.NoError(t, err)
+			require.NoError(t, s.QPush(ctx, "jobs", "a", "b", "c", "d"))
+
+			var ids []string
+			for i := 0; i < 4; i++ {
+				m, err := s.QReserve("jobs", time.Minute, time.Time{})
+				require.NoError(t, err)
+				ids = append(ids, m.ID)
+			}
+			_, err = s.QAck("jobs", ids[0])
+			require.NoError(t, err)
+			_, err = s.QNack("jobs", "failed", time.Time{}, ids[1], ids[2])
+			require.NoError(t, err)
+			_, err = s.QDLRequeue("jobs:dead", "jobs/"+ids[1])
+			require.NoError(t, err)
+			_, err = s.QDLPurge("jobs:dead", "jobs/"+ids[2])
+			require.NoError(t, err)
+		}},
+		{"capacity", func(t *testing.T, s Service) {
+			overflow := queue.OverflowDropOldest
+			_, err := s.QConfig("q", queue.ConfigUpdate{MaxLen: intPtr(2), Overflow: &overflow})
+			require.NoError(t, err)
+			require.NoError(t, s.QPush(ctx, "q", "a", "b"))
+			_, err = s.LPush("q", "c")
+			require.NoError(t, err)
+			require.NoError(t, s.QPushAt("q", later, "d"))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "appendonly.aof")
+			s := newAOFService(t, path)
+			tt.run(t, s)
+
+			keys, queues := stateOf(s)
+			replayedKeys, replayedQueues := stateOf(replayedService(t, path))
+			assert.Equal(t, keys, replayedKeys)
+			assert.Equal(t, queues, replayedQueues)
+		})
+	}
+}
+
+func TestAOFReplayScheduledPush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	s := newAOFService(t, path)
+
+	require.NoError(t, s.QPushAt("q", time.Now().Add(50*time.Millisecond), "delayed"))
+	_, err := s.RPush("q", "v1")
+	require.NoError(t, err)
+	value, err := s.QPop("q")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", value)
+	require.Eventually(t, func() bool { return s.LLen("q") == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// Replaying once the value is due pushes it where it was pushed, after
+	// the pop, rather than as the file is read
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []interface{}{"delayed"}, replayedService(t, path).LRange("q", 0, -1))
+}
@@ -0,0 +1,1449 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/pkg/model"
+)
+
+var errSyntax = errors.New("syntax error")
+
+type commandParser func(args []string) (interface{}, error)
+
+type commandSpec struct {
+	// Arity is the number of arguments including the command name. A
+	// negative arity means "at least -arity arguments".
+	arity int
+	parse commandParser
+}
+
+var commands = map[string]commandSpec{
+	"SET":   {arity: -3, parse: parseSetCommand},
+	"GET":   {arity: 2, parse: parseGetCommand},
+	"QPUSH": {arity: -3, parse: parseQPushCommand},
+	"QPOP":  {arity: 2, parse: parseQPopCommand},
+	"BQPOP": {arity: -3, parse: parseBQPopCommand},
+
+	"QPUSHIN": {arity: -4, parse: parseQPushLaterCommand(false)},
+	"QPUSHAT": {arity: -4, parse: parseQPushLaterCommand(true)},
+
+	"QPUSHPRI": {arity: -4, parse: parseQPushPriorityCommand},
+
+	"LPUSH":   {arity: -3, parse: parseListPushCommand(true)},
+	"RPUSH":   {arity: -3, parse: parseListPushCommand(false)},
+	"LPOP":    {arity: 2, parse: parseListPopCommand(true)},
+	"RPOP":    {arity: 2, parse: parseListPopCommand(false)},
+	"LLEN":    {arity: 2, parse: parseLLenCommand},
+	"LINDEX":  {arity: 3, parse: parseLIndexCommand},
+	"LRANGE":  {arity: 4, parse: parseLRangeCommand},
+	"LTRIM":   {arity: 4, parse: parseLTrimCommand},
+	"LINSERT": {arity: 5, parse: parseLInsertCommand},
+
+	"QRESERVE": {arity: -3, parse: parseQReserveCommand},
+	"QACK":     {arity: -3, parse: parseQAckCommand(false)},
+	"QNACK":    {arity: -3, parse: parseQAckCommand(true)},
+	"QCONFIG":  {arity: -2, parse: parseQConfigCommand},
+
+	"QDLLIST":    {arity: -2, parse: parseQDLListCommand},
+	"QDLGET":     {arity: 3, parse: parseQDLGetCommand},
+	"QDLREQUEUE": {arity: -2, parse: parseQDLRequeueCommand(false)},
+	"QDLPURGE":   {arity: -2, parse: parseQDLRequeueCommand(true)},
+
+	"QLEN":   {arity: 2, parse: parseLLenCommand},
+	"QPEEK":  {arity: 2, parse: parseQPeekCommand},
+	"QRANGE": {arity: 4, parse: parseLRangeCommand},
+	"QSTATS": {arity: 2, parse: parseQStatsCommand},
+
+	"QPOPN":    {arity: 3, parse: parseQPopNCommand},
+	"QPROMOTE": {arity: -2, parse: parseQPromoteCommand},
+	"QMOVE":    {arity: -3, parse: parseQMoveCommand(false)},
+	"BQMOVE":   {arity: -4, parse: parseQMoveCommand(true)},
+
+	"TTL":       {arity: 2, parse: parseTTLCommand(false)},
+	"PTTL":      {arity: 2, parse: parseTTLCommand(true)},
+	"EXPIRE":    {arity: -3, parse: parseExpireCommand("expire", time.Second, false)},
+	"PEXPIRE":   {arity: -3, parse: parseExpireCommand("pexpire", time.Millisecond, false)},
+	"EXPIREAT":  {arity: -3, parse: parseExpireCommand("expireat", time.Second, true)},
+	"PEXPIREAT": {arity: -3, parse: parseExpireCommand("pexpireat", time.Millisecond, true)},
+	"PERSIST":   {arity: 2, parse: parsePersistCommand},
+
+	"INCR":        {arity: -2, parse: parseIncrCommand("incr", 1, false)},
+	"DECR":        {arity: -2, parse: parseIncrCommand("decr", -1, false)},
+	"INCRBY":      {arity: -3, parse: parseIncrCommand("incrby", 1, true)},
+	"DECRBY":      {arity: -3, parse: parseIncrCommand("decrby", -1, true)},
+	"INCRBYFLOAT": {arity: -3, parse: parseIncrByFloatCommand},
+
+	"HSET":    {arity: -4, parse: parseHSetCommand},
+	"HGET":    {arity: 3, parse: parseHGetCommand},
+	"HDEL":    {arity: -3, parse: parseHDelCommand},
+	"HGETALL": {arity: 2, parse: parseHGetAllCommand},
+	"HINCRBY": {arity: 4, parse: parseHIncrByCommand},
+	"HLEN":    {arity: 2, parse: parseHLenCommand},
+
+	"SADD":        {arity: -3, parse: parseSAddCommand},
+	"SREM":        {arity: -3, parse: parseSRemCommand},
+	"SMEMBERS":    {arity: 2, parse: parseSMembersCommand},
+	"SISMEMBER":   {arity: 3, parse: parseSIsMemberCommand},
+	"SCARD":       {arity: 2, parse: parseSCardCommand},
+	"SINTER":      {arity: -2, parse: parseSetOperationCommand(kvstore.SetInter)},
+	"SUNION":      {arity: -2, parse: parseSetOperationCommand(kvstore.SetUnion)},
+	"SDIFF":       {arity: -2, parse: parseSetOperationCommand(kvstore.SetDiff)},
+	"SINTERSTORE": {arity: -3, parse: parseSetOperationStoreCommand(kvstore.SetInter)},
+	"SUNIONSTORE": {arity: -3, parse: parseSetOperationStoreCommand(kvstore.SetUnion)},
+	"SDIFFSTORE":  {arity: -3, parse: parseSetOperationStoreCommand(kvstore.SetDiff)},
+
+	"ZADD":             {arity: -4, parse: parseZAddCommand},
+	"ZINCRBY":          {arity: 4, parse: parseZIncrByCommand},
+	"ZRANK":            {arity: 3, parse: parseZRankCommand(false)},
+	"ZREVRANK":         {arity: 3, parse: parseZRankCommand(true)},
+	"ZSCORE":           {arity: 3, parse: parseZScoreCommand},
+	"ZCARD":            {arity: 2, parse: parseZCardCommand},
+	"ZREM":             {arity: -3, parse: parseZRemCommand},
+	"ZREMRANGEBYSCORE": {arity: 4, parse: parseZRemRangeByScoreCommand},
+	"ZRANGE":           {arity: -4, parse: parseZRangeCommand},
+
+	"DEL":    {arity: -2, parse: parseDelCommand},
+	"EXISTS": {arity: -2, parse: parseExistsCommand},
+	"KEYS":   {arity: 2, parse: parseKeysCommand},
+	"SCAN":   {arity: -2, parse: parseScanCommand},
+
+	"SAVE":     {arity: 1, parse: parseSaveCommand(false)},
+	"BGSAVE":   {arity: 1, parse: parseSaveCommand(true)},
+	"LASTSAVE": {arity: 1, parse: parseLastSaveCommand},
+}
+
+// ParseCommand turns a tokenized command into the matching model request,
+// e.g. ["SET", "k", "v"] becomes a model.SetRequest. Failures are reported
+// as *model.CommandError.
+func ParseCommand(args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, &model.CommandError{Code: model.CodeSyntax, Message: "empty command"}
+	}
+
+	name := strings.ToUpper(args[0])
+	spec, ok := commands[name]
+	if !ok {
+		return nil, &model.CommandError{
+			Code:    model.CodeUnknownCommand,
+			Command: args[0],
+			Message: fmt.Sprintf("unknown command '%s'", args[0]),
+		}
+	}
+
+	if (spec.arity > 0 && len(args) != spec.arity) || (spec.arity < 0 && len(args) < -spec.arity) {
+		return nil, &model.CommandError{
+			Code:    model.CodeWrongArity,
+			Command: name,
+			Message: fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(name)),
+		}
+	}
+
+	req, err := spec.parse(args[1:])
+	if err != nil {
+		if _, ok := err.(*model.CommandError); ok {
+			return nil, err
+		}
+		return nil, &model.CommandError{Code: model.CodeSyntax, Command: name, Message: err.Error()}
+	}
+
+	return req, nil
+}
+
+// Tokenize splits a command line into arguments. Arguments are separated by
+// whitespace and may be wrapped in double quotes, which understand the
+// escapes \" \\ \n \r \t \b \a and \xHH, or in single quotes, which only
+// understand \'.
+func Tokenize(line string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+	)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+
+		case c == '"' || c == '\'':
+			end, err := readQuoted(line, i, &current)
+			if err != nil {
+				return nil, err
+			}
+			// A closing quote must be followed by a separator
+			if end+1 < len(line) && !strings.ContainsRune(" \t\n\r", rune(line[end+1])) {
+				return nil, &model.CommandError{Code: model.CodeSyntax, Message: "closing quote must be followed by a space"}
+			}
+			i = end
+			inArg = true
+
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// readQuoted consumes the quoted string starting at line[start] into buf and
+// returns the index of the closing quote.
+func readQuoted(line string, start int, buf *strings.Builder) (int, error) {
+	quote := line[start]
+
+	for i := start + 1; i < len(line); i++ {
+		c := line[i]
+
+		if c == quote {
+			return i, nil
+		}
+
+		if c != '\\' || i+1 >= len(line) {
+			buf.WriteByte(c)
+			continue
+		}
+
+		next := line[i+1]
+		if quote == '\'' {
+			if next == '\'' {
+				buf.WriteByte('\'')
+				i++
+			} else {
+				buf.WriteByte(c)
+			}
+			continue
+		}
+
+		i++
+		switch next {
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'b':
+			buf.WriteByte('\b')
+		case 'a':
+			buf.WriteByte('\a')
+		case 'x':
+			if i+2 < len(line) {
+				if b, err := strconv.ParseUint(line[i+1:i+3], 16, 8); err == nil {
+					buf.WriteByte(byte(b))
+					i += 2
+					continue
+				}
+			}
+			buf.WriteByte('x')
+		default:
+			buf.WriteByte(next)
+		}
+	}
+
+	return 0, &model.CommandError{Code: model.CodeSyntax, Message: "unbalanced quotes in command"}
+}
+
+// SET key value [EX seconds | PX milliseconds | EXAT unix-seconds |
+// PXAT unix-milliseconds | KEEPTTL] [NX | XX]
+func parseSetCommand(args []string) (interface{}, error) {
+	req := model.SetRequest{Key: args[0], Value: args[1]}
+
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX", "XX":
+			if req.Condition != "" {
+				return nil, errSyntax
+			}
+			req.Condition = opt
+
+		case "KEEPTTL":
+			if !req.ExpiresAt.IsZero() || req.KeepTTL {
+				return nil, errSyntax
+			}
+			req.KeepTTL = true
+
+		case "EX", "PX", "EXAT", "PXAT":
+			if !req.ExpiresAt.IsZero() || req.KeepTTL || i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			expiresAt, err := parseExpiry("set", opt, args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			req.ExpiresAt = expiresAt
+			i++
+
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	if err := validateSetRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// parseExpiry turns the argument of the EX, PX, EXAT or PXAT option of the
+// command name into an absolute expiry
+func parseExpiry(name, opt, arg string) (time.Time, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, fmt.Errorf("invalid expire time in '%s' command", name)
+	}
+
+	var expiresAt time.Time
+	var ok bool
+	switch opt {
+	case "EX":
+		expiresAt, ok = expiryAfter(n, time.Second, false)
+	case "PX":
+		expiresAt, ok = expiryAfter(n, time.Millisecond, false)
+	case "EXAT":
+		expiresAt, ok = expiryAfter(n, time.Second, true)
+	default:
+		expiresAt, ok = expiryAfter(n, time.Millisecond, true)
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid expire time in '%s' command", name)
+	}
+
+	return expiresAt, nil
+}
+
+// expiryAfter returns the expiry n units from now, or n units after the
+// unix epoch if absolute. It reports false if the expiry does not fit in a
+// time.Duration from now, or in int64 unix milliseconds.
+func expiryAfter(n int64, unit time.Duration, absolute bool) (time.Time, bool) {
+	if absolute {
+		if unit == time.Second {
+			if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+				return time.Time{}, false
+			}
+			return time.Unix(n, 0), true
+		}
+		return time.UnixMilli(n), true
+	}
+
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return time.Time{}, false
+	}
+	return time.Now().Add(time.Duration(n) * unit), true
+}
+
+// parseCreateExpiry parses the optional [EX seconds | PX milliseconds |
+// EXAT unix-seconds | PXAT unix-milliseconds] that ends the command name,
+// the expiry of keys the command creates
+func parseCreateExpiry(name string, args []string) (time.Time, error) {
+	if len(args) == 0 {
+		return time.Time{}, nil
+	}
+	if len(args) != 2 {
+		return time.Time{}, errSyntax
+	}
+
+	switch opt := strings.ToUpper(args[0]); opt {
+	case "EX", "PX", "EXAT", "PXAT":
+		return parseExpiry(name, opt, args[1])
+	default:
+		return time.Time{}, errSyntax
+	}
+}
+
+// GET key
+func parseGetCommand(args []string) (interface{}, error) {
+	req := model.GetRequest{Key: args[0]}
+	if err := validateGetRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// INCR key and DECR key, or INCRBY key increment and DECRBY key decrement
+// when withDelta, followed by the expiry of a key they create:
+// [EX seconds | PX milliseconds | EXAT unix-seconds | PXAT unix-milliseconds]
+func parseIncrCommand(name string, sign int64, withDelta bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		req := model.IncrRequest{Key: args[0], Delta: sign}
+		rest := args[1:]
+
+		if withDelta {
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return nil, kvstore.ErrNotInteger
+			}
+			if sign < 0 && n == math.MinInt64 {
+				return nil, kvstore.ErrOverflow
+			}
+			req.Delta = sign * n
+			rest = args[2:]
+		}
+
+		expiresAt, err := parseCreateExpiry(name, rest)
+		if err != nil {
+			return nil, err
+		}
+		req.ExpiresAt = expiresAt
+
+		if err := validateIncrRequest(&req); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// INCRBYFLOAT key increment [EX seconds | PX milliseconds |
+// EXAT unix-seconds | PXAT unix-milliseconds]
+func parseIncrByFloatCommand(args []string) (interface{}, error) {
+	delta, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, kvstore.ErrNotFloat
+	}
+
+	expiresAt, err := parseCreateExpiry("incrbyfloat", args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	req := model.IncrByFloatRequest{Key: args[0], Delta: delta, ExpiresAt: expiresAt}
+	if err := validateIncrByFloatRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// TTL key and PTTL key
+func parseTTLCommand(milliseconds bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		req := model.TTLRequest{Key: args[0], Milliseconds: milliseconds}
+		if err := validateTTLRequest(&req); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// EXPIRE key seconds [NX | XX | GT | LT], and PEXPIRE, EXPIREAT and
+// PEXPIREAT which take milliseconds and absolute unix times instead
+func parseExpireCommand(name string, unit time.Duration, absolute bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		if len(args) > 3 {
+			return nil, errSyntax
+		}
+
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, errors.New("value is not an integer or out of range")
+		}
+
+		// Absolute times are kept absolute so that a command replayed
+		// from the append-only file means the same thing
+		expiresAt, ok := expiryAfter(n, unit, absolute)
+		if !ok {
+			return nil, fmt.Errorf("invalid expire time in '%s' command", name)
+		}
+		req := model.ExpireRequest{Key: args[0], ExpiresAt: expiresAt}
+
+		if len(args) == 3 {
+			req.Condition = strings.ToUpper(args[2])
+		}
+
+		if err := validateExpireRequest(&req); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// PERSIST key
+func parsePersistCommand(args []string) (interface{}, error) {
+	req := model.PersistRequest{Key: args[0]}
+	if err := validatePersistRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// HSET key field value [field value ...]
+func parseHSetCommand(args []string) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return nil, &model.CommandError{
+			Code:    model.CodeWrongArity,
+			Command: "HSET",
+			Message: "wrong number of arguments for 'hset' command",
+		}
+	}
+
+	req := model.HSetRequest{Key: args[0], Fields: make(map[string]string, len(args)/2)}
+	for i := 1; i < len(args); i += 2 {
+		req.Fields[args[i]] = args[i+1]
+	}
+	if err := validateHSetRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// HGET key field
+func parseHGetCommand(args []string) (interface{}, error) {
+	req := model.HGetRequest{Key: args[0], Field: args[1]}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// HDEL key field [field ...]
+func parseHDelCommand(args []string) (interface{}, error) {
+	req := model.HDelRequest{Key: args[0], Fields: args[1:]}
+	if err := validateHDelRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// HGETALL key
+func parseHGetAllCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.HGetAllRequest{Key: args[0]}, nil
+}
+
+// HINCRBY key field increment
+func parseHIncrByCommand(args []string) (interface{}, error) {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, kvstore.ErrNotInteger
+	}
+
+	req := model.HIncrByRequest{Key: args[0], Field: args[1], Delta: delta}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// HLEN key
+func parseHLenCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.HLenRequest{Key: args[0]}, nil
+}
+
+// SADD key member [member ...]
+func parseSAddCommand(args []string) (interface{}, error) {
+	req := model.SAddRequest{Key: args[0], Members: args[1:]}
+	if err := validateSetMembers(req.Key, req.Members); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// SREM key member [member ...]
+func parseSRemCommand(args []string) (interface{}, error) {
+	req := model.SRemRequest{Key: args[0], Members: args[1:]}
+	if err := validateSetMembers(req.Key, req.Members); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// SMEMBERS key
+func parseSMembersCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.SMembersRequest{Key: args[0]}, nil
+}
+
+// SISMEMBER key member
+func parseSIsMemberCommand(args []string) (interface{}, error) {
+	req := model.SIsMemberRequest{Key: args[0], Member: args[1]}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// SCARD key
+func parseSCardCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.SCardRequest{Key: args[0]}, nil
+}
+
+// SINTER|SUNION|SDIFF key [key ...]
+func parseSetOperationCommand(op string) commandParser {
+	return func(args []string) (interface{}, error) {
+		req := model.SetOperationRequest{Op: op, Keys: args}
+		if err := validateKeys(req.Keys); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// SINTERSTORE|SUNIONSTORE|SDIFFSTORE destination key [key ...]
+func parseSetOperationStoreCommand(op string) commandParser {
+	return func(args []string) (interface{}, error) {
+		req := model.SetOperationStoreRequest{Op: op, Destination: args[0], Keys: args[1:]}
+		if err := validateSetOperationStoreRequest(&req); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// ZADD key [NX|XX] [GT|LT] score member [score member ...]
+func parseZAddCommand(args []string) (interface{}, error) {
+	req := model.ZAddRequest{Key: args[0]}
+
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX", "XX":
+			if req.Condition != "" {
+				return nil, errSyntax
+			}
+			req.Condition = opt
+		case "GT", "LT":
+			if req.Comparison != "" {
+				return nil, errSyntax
+			}
+			req.Comparison = opt
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, errSyntax
+	}
+	req.Members = make(map[string]float64, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := parseScore(pairs[j])
+		if err != nil {
+			return nil, err
+		}
+		req.Members[pairs[j+1]] = score
+	}
+
+	if err := validateZAddRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// parseScore parses a score or increment of a sorted set, which must be
+// finite
+func parseScore(arg string) (float64, error) {
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, kvstore.ErrNotFloat
+	}
+
+	return f, nil
+}
+
+// ZINCRBY key increment member
+func parseZIncrByCommand(args []string) (interface{}, error) {
+	delta, err := parseScore(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	req := model.ZIncrByRequest{Key: args[0], Delta: delta, Member: args[2]}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// ZRANK|ZREVRANK key member
+func parseZRankCommand(rev bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		req := model.ZRankRequest{Key: args[0], Member: args[1], Rev: rev}
+		if err := validateKeys([]string{req.Key}); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// ZSCORE key member
+func parseZScoreCommand(args []string) (interface{}, error) {
+	req := model.ZScoreRequest{Key: args[0], Member: args[1]}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// ZCARD key
+func parseZCardCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.ZCardRequest{Key: args[0]}, nil
+}
+
+// ZREM key member [member ...]
+func parseZRemCommand(args []string) (interface{}, error) {
+	req := model.ZRemRequest{Key: args[0], Members: args[1:]}
+	if err := validateSetMembers(req.Key, req.Members); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// ZREMRANGEBYSCORE key min max
+func parseZRemRangeByScoreCommand(args []string) (interface{}, error) {
+	req := model.ZRemRangeByScoreRequest{Key: args[0], Min: args[1], Max: args[2]}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func parseZRangeCommand(args []string) (interface{}, error) {
+	req := model.ZRangeRequest{Key: args[0], Start: args[1], Stop: args[2]}
+
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "BYSCORE", "BYLEX":
+			if req.By != "" {
+				return nil, errSyntax
+			}
+			req.By = strings.TrimPrefix(opt, "BY")
+
+		case "REV":
+			req.Rev = true
+
+		case "WITHSCORES":
+			req.WithScores = true
+
+		case "LIMIT":
+			if req.Limit != nil || i+2 >= len(args) {
+				return nil, errSyntax
+			}
+			offset, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, kvstore.ErrNotInteger
+			}
+			count, err := strconv.Atoi(args[i+2])
+			if err != nil {
+				return nil, kvstore.ErrNotInteger
+			}
+			req.Limit = &model.ZLimit{Offset: offset, Count: count}
+			i += 2
+
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	if err := validateZRangeRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// DEL key [key ...]
+func parseDelCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.DelRequest{Keys: args}, nil
+}
+
+// EXISTS key [key ...]
+func parseExistsCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.ExistsRequest{Keys: args}, nil
+}
+
+// KEYS pattern
+func parseKeysCommand(args []string) (interface{}, error) {
+	return model.KeysRequest{Pattern: args[0]}, nil
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+func parseScanCommand(args []string) (interface{}, error) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	req := model.ScanRequest{Cursor: cursor}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			req.Match = args[i+1]
+		case "COUNT":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, errors.New("value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, errSyntax
+			}
+			req.Count = count
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	return req, nil
+}
+
+// QPUSH key value [value ...]
+func parseQPushCommand(args []string) (interface{}, error) {
+	values := make([]interface{}, len(args)-1)
+	for i, v := range args[1:] {
+		values[i] = v
+	}
+
+	req := model.QPushRequest{Key: args[0], Values: values}
+	if err := validateQPushRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// QPUSHIN key seconds value [value ...] and QPUSHAT key unix-milliseconds
+// value [value ...]
+func parseQPushLaterCommand(absolute bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		req, err := parseQPushCommand(append([]string{args[0]}, args[2:]...))
+		if err != nil {
+			return nil, err
+		}
+		push := req.(model.QPushRequest)
+
+		if absolute {
+			ms, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || ms <= 0 {
+				return nil, errors.New("invalid time in 'qpushat' command")
+			}
+			push.NotBefore = time.UnixMilli(ms)
+		} else {
+			push.Delay, err = parseSeconds(args[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return push, nil
+	}
+}
+
+// QPUSHPRI key priority value [value ...]
+func parseQPushPriorityCommand(args []string) (interface{}, error) {
+	priority, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, kvstore.ErrNotInteger
+	}
+
+	req, err := parseQPushCommand(append([]string{args[0]}, args[2:]...))
+	if err != nil {
+		return nil, err
+	}
+	push := req.(model.QPushRequest)
+	push.Priority = &priority
+
+	return push, nil
+}
+
+// QPOP key
+func parseQPopCommand(args []string) (interface{}, error) {
+	req := model.QPopRequest{Key: args[0]}
+	if err := validateQPopRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// BQPOP key [key ...] timeout, timeout being in (possibly fractional)
+// seconds
+func parseBQPopCommand(args []string) (interface{}, error) {
+	timeout, err := parseSeconds(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	req := model.BQPopRequest{Keys: args[:len(args)-1], Timeout: timeout}
+	if err := validateBQPopRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// LPUSH|RPUSH key value [value ...]
+func parseListPushCommand(left bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		values := make([]interface{}, len(args)-1)
+		for i, v := range args[1:] {
+			values[i] = v
+		}
+
+		req := model.ListPushRequest{Left: left, Key: args[0], Values: values}
+		if err := validateListPushRequest(&req); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// LPOP|RPOP key
+func parseListPopCommand(left bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		if err := validateKeys(args); err != nil {
+			return nil, err
+		}
+
+		return model.ListPopRequest{Left: left, Key: args[0]}, nil
+	}
+}
+
+// LLEN key
+func parseLLenCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.LLenRequest{Key: args[0]}, nil
+}
+
+// LINDEX key index
+func parseLIndexCommand(args []string) (interface{}, error) {
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, kvstore.ErrNotInteger
+	}
+
+	req := model.LIndexRequest{Key: args[0], Index: index}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// LRANGE key start stop
+func parseLRangeCommand(args []string) (interface{}, error) {
+	start, stop, err := parseListRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	req := model.LRangeRequest{Key: args[0], Start: start, Stop: stop}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// LTRIM key start stop
+func parseLTrimCommand(args []string) (interface{}, error) {
+	start, stop, err := parseListRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	req := model.LTrimRequest{Key: args[0], Start: start, Stop: stop}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func parseListRange(start, stop string) (int, int, error) {
+	i, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, kvstore.ErrNotInteger
+	}
+	j, err := strconv.Atoi(stop)
+	if err != nil {
+		return 0, 0, kvstore.ErrNotInteger
+	}
+
+	return i, j, nil
+}
+
+// LINSERT key BEFORE|AFTER pivot value
+func parseLInsertCommand(args []string) (interface{}, error) {
+	req := model.LInsertRequest{Key: args[0], Pivot: args[2], Value: args[3]}
+	switch strings.ToUpper(args[1]) {
+	case "BEFORE":
+		req.Before = true
+	case "AFTER":
+	default:
+		return nil, errSyntax
+	}
+
+	if err := validateLInsertRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// QRESERVE key seconds [PXAT unix-milliseconds]
+func parseQReserveCommand(args []string) (interface{}, error) {
+	visibility, err := parseSeconds(args[1])
+	if err != nil {
+		return nil, err
+	}
+	req := model.QReserveRequest{Key: args[0], Visibility: visibility}
+
+	switch {
+	case len(args) == 4 && strings.ToUpper(args[2]) == "PXAT":
+		deadline, err := parseExpiry("qreserve", "PXAT", args[3])
+		if err != nil {
+			return nil, err
+		}
+		req.Deadline = deadline
+	case len(args) != 2:
+		return nil, errSyntax
+	}
+
+	if err := validateQReserveRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// QACK key id [id ...] and QNACK key id [id ...] [REASON reason]
+// [PXAT unix-milliseconds]
+func parseQAckCommand(nack bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		req := model.QAckRequest{Nack: nack, Key: args[0], IDs: args[1:]}
+		if n := len(req.IDs); nack && n >= 3 && strings.ToUpper(req.IDs[n-2]) == "PXAT" {
+			at, err := parseExpiry("qnack", "PXAT", req.IDs[n-1])
+			if err != nil {
+				return nil, err
+			}
+			req.IDs, req.At = req.IDs[:n-2], at
+		}
+		if n := len(req.IDs); nack && n >= 3 && strings.ToUpper(req.IDs[n-2]) == "REASON" {
+			req.IDs, req.Reason = req.IDs[:n-2], req.IDs[n-1]
+		}
+		if err := validateQAckRequest(&req); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// QCONFIG key [MAXATTEMPTS n] [DEADLETTER dlq]
+func parseQConfigCommand(args []string) (interface{}, error) {
+	req := model.QConfigRequest{Key: args[0]}
+
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "MAXATTEMPTS":
+			if req.MaxAttempts != nil {
+				return nil, errSyntax
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, kvstore.ErrNotInteger
+			}
+			req.MaxAttempts = &n
+
+		case "DEADLETTER":
+			if req.DeadLetter != nil {
+				return nil, errSyntax
+			}
+			dlq := args[i+1]
+			req.DeadLetter = &dlq
+
+		case "MAXLEN":
+			if req.MaxLen != nil {
+				return nil, errSyntax
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, kvstore.ErrNotInteger
+			}
+			req.MaxLen = &n
+
+		case "OVERFLOW":
+			if req.Overflow != nil {
+				return nil, errSyntax
+			}
+			overflow := strings.ToLower(args[i+1])
+			req.Overflow = &overflow
+
+		case "BLOCKTIMEOUT":
+			if req.BlockTimeout != nil {
+				return nil, errSyntax
+			}
+			timeout, err := parseSeconds(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			req.BlockTimeout = &timeout
+
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	if err := validateQConfigRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// QDLLIST dlq [start stop], every message by default
+func parseQDLListCommand(args []string) (interface{}, error) {
+	req := model.QDLListRequest{Key: args[0], Stop: -1}
+
+	switch len(args) {
+	case 1:
+	case 3:
+		start, stop, err := parseListRange(args[1], args[2])
+		if err != nil {
+			return nil, err
+		}
+		req.Start, req.Stop = start, stop
+	default:
+		return nil, errSyntax
+	}
+
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// QDLGET dlq id
+func parseQDLGetCommand(args []string) (interface{}, error) {
+	req := model.QDLGetRequest{Key: args[0], ID: args[1]}
+	if err := validateQDLGetRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// QDLREQUEUE|QDLPURGE dlq [id ...]
+func parseQDLRequeueCommand(purge bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		req := model.QDLRequeueRequest{Purge: purge, Key: args[0], IDs: args[1:]}
+		if err := validateKeys([]string{req.Key}); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// QPEEK key
+func parseQPeekCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.QPeekRequest{Key: args[0]}, nil
+}
+
+// QSTATS key
+func parseQStatsCommand(args []string) (interface{}, error) {
+	if err := validateKeys(args); err != nil {
+		return nil, err
+	}
+
+	return model.QStatsRequest{Key: args[0]}, nil
+}
+
+// QPOPN key count
+func parseQPopNCommand(args []string) (interface{}, error) {
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, kvstore.ErrNotInteger
+	}
+
+	req := model.QPopNRequest{Key: args[0], Count: count}
+	if err := validateQPopNRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// QPROMOTE key [count]
+func parseQPromoteCommand(args []string) (interface{}, error) {
+	if len(args) > 2 {
+		return nil, errSyntax
+	}
+
+	req := model.QPromoteRequest{Key: args[0]}
+	if len(args) == 2 {
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, kvstore.ErrNotInteger
+		}
+		if count <= 0 {
+			return nil, errors.New("count must be positive")
+		}
+		req.Count = count
+	}
+	if err := validateQPromoteRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// QMOVE source destination [LEFT|RIGHT LEFT|RIGHT] and BQMOVE source
+// destination [LEFT|RIGHT LEFT|RIGHT] timeout, LEFT RIGHT by default
+func parseQMoveCommand(block bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		req := model.QMoveRequest{Block: block, Source: args[0], Destination: args[1]}
+
+		rest := args[2:]
+		if block {
+			timeout, err := parseSeconds(rest[len(rest)-1])
+			if err != nil {
+				return nil, err
+			}
+			req.Timeout = timeout
+			rest = rest[:len(rest)-1]
+		}
+
+		switch len(rest) {
+		case 0:
+		case 2:
+			from, to := strings.ToUpper(rest[0]), strings.ToUpper(rest[1])
+			if (from != "LEFT" && from != "RIGHT") || (to != "LEFT" && to != "RIGHT") {
+				return nil, errSyntax
+			}
+			req.FromTail, req.ToHead = from == "RIGHT", to == "LEFT"
+		default:
+			return nil, errSyntax
+		}
+
+		if err := validateQMoveRequest(&req); err != nil {
+			return nil, err
+		}
+
+		return req, nil
+	}
+}
+
+// SAVE and BGSAVE
+func parseSaveCommand(background bool) commandParser {
+	return func(args []string) (interface{}, error) {
+		return model.SaveRequest{Background: background}, nil
+	}
+}
+
+// LASTSAVE
+func parseLastSaveCommand(args []string) (interface{}, error) {
+	return model.LastSaveRequest{}, nil
+}
+
+func parseSeconds(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs < 0 {
+		return 0, errors.New("timeout is not a float or out of range")
+	}
+
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// Dispatch hands a parsed model request to the endpoint serving it
+func (e Endpoints) Dispatch(ctx context.Context, request interface{}) (interface{}, error) {
+	switch request.(type) {
+	case model.SetRequest:
+		return e.SetEndpoint(ctx, request)
+	case model.IncrRequest:
+		return e.IncrEndpoint(ctx, request)
+	case model.IncrByFloatRequest:
+		return e.IncrByFloatEndpoint(ctx, request)
+	case model.GetRequest:
+		return e.GetEndpoint(ctx, request)
+	case model.TTLRequest:
+		return e.TTLEndpoint(ctx, request)
+	case model.ExpireRequest:
+		return e.ExpireEndpoint(ctx, request)
+	case model.PersistRequest:
+		return e.PersistEndpoint(ctx, request)
+	case model.HSetRequest:
+		return e.HSetEndpoint(ctx, request)
+	case model.HGetRequest:
+		return e.HGetEndpoint(ctx, request)
+	case model.HDelRequest:
+		return e.HDelEndpoint(ctx, request)
+	case model.HGetAllRequest:
+		return e.HGetAllEndpoint(ctx, request)
+	case model.HIncrByRequest:
+		return e.HIncrByEndpoint(ctx, request)
+	case model.HLenRequest:
+		return e.HLenEndpoint(ctx, request)
+	case model.SAddRequest:
+		return e.SAddEndpoint(ctx, request)
+	case model.SRemRequest:
+		return e.SRemEndpoint(ctx, request)
+	case model.SMembersRequest:
+		return e.SMembersEndpoint(ctx, request)
+	case model.SIsMemberRequest:
+		return e.SIsMemberEndpoint(ctx, request)
+	case model.SCardRequest:
+		return e.SCardEndpoint(ctx, request)
+	case model.ZAddRequest:
+		return e.ZAddEndpoint(ctx, request)
+	case model.ZIncrByRequest:
+		return e.ZIncrByEndpoint(ctx, request)
+	case model.ZRankRequest:
+		return e.ZRankEndpoint(ctx, request)
+	case model.ZScoreRequest:
+		return e.ZScoreEndpoint(ctx, request)
+	case model.ZCardRequest:
+		return e.ZCardEndpoint(ctx, request)
+	case model.ZRemRequest:
+		return e.ZRemEndpoint(ctx, request)
+	case model.ZRemRangeByScoreRequest:
+		return e.ZRemRangeByScoreEndpoint(ctx, request)
+	case model.ZRangeRequest:
+		return e.ZRangeEndpoint(ctx, request)
+	case model.SetOperationRequest:
+		return e.SetOperationEndpoint(ctx, request)
+	case model.SetOperationStoreRequest:
+		return e.SetOperationStoreEndpoint(ctx, request)
+	case model.DelRequest:
+		return e.DelEndpoint(ctx, request)
+	case model.ExistsRequest:
+		return e.ExistsEndpoint(ctx, request)
+	case model.KeysRequest:
+		return e.KeysEndpoint(ctx, request)
+	case model.ScanRequest:
+		return e.ScanEndpoint(ctx, request)
+	case model.QPushRequest:
+		return e.QPushEndpoint(ctx, request)
+	case model.QPopRequest:
+		return e.QPopEndpoint(ctx, request)
+	case model.BQPopRequest:
+		return e.BQPopEndpoint(ctx, request)
+	case model.ListPushRequest:
+		return e.ListPushEndpoint(ctx, request)
+	case model.ListPopRequest:
+		return e.ListPopEndpoint(ctx, request)
+	case model.LLenRequest:
+		return e.LLenEndpoint(ctx, request)
+	case model.LIndexRequest:
+		return e.LIndexEndpoint(ctx, request)
+	case model.LRangeRequest:
+		return e.LRangeEndpoint(ctx, request)
+	case model.LTrimRequest:
+		return e.LTrimEndpoint(ctx, request)
+	case model.LInsertRequest:
+		return e.LInsertEndpoint(ctx, request)
+	case model.QReserveRequest:
+		return e.QReserveEndpoint(ctx, request)
+	case model.QAckRequest:
+		return e.QAckEndpoint(ctx, request)
+	case model.QConfigRequest:
+		return e.QConfigEndpoint(ctx, request)
+	case model.QDLListRequest:
+		return e.QDLListEndpoint(ctx, request)
+	case model.QDLGetRequest:
+		return e.QDLGetEndpoint(ctx, request)
+	case model.QDLRequeueRequest:
+		return e.QDLRequeueEndpoint(ctx, request)
+	case model.QPeekRequest:
+		return e.QPeekEndpoint(ctx, request)
+	case model.QStatsRequest:
+		return e.QStatsEndpoint(ctx, request)
+	case model.QPopNRequest:
+		return e.QPopNEndpoint(ctx, request)
+	case model.QPromoteRequest:
+		return e.QPromoteEndpoint(ctx, request)
+	case model.QMoveRequest:
+		return e.QMoveEndpoint(ctx, request)
+	case model.SaveRequest:
+		return e.SaveEndpoint(ctx, request)
+	case model.LastSaveRequest:
+		return e.LastSaveEndpoint(ctx, request)
+	}
+
+	return nil, &model.CommandError{
+		Code:    model.CodeUnknownCommand,
+		Message: fmt.Sprintf("no endpoint for request of type %T", request),
+	}
+}
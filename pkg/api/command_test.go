@@ -0,0 +1,187 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sprectza/go-kvstore/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"SET key1 value1", []string{"SET", "key1", "value1"}},
+		{"  GET   key1  ", []string{"GET", "key1"}},
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key "a\"b\\c\n\x41"`, []string{"SET", "key", "a\"b\\c\nA"}},
+		{`SET key 'it\'s \n'`, []string{"SET", "key", `it's \n`}},
+		{`SET key ""`, []string{"SET", "key", ""}},
+	}
+
+	for _, tt := range tests {
+		got, err := Tokenize(tt.line)
+		assert.NoError(t, err, tt.line)
+		assert.Equal(t, tt.want, got, tt.line)
+	}
+
+	_, err := Tokenize(`SET key "unterminated`)
+	assert.Error(t, err)
+
+	_, err = Tokenize(`SET key "a"b`)
+	assert.Error(t, err)
+}
+
+func TestParseCommand(t *testing.T) {
+	req, err := ParseCommand([]string{"set", "key1", "value1", "EX", "10", "nx"})
+	assert.NoError(t, err)
+	setReq := req.(model.SetRequest)
+	assert.Equal(t, "key1", setReq.Key)
+	assert.Equal(t, "value1", setReq.Value)
+	assert.Equal(t, "NX", setReq.Condition)
+	assert.WithinDuration(t, time.Now().Add(10*time.Second), setReq.ExpiresAt, time.Second)
+
+	req, err = ParseCommand([]string{"QPUSH", "queue1", "a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QPushRequest{Key: "queue1", Values: []interface{}{"a", "b"}}, req)
+
+	req, err = ParseCommand([]string{"QPUSHIN", "queue1", "1.5", "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QPushRequest{Key: "queue1", Values: []interface{}{"a"}, Delay: 1500 * time.Millisecond}, req)
+
+	req, err = ParseCommand([]string{"QPUSHAT", "queue1", "1700000000000", "a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QPushRequest{Key: "queue1", Values: []interface{}{"a", "b"}, NotBefore: time.UnixMilli(1700000000000)}, req)
+
+	req, err = ParseCommand([]string{"QPUSHPRI", "queue1", "-2", "a"})
+	assert.NoError(t, err)
+	priority := int64(-2)
+	assert.Equal(t, model.QPushRequest{Key: "queue1", Values: []interface{}{"a"}, Priority: &priority}, req)
+
+	req, err = ParseCommand([]string{"DECRBY", "hits", "5", "px", "60000"})
+	assert.NoError(t, err)
+	incrReq := req.(model.IncrRequest)
+	assert.Equal(t, int64(-5), incrReq.Delta)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), incrReq.ExpiresAt, time.Second)
+
+	req, err = ParseCommand([]string{"BQPOP", "queue1", "1.5"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.BQPopRequest{Keys: []string{"queue1"}, Timeout: 1500 * time.Millisecond}, req)
+
+	req, err = ParseCommand([]string{"BQPOP", "high", "low", "0"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.BQPopRequest{Keys: []string{"high", "low"}}, req)
+
+	req, err = ParseCommand([]string{"QRESERVE", "jobs", "0.5", "pxat", "1700000000000"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QReserveRequest{Key: "jobs", Visibility: 500 * time.Millisecond, Deadline: time.UnixMilli(1700000000000)}, req)
+
+	req, err = ParseCommand([]string{"QNACK", "jobs", "1-0", "2-0", "reason", "timed out"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QAckRequest{Nack: true, Key: "jobs", IDs: []string{"1-0", "2-0"}, Reason: "timed out"}, req)
+
+	req, err = ParseCommand([]string{"QNACK", "jobs", "1-0", "REASON", "boom", "PXAT", "1700000000000"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QAckRequest{Nack: true, Key: "jobs", IDs: []string{"1-0"}, Reason: "boom", At: time.UnixMilli(1700000000000)}, req)
+
+	req, err = ParseCommand([]string{"QCONFIG", "jobs", "maxattempts", "3", "DEADLETTER", "jobs:dead"})
+	assert.NoError(t, err)
+	maxAttempts, dlq := 3, "jobs:dead"
+	assert.Equal(t, model.QConfigRequest{Key: "jobs", MaxAttempts: &maxAttempts, DeadLetter: &dlq}, req)
+
+	req, err = ParseCommand([]string{"QCONFIG", "jobs", "MAXLEN", "100", "OVERFLOW", "Block", "BLOCKTIMEOUT", "1.5"})
+	assert.NoError(t, err)
+	maxLen, overflow, timeout := 100, "block", 1500*time.Millisecond
+	assert.Equal(t, model.QConfigRequest{Key: "jobs", MaxLen: &maxLen, Overflow: &overflow, BlockTimeout: &timeout}, req)
+
+	req, err = ParseCommand([]string{"QDLLIST", "jobs:dead"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QDLListRequest{Key: "jobs:dead", Stop: -1}, req)
+
+	req, err = ParseCommand([]string{"QRANGE", "jobs", "0", "-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.LRangeRequest{Key: "jobs", Start: 0, Stop: -1}, req)
+
+	req, err = ParseCommand([]string{"QSTATS", "jobs"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QStatsRequest{Key: "jobs"}, req)
+
+	req, err = ParseCommand([]string{"QPOPN", "jobs", "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QPopNRequest{Key: "jobs", Count: 10}, req)
+
+	req, err = ParseCommand([]string{"QPROMOTE", "jobs"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QPromoteRequest{Key: "jobs"}, req)
+
+	req, err = ParseCommand([]string{"QPROMOTE", "jobs", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QPromoteRequest{Key: "jobs", Count: 2}, req)
+
+	req, err = ParseCommand([]string{"QMOVE", "jobs", "done"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QMoveRequest{Source: "jobs", Destination: "done"}, req)
+
+	req, err = ParseCommand([]string{"BQMOVE", "jobs", "done", "right", "LEFT", "0.5"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.QMoveRequest{Block: true, Source: "jobs", Destination: "done", FromTail: true, ToHead: true, Timeout: 500 * time.Millisecond}, req)
+
+	errorCases := []struct {
+		args []string
+		code string
+	}{
+		{[]string{"FLY", "away"}, model.CodeUnknownCommand},
+		{[]string{"GET"}, model.CodeWrongArity},
+		{[]string{"GET", "a", "b"}, model.CodeWrongArity},
+		{[]string{"QPUSH", "queue1"}, model.CodeWrongArity},
+		{[]string{"QPUSHIN", "queue1", "5"}, model.CodeWrongArity},
+		{[]string{"QPUSHIN", "queue1", "-5", "a"}, model.CodeSyntax},
+		{[]string{"QPUSHAT", "queue1", "soon", "a"}, model.CodeSyntax},
+		{[]string{"QPUSHPRI", "queue1", "high", "a"}, model.CodeSyntax},
+		{[]string{"SET", "k", "v", "EX"}, model.CodeSyntax},
+		{[]string{"SET", "k", "v", "EX", "ten"}, model.CodeSyntax},
+		{[]string{"SET", "k", "v", "NX", "XX"}, model.CodeSyntax},
+		{[]string{"BQPOP", "queue1", "-1"}, model.CodeSyntax},
+		{[]string{"BQPOP", "queue1"}, model.CodeWrongArity},
+		{[]string{"QRESERVE", "jobs", "0"}, model.CodeSyntax},
+		{[]string{"QRESERVE", "jobs", "30", "PXAT"}, model.CodeSyntax},
+		{[]string{"QACK", "jobs"}, model.CodeWrongArity},
+		{[]string{"QCONFIG", "jobs", "MAXATTEMPTS"}, model.CodeSyntax},
+		{[]string{"QCONFIG", "jobs", "MAXATTEMPTS", "-1"}, model.CodeSyntax},
+		{[]string{"QCONFIG", "jobs", "MAXLEN", "-1"}, model.CodeSyntax},
+		{[]string{"QCONFIG", "jobs", "OVERFLOW", "newest"}, model.CodeSyntax},
+		{[]string{"QDLLIST", "jobs:dead", "0"}, model.CodeSyntax},
+		{[]string{"QPEEK", "jobs", "2"}, model.CodeWrongArity},
+		{[]string{"QPOPN", "jobs", "0"}, model.CodeSyntax},
+		{[]string{"QPROMOTE", "jobs", "0"}, model.CodeSyntax},
+		{[]string{"QPROMOTE", "jobs", "1", "2"}, model.CodeSyntax},
+		{[]string{"QMOVE", "jobs", "done", "LEFT"}, model.CodeSyntax},
+		{[]string{"QMOVE", "jobs", "done", "UP", "LEFT"}, model.CodeSyntax},
+		{[]string{"BQMOVE", "jobs", "done"}, model.CodeWrongArity},
+		{[]string{"BQMOVE", "jobs", "done", "-1"}, model.CodeSyntax},
+		{[]string{"INCR", "k", "EX"}, model.CodeSyntax},
+		{[]string{"INCRBY", "k", "1.5"}, model.CodeSyntax},
+		{[]string{"DECRBY", "k", "-9223372036854775808"}, model.CodeSyntax},
+		{[]string{"INCRBYFLOAT", "k", "inf"}, model.CodeSyntax},
+		{[]string{"SET", "k", "v", "EX", "9223372036854775807"}, model.CodeSyntax},
+		{[]string{"SET", "k", "v", "PX", "9223372036854775807"}, model.CodeSyntax},
+		{[]string{"SET", "k", "v", "EXAT", "9223372036854775807"}, model.CodeSyntax},
+		{[]string{"EXPIRE", "k", "9223372036854775807"}, model.CodeSyntax},
+		{[]string{"EXPIRE", "k", "-9223372036854775807"}, model.CodeSyntax},
+		{[]string{"PEXPIRE", "k", "9223372036854775807"}, model.CodeSyntax},
+		{[]string{"EXPIREAT", "k", "9223372036854775807"}, model.CodeSyntax},
+	}
+
+	for _, tt := range errorCases {
+		_, err := ParseCommand(tt.args)
+		cmdErr, ok := err.(*model.CommandError)
+		if assert.True(t, ok, "%v: expected *model.CommandError, got %v", tt.args, err) {
+			assert.Equal(t, tt.code, cmdErr.Code, tt.args)
+		}
+	}
+
+	_, err = ParseCommand([]string{"EXPIRE", "k", "9223372036854775807"})
+	assert.EqualError(t, err, "invalid expire time in 'expire' command")
+}
@@ -48,7 +48,7 @@ func (mw loggingMiddleware) Get(key string) (value string, err error) {
 	return
 }
 
-func (mw loggingMiddleware) QPush(key string, values ...interface{}) {
+func (mw loggingMiddleware) QPush(ctx context.Context, key string, values ...interface{}) {
 	defer func(begin time.Time) {
 		_ = mw.logger.Log(
 			"method", "QPush",
@@ -58,7 +58,7 @@ func (mw loggingMiddleware) QPush(key string, values ...interface{}) {
 		)
 	}(time.Now())
 
-	mw.next.QPush(key, values...)
+	mw.next.QPush(ctx, key, values...)
 }
 
 func (mw loggingMiddleware) QPop(key string) (value interface{}, err error) {
@@ -75,10 +75,11 @@ func (mw loggingMiddleware) QPop(key string) (value interface{}, err error) {
 	return
 }
 
-func (mw loggingMiddleware) BQPop(key string, timeout time.Duration) (value interface{}, err error) {
+func (mw loggingMiddleware) BQPop(ctx context.Context, keys []string, timeout time.Duration) (key string, value interface{}, err error) {
 	defer func(begin time.Time) {
 		_ = mw.logger.Log(
 			"method", "BQPop",
+			"keys", keys,
 			"key", key,
 			"value", value,
 			"timeout", timeout,
@@ -86,7 +87,7 @@ func (mw loggingMiddleware) BQPop(key string, timeout time.Duration) (value inte
 		)
 	}(time.Now())
 
-	value, err = mw.next.BQPop(key, timeout)
+	key, value, err = mw.next.BQPop(ctx, keys, timeout)
 	return
 }
 */
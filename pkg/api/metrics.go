@@ -0,0 +1,152 @@
+package kvstore
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+)
+
+var (
+	expiredKeysTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kvstore_expired_keys_total",
+		Help: "Keys deleted by the active expiry process.",
+	})
+	expiredKeysPerSecond = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kvstore_expired_keys_per_second",
+		Help: "Keys deleted by the active expiry process over the last second.",
+	})
+	expireCycleSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kvstore_expire_cycle_seconds",
+		Help:    "Time spent in each active expiry cycle.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 8),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(expiredKeysTotal)
+	prometheus.MustRegister(expiredKeysPerSecond)
+	prometheus.MustRegister(expireCycleSeconds)
+}
+
+func observeExpireCycle(stats kvstore.ExpireStats) {
+	expiredKeysTotal.Add(float64(stats.Expired))
+	expiredKeysPerSecond.Set(stats.ExpiredPerSecond)
+	expireCycleSeconds.Observe(stats.Took.Seconds())
+}
+
+var (
+	shardKeysDesc = prometheus.NewDesc(
+		"kvstore_shard_keys",
+		"Keys held by each shard, including expired keys not deleted yet.",
+		[]string{"shard"}, nil,
+	)
+	shardVolatileKeysDesc = prometheus.NewDesc(
+		"kvstore_shard_volatile_keys",
+		"Keys with an expiry held by each shard.",
+		[]string{"shard"}, nil,
+	)
+)
+
+// shardCollector reports the stats of every shard of a store when scraped
+type shardCollector struct {
+	store *kvstore.ShardedKVStore
+}
+
+// NewShardCollector returns a collector for the per-shard key counts of
+// store, to be registered with Prometheus
+func NewShardCollector(store *kvstore.ShardedKVStore) prometheus.Collector {
+	return shardCollector{store: store}
+}
+
+func (c shardCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- shardKeysDesc
+	ch <- shardVolatileKeysDesc
+}
+
+func (c shardCollector) Collect(ch chan<- prometheus.Metric) {
+	for i, stats := range c.store.Stats() {
+		shard := strconv.Itoa(i)
+		ch <- prometheus.MustNewConstMetric(shardKeysDesc, prometheus.GaugeValue, float64(stats.Keys), shard)
+		ch <- prometheus.MustNewConstMetric(shardVolatileKeysDesc, prometheus.GaugeValue, float64(stats.Volatile), shard)
+	}
+}
+
+var (
+	queueLengthDesc = prometheus.NewDesc(
+		"kvstore_queue_length",
+		"Values held by each queue.",
+		[]string{"queue"}, nil,
+	)
+	queueEnqueuedDesc = prometheus.NewDesc(
+		"kvstore_queue_enqueued_total",
+		"Values pushed to each queue.",
+		[]string{"queue"}, nil,
+	)
+	queueDequeuedDesc = prometheus.NewDesc(
+		"kvstore_queue_dequeued_total",
+		"Values popped or reserved from each queue.",
+		[]string{"queue"}, nil,
+	)
+	queueOldestAgeDesc = prometheus.NewDesc(
+		"kvstore_queue_oldest_age_seconds",
+		"How long the value pushed longest ago to each queue has been in it.",
+		[]string{"queue"}, nil,
+	)
+	queueWaitersDesc = prometheus.NewDesc(
+		"kvstore_queue_waiters",
+		"Pops blocked on each queue.",
+		[]string{"queue"}, nil,
+	)
+	queueRejectedDesc = prometheus.NewDesc(
+		"kvstore_queue_rejected_total",
+		"Pushes refused because each queue was at its max length.",
+		[]string{"queue"}, nil,
+	)
+	queueDroppedDesc = prometheus.NewDesc(
+		"kvstore_queue_dropped_total",
+		"Values dropped from each queue to make room for newer ones.",
+		[]string{"queue"}, nil,
+	)
+)
+
+// queueCollector reports the stats of every queue when scraped
+type queueCollector struct {
+	qs *queue.Queue
+}
+
+// NewQueueCollector returns a collector for the per-queue stats of qs, to
+// be registered with Prometheus. Queues are reported while their stats are
+// kept, so that keys used for a while do not stay as series forever.
+func NewQueueCollector(qs *queue.Queue) prometheus.Collector {
+	return queueCollector{qs: qs}
+}
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueLengthDesc
+	ch <- queueEnqueuedDesc
+	ch <- queueDequeuedDesc
+	ch <- queueOldestAgeDesc
+	ch <- queueWaitersDesc
+	ch <- queueRejectedDesc
+	ch <- queueDroppedDesc
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for key, stats := range c.qs.AllStats() {
+		var age float64
+		if !stats.OldestPushedAt.IsZero() {
+			age = now.Sub(stats.OldestPushedAt).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(queueLengthDesc, prometheus.GaugeValue, float64(stats.Len), key)
+		ch <- prometheus.MustNewConstMetric(queueEnqueuedDesc, prometheus.CounterValue, float64(stats.Enqueued), key)
+		ch <- prometheus.MustNewConstMetric(queueDequeuedDesc, prometheus.CounterValue, float64(stats.Dequeued), key)
+		ch <- prometheus.MustNewConstMetric(queueOldestAgeDesc, prometheus.GaugeValue, age, key)
+		ch <- prometheus.MustNewConstMetric(queueWaitersDesc, prometheus.GaugeValue, float64(stats.Waiters), key)
+		ch <- prometheus.MustNewConstMetric(queueRejectedDesc, prometheus.CounterValue, float64(stats.Rejected), key)
+		ch <- prometheus.MustNewConstMetric(queueDroppedDesc, prometheus.CounterValue, float64(stats.Dropped), key)
+	}
+}
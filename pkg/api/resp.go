@@ -0,0 +1,723 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/sprectza/go-kvstore/internal/resp"
+	"github.com/sprectza/go-kvstore/pkg/model"
+)
+
+// RESPServer serves the Service over the Redis serialization protocol
+// (RESP2), so that existing Redis clients can talk to go-kvstore.
+type RESPServer struct {
+	endpoints Endpoints
+	// Done once the server is closed, ending the commands still blocked
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	listener  net.Listener
+	conns     map[net.Conn]struct{}
+	closed    bool
+	closeOnce sync.Once
+}
+
+func NewRESPServer(s Service) *RESPServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RESPServer{
+		endpoints: MakeEndpoints(s),
+		ctx:       ctx,
+		cancel:    cancel,
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+func (srv *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return srv.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called
+func (srv *RESPServer) Serve(l net.Listener) error {
+	srv.mu.Lock()
+	if srv.closed {
+		srv.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	srv.listener = l
+	srv.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			srv.mu.Lock()
+			closed := srv.closed
+			srv.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return err
+		}
+
+		srv.mu.Lock()
+		srv.conns[conn] = struct{}{}
+		srv.mu.Unlock()
+
+		go srv.serveConn(conn)
+	}
+}
+
+// Close stops the listener and closes every client connection
+func (srv *RESPServer) Close() error {
+	var err error
+	srv.closeOnce.Do(func() {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+
+		srv.closed = true
+		srv.cancel()
+		if srv.listener != nil {
+			err = srv.listener.Close()
+		}
+		for conn := range srv.conns {
+			conn.Close()
+		}
+	})
+
+	return err
+}
+
+func (srv *RESPServer) serveConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(srv.ctx)
+	defer func() {
+		cancel()
+		conn.Close()
+		srv.mu.Lock()
+		delete(srv.conns, conn)
+		srv.mu.Unlock()
+	}()
+
+	watched := &watchedConn{Conn: conn}
+	r := resp.NewReader(watched)
+	w := resp.NewWriter(conn)
+
+	for {
+		args, err := r.ReadCommand()
+		if err != nil {
+			if errors.Is(err, resp.ErrProtocol) {
+				w.WriteError("ERR " + err.Error())
+				w.Flush()
+			} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("resp: reading from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		quit := srv.execute(ctx, watched, w, args)
+
+		// Pipelined commands are answered in one write once every
+		// command already received has been executed
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// execute runs one command and writes its reply. It reports whether the
+// client asked to close the connection.
+func (srv *RESPServer) execute(ctx context.Context, conn *watchedConn, w *resp.Writer, args []string) bool {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		if len(args) > 2 {
+			w.WriteError("ERR wrong number of arguments for 'ping' command")
+		} else if len(args) == 2 {
+			w.WriteBulkString(args[1])
+		} else {
+			w.WriteSimpleString("PONG")
+		}
+		return false
+	case "ECHO":
+		if len(args) != 2 {
+			w.WriteError("ERR wrong number of arguments for 'echo' command")
+		} else {
+			w.WriteBulkString(args[1])
+		}
+		return false
+	case "QUIT":
+		w.WriteSimpleString("OK")
+		return true
+	}
+
+	req, err := ParseCommand(args)
+	if err != nil {
+		writeRESPError(w, err)
+		return false
+	}
+
+	// A blocked pop ends as soon as its client leaves, so that the value it
+	// would have taken goes to another client. Pushes waiting for room are
+	// not watched, as most never wait and one from a client that left loses
+	// nothing.
+	switch req := req.(type) {
+	case model.BQPopRequest:
+		defer srv.watch(ctx, conn, &ctx)()
+	case model.QMoveRequest:
+		if req.Block {
+			defer srv.watch(ctx, conn, &ctx)()
+		}
+	}
+
+	response, err := srv.endpoints.Dispatch(ctx, req)
+	if err != nil {
+		writeRESPError(w, err)
+		return false
+	}
+
+	writeRESPReply(w, response)
+	return false
+}
+
+// watch sets *ctx to a child of parent that is done once the client closes
+// conn, and returns a function to stop watching
+func (srv *RESPServer) watch(parent context.Context, conn *watchedConn, ctx *context.Context) func() {
+	watched, cancel := context.WithCancel(parent)
+	*ctx = watched
+	stop := conn.watch(cancel)
+
+	return func() {
+		stop()
+		cancel()
+	}
+}
+
+// Bytes read from a client while watching it, past which it is no longer
+// watched
+const maxWatchBuffer = 64 * 1024
+
+// watchedConn is the connection of a client, which can be read from in the
+// background while a command blocks to learn when the client leaves. Bytes
+// read meanwhile, such as pipelined commands, are returned by the next
+// reads.
+type watchedConn struct {
+	net.Conn
+	pending []byte
+	err     error
+}
+
+func (c *watchedConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		err := c.err
+		c.err = nil
+		return 0, err
+	}
+
+	return c.Conn.Read(p)
+}
+
+// watch reads from the connection until the returned function is called,
+// calling left if the client closed it. The connection must not be read
+// from in between.
+func (c *watchedConn) watch(left func()) func() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		buf := make([]byte, 4096)
+		for len(c.pending) < maxWatchBuffer {
+			n, err := c.Conn.Read(buf)
+			c.pending = append(c.pending, buf[:n]...)
+			if err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					c.err = err
+					left()
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		// A read deadline in the past ends the read in progress
+		c.Conn.SetReadDeadline(time.Now())
+		<-done
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func writeRESPReply(w *resp.Writer, response interface{}) {
+	switch res := response.(type) {
+	case model.SetResponse:
+		switch {
+		case res.Err != nil:
+			writeRESPError(w, res.Err)
+		case res.Applied:
+			w.WriteSimpleString("OK")
+		default:
+			// NX or XX did not hold
+			w.WriteNull()
+		}
+
+	case model.GetResponse:
+		writeRESPValue(w, res.Value, res.Err)
+
+	case model.IncrResponse:
+		writeRESPInteger(w, res.Value, res.Err)
+
+	case model.IncrByFloatResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		w.WriteBulkString(kvstore.FormatFloat(res.Value))
+
+	case model.HSetResponse:
+		writeRESPInteger(w, int64(res.Added), res.Err)
+
+	case model.HGetResponse:
+		writeRESPValue(w, res.Value, res.Err)
+
+	case model.HDelResponse:
+		writeRESPInteger(w, int64(res.Deleted), res.Err)
+
+	case model.HGetAllResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		fields := make([]string, 0, len(res.Fields))
+		for field := range res.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		w.WriteArrayHeader(2 * len(fields))
+		for _, field := range fields {
+			w.WriteBulkString(field)
+			w.WriteBulkString(res.Fields[field])
+		}
+
+	case model.HIncrByResponse:
+		writeRESPInteger(w, res.Value, res.Err)
+
+	case model.HLenResponse:
+		writeRESPInteger(w, int64(res.Len), res.Err)
+
+	case model.SAddResponse:
+		writeRESPInteger(w, int64(res.Added), res.Err)
+
+	case model.SRemResponse:
+		writeRESPInteger(w, int64(res.Removed), res.Err)
+
+	case model.SMembersResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		writeRESPStrings(w, res.Members)
+
+	case model.SIsMemberResponse:
+		writeRESPBool(w, res.IsMember, res.Err)
+
+	case model.SCardResponse:
+		writeRESPInteger(w, int64(res.Len), res.Err)
+
+	case model.ZAddResponse:
+		writeRESPInteger(w, int64(res.Added), res.Err)
+
+	case model.ZIncrByResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		w.WriteBulkString(kvstore.FormatFloat(res.Score))
+
+	case model.ZRankResponse:
+		if res.Err == kvstore.ErrKeyNotFound || res.Err == kvstore.ErrMemberNotFound {
+			w.WriteNull()
+			return
+		}
+		writeRESPInteger(w, int64(res.Rank), res.Err)
+
+	case model.ZScoreResponse:
+		writeRESPValue(w, kvstore.FormatFloat(res.Score), res.Err)
+
+	case model.ZCardResponse:
+		writeRESPInteger(w, int64(res.Len), res.Err)
+
+	case model.ZRemResponse:
+		writeRESPInteger(w, int64(res.Removed), res.Err)
+
+	case model.ZRemRangeByScoreResponse:
+		writeRESPInteger(w, int64(res.Removed), res.Err)
+
+	case model.ZRangeResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		if !res.WithScores {
+			w.WriteArrayHeader(len(res.Members))
+			for _, m := range res.Members {
+				w.WriteBulkString(m.Member)
+			}
+			return
+		}
+		w.WriteArrayHeader(2 * len(res.Members))
+		for _, m := range res.Members {
+			w.WriteBulkString(m.Member)
+			w.WriteBulkString(kvstore.FormatFloat(m.Score))
+		}
+
+	case model.SetOperationResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		writeRESPStrings(w, res.Members)
+
+	case model.SetOperationStoreResponse:
+		writeRESPInteger(w, int64(res.Count), res.Err)
+
+	case model.TTLResponse:
+		switch {
+		case res.Err == kvstore.ErrKeyNotFound:
+			w.WriteInteger(-2)
+		case res.Err != nil:
+			writeRESPError(w, res.Err)
+		default:
+			w.WriteInteger(res.TTL)
+		}
+
+	case model.ExpireResponse:
+		writeRESPBool(w, res.Applied, res.Err)
+
+	case model.PersistResponse:
+		writeRESPBool(w, res.Applied, res.Err)
+
+	case model.DelResponse:
+		w.WriteInteger(int64(res.Deleted))
+
+	case model.ExistsResponse:
+		w.WriteInteger(int64(res.Count))
+
+	case model.KeysResponse:
+		writeRESPStrings(w, res.Keys)
+
+	case model.ScanResponse:
+		w.WriteArrayHeader(2)
+		w.WriteBulkString(strconv.FormatUint(res.Cursor, 10))
+		writeRESPStrings(w, res.Keys)
+
+	case model.QPushResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		w.WriteSimpleString("OK")
+
+	case model.QPopResponse:
+		writeRESPValue(w, res.Value, res.Err)
+
+	case model.BQPopResponse:
+		// The key and the value, or the null array once timed out, as
+		// BLPOP does
+		if res.Err == queue.ErrQueueEmpty {
+			w.WriteNullArray()
+			return
+		}
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		value, err := formatValue(res.Value)
+		if err != nil {
+			writeRESPError(w, err)
+			return
+		}
+		writeRESPStrings(w, []string{res.Key, value})
+
+	case model.ListPushResponse:
+		writeRESPInteger(w, int64(res.Len), res.Err)
+
+	case model.ListPopResponse:
+		writeRESPValue(w, res.Value, res.Err)
+
+	case model.LLenResponse:
+		w.WriteInteger(int64(res.Len))
+
+	case model.LIndexResponse:
+		writeRESPValue(w, res.Value, res.Err)
+
+	case model.LRangeResponse:
+		values, err := appendValues(nil, res.Values)
+		if err != nil {
+			writeRESPError(w, err)
+			return
+		}
+		writeRESPStrings(w, values)
+
+	case model.LTrimResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		w.WriteSimpleString("OK")
+
+	case model.LInsertResponse:
+		writeRESPInteger(w, int64(res.Len), res.Err)
+
+	case model.QReserveResponse:
+		// The ID, the value and the number of attempts, or the null array
+		// if the queue is empty
+		if res.Err == queue.ErrQueueEmpty {
+			w.WriteNullArray()
+			return
+		}
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		value, err := formatValue(res.Value)
+		if err != nil {
+			writeRESPError(w, err)
+			return
+		}
+		w.WriteArrayHeader(3)
+		w.WriteBulkString(res.ID)
+		w.WriteBulkString(value)
+		w.WriteInteger(int64(res.Attempts))
+
+	case model.QAckResponse:
+		writeRESPInteger(w, int64(res.Count), res.Err)
+
+	case model.QConfigResponse:
+		// Option and value pairs, as CONFIG GET does
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		writeRESPStrings(w, []string{
+			"maxattempts", strconv.Itoa(res.MaxAttempts),
+			"deadletter", res.DeadLetter,
+			"maxlen", strconv.Itoa(res.MaxLen),
+			"overflow", res.Overflow,
+			"blocktimeout", strconv.FormatFloat(res.BlockTimeout.Seconds(), 'f', -1, 64),
+		})
+
+	case model.QDLListResponse:
+		// One array of field and value pairs per message
+		messages := make([][]string, 0, len(res.Messages))
+		for _, d := range res.Messages {
+			fields, err := deadLetterFields(d)
+			if err != nil {
+				writeRESPError(w, err)
+				return
+			}
+			messages = append(messages, fields)
+		}
+		w.WriteArrayHeader(len(messages))
+		for _, fields := range messages {
+			writeRESPStrings(w, fields)
+		}
+
+	case model.QDLGetResponse:
+		// Field and value pairs, or the null array if there is no such
+		// message
+		if res.Err == queue.ErrMessageNotFound {
+			w.WriteNullArray()
+			return
+		}
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		fields, err := deadLetterFields(res.Message)
+		if err != nil {
+			writeRESPError(w, err)
+			return
+		}
+		writeRESPStrings(w, fields)
+
+	case model.QDLRequeueResponse:
+		writeRESPInteger(w, int64(res.Count), res.Err)
+
+	case model.QPeekResponse:
+		writeRESPValue(w, res.Value, res.Err)
+
+	case model.QPromoteResponse:
+		writeRESPInteger(w, int64(res.Count), res.Err)
+
+	case model.QPopNResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		values, err := appendValues(nil, res.Values)
+		if err != nil {
+			writeRESPError(w, err)
+			return
+		}
+		writeRESPStrings(w, values)
+
+	case model.QMoveResponse:
+		writeRESPValue(w, res.Value, res.Err)
+
+	case model.QStatsResponse:
+		// Field and value pairs, the age in milliseconds
+		writeRESPStrings(w, []string{
+			"len", strconv.Itoa(res.Len),
+			"enqueued", strconv.FormatUint(res.Enqueued, 10),
+			"dequeued", strconv.FormatUint(res.Dequeued, 10),
+			"oldest-age-ms", strconv.FormatInt(res.OldestAge.Milliseconds(), 10),
+			"waiters", strconv.Itoa(res.Waiters),
+			"rejected", strconv.FormatUint(res.Rejected, 10),
+			"dropped", strconv.FormatUint(res.Dropped, 10),
+		})
+
+	case model.SaveResponse:
+		if res.Err != nil {
+			writeRESPError(w, res.Err)
+			return
+		}
+		w.WriteSimpleString("OK")
+
+	case model.LastSaveResponse:
+		if res.Time.IsZero() {
+			w.WriteInteger(0)
+			return
+		}
+		w.WriteInteger(res.Time.Unix())
+
+	default:
+		w.WriteError(fmt.Sprintf("ERR unexpected response of type %T", response))
+	}
+}
+
+func writeRESPStrings(w *resp.Writer, values []string) {
+	w.WriteArrayHeader(len(values))
+	for _, v := range values {
+		w.WriteBulkString(v)
+	}
+}
+
+// deadLetterFields returns the fields of d and their values, times being
+// in Unix milliseconds
+func deadLetterFields(d model.DeadLetter) ([]string, error) {
+	value, err := formatValue(d.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		"id", d.ID,
+		"value", value,
+		"queue", d.Queue,
+		"attempts", strconv.Itoa(d.Attempts),
+		"reason", d.Reason,
+		"enqueued", strconv.FormatInt(d.EnqueuedAt.UnixMilli(), 10),
+		"dead", strconv.FormatInt(d.DeadAt.UnixMilli(), 10),
+	}, nil
+}
+
+func writeRESPInteger(w *resp.Writer, n int64, err error) {
+	if err != nil {
+		writeRESPError(w, err)
+		return
+	}
+	w.WriteInteger(n)
+}
+
+// writeRESPBool writes b as the integer 1 or 0
+func writeRESPBool(w *resp.Writer, b bool, err error) {
+	switch {
+	case err != nil:
+		writeRESPError(w, err)
+	case b:
+		w.WriteInteger(1)
+	default:
+		w.WriteInteger(0)
+	}
+}
+
+// writeRESPValue writes a single value, with missing keys and empty queues
+// answered by the null bulk string as Redis does.
+func writeRESPValue(w *resp.Writer, value interface{}, err error) {
+	switch {
+	case err == kvstore.ErrKeyNotFound, err == kvstore.ErrFieldNotFound, err == kvstore.ErrMemberNotFound,
+		err == queue.ErrQueueEmpty, err == queue.ErrIndexOutOfRange:
+		w.WriteNull()
+		return
+	case err != nil:
+		writeRESPError(w, err)
+		return
+	}
+
+	if value == nil {
+		w.WriteNull()
+		return
+	}
+
+	s, err := formatValue(value)
+	if err != nil {
+		writeRESPError(w, err)
+		return
+	}
+	w.WriteBulkString(s)
+}
+
+// formatValue turns a stored value into a string. Values pushed over HTTP
+// may be any JSON value, those are written in their JSON form.
+func formatValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func writeRESPError(w *resp.Writer, err error) {
+	if err == kvstore.ErrWrongType {
+		w.WriteError("WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	if err == queue.ErrQueueFull {
+		w.WriteError("QUEUEFULL " + err.Error())
+		return
+	}
+	w.WriteError("ERR " + err.Error())
+}
@@ -0,0 +1,281 @@
+package kvstore
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startRESPServer(t *testing.T) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := NewRESPServer(NewService(kvstore.NewShardedKVStore(0), queue.NewQueue()))
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return conn
+}
+
+func TestRESPPipeline(t *testing.T) {
+	conn := startRESPServer(t)
+
+	// Two multibulk commands and three inline ones sent in a single write
+	_, err := io.WriteString(conn, "*4\r\n$5\r\nQPUSH\r\n$1\r\nq\r\n$3\r\na b\r\n$1\r\nc\r\n"+
+		"*2\r\n$4\r\nQPOP\r\n$1\r\nq\r\n"+
+		"PING\r\nGET\r\nFOO bar\r\nBQPOP q\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(bufio.NewReader(conn))
+	require.NoError(t, err)
+
+	assert.Equal(t, "+OK\r\n$3\r\na b\r\n+PONG\r\n"+
+		"-ERR wrong number of arguments for 'get' command\r\n"+
+		"-ERR unknown command 'FOO'\r\n"+
+		"-ERR wrong number of arguments for 'bqpop' command\r\n"+
+		"+OK\r\n", string(replies))
+}
+
+func TestRESPSetConditions(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "SET k v\r\nSET k w NX\r\nGET k\r\nSET other v XX\r\nSET k w XX\r\nGET k\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "+OK\r\n$-1\r\n$1\r\nv\r\n$-1\r\n+OK\r\n$1\r\nw\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPHash(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "HSET h b 2 a 1\r\nHGETALL h\r\nHGET h c\r\nGET h\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, ":2\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n$-1\r\n"+
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPSet(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "SADD a 1 2 3\r\nSADD b 2 3 4\r\nSINTER a b\r\nSUNIONSTORE c a b\r\n"+
+		"SISMEMBER c 4\r\nSDIFF a b\r\nSCARD missing\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, ":3\r\n:3\r\n*2\r\n$1\r\n2\r\n$1\r\n3\r\n:4\r\n:1\r\n*1\r\n$1\r\n1\r\n:0\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPSortedSet(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "ZADD lb 10 ada 20 bob 15 cy\r\nZINCRBY lb 7.5 ada\r\nZREVRANK lb ada\r\n"+
+		"ZRANGE lb +inf (15 BYSCORE REV WITHSCORES\r\nZRANK lb dan\r\nZRANGE lb 0 -1 LIMIT 0 1\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, ":3\r\n$4\r\n17.5\r\n:1\r\n*4\r\n$3\r\nbob\r\n$2\r\n20\r\n$3\r\nada\r\n$4\r\n17.5\r\n$-1\r\n"+
+		"-ERR LIMIT is only supported with SCORE or LEX ranges\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPList(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "RPUSH l b c\r\nLPUSH l a\r\nLINSERT l AFTER b x\r\nLRANGE l 0 -1\r\n"+
+		"LTRIM l 1 -1\r\nRPOP l\r\nLINDEX l 5\r\nLLEN l\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, ":2\r\n:3\r\n:4\r\n*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nx\r\n$1\r\nc\r\n"+
+		"+OK\r\n$1\r\nc\r\n$-1\r\n:2\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPBlockingPop(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "RPUSH low a\r\nBQPOP high low 0\r\nBQPOP high low 0.01\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, ":1\r\n*2\r\n$3\r\nlow\r\n$1\r\na\r\n*-1\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPBlockingPopDisconnect(t *testing.T) {
+	conn := startRESPServer(t)
+
+	// A client that leaves while blocked does not take the next value
+	_, err := io.WriteString(conn, "BQPOP q 0\r\n")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	time.Sleep(50 * time.Millisecond)
+
+	other, err := net.Dial("tcp", conn.RemoteAddr().String())
+	require.NoError(t, err)
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(other, "RPUSH q precious\r\nQPOP q\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(other)
+	require.NoError(t, err)
+	assert.Equal(t, ":1\r\n$8\r\nprecious\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPScheduledPush(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "QPUSHIN q 0.05 later\r\nQPUSHAT q 1000 now\r\nQPOP q\r\nQPOP q\r\n"+
+		"BQPOP q 5\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "+OK\r\n+OK\r\n$3\r\nnow\r\n$-1\r\n*2\r\n$1\r\nq\r\n$5\r\nlater\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPPriorityQueue(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "RPUSH q low\r\nQPUSHPRI q 5 high\r\nLRANGE q 0 -1\r\nRPOP q\r\nQPOP q\r\n"+
+		"QUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, ":1\r\n+OK\r\n*2\r\n$4\r\nhigh\r\n$3\r\nlow\r\n"+
+		"-ERR operation not supported by priority queues\r\n$4\r\nhigh\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPQueueIntrospection(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "QPEEK q\r\nRPUSH q a b\r\nQPOP q\r\nQPEEK q\r\nQLEN q\r\nQRANGE q 0 -1\r\n"+
+		"QPOP q\r\nQSTATS q\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "$-1\r\n:2\r\n$1\r\na\r\n$1\r\nb\r\n:1\r\n*1\r\n$1\r\nb\r\n$1\r\nb\r\n"+
+		"*14\r\n$3\r\nlen\r\n$1\r\n0\r\n$8\r\nenqueued\r\n$1\r\n0\r\n$8\r\ndequeued\r\n$1\r\n0\r\n"+
+		"$13\r\noldest-age-ms\r\n$1\r\n0\r\n$7\r\nwaiters\r\n$1\r\n0\r\n"+
+		"$8\r\nrejected\r\n$1\r\n0\r\n$7\r\ndropped\r\n$1\r\n0\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPBatchPopAndMove(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "RPUSH src a b c\r\nQPOPN src 2\r\nQMOVE src dst\r\nQMOVE src dst\r\n"+
+		"BQMOVE src dst 0.01\r\nQPOPN dst 5\r\nQPOPN dst 5\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, ":3\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$-1\r\n$-1\r\n*1\r\n$1\r\nc\r\n*0\r\n+OK\r\n",
+		string(replies))
+}
+
+func TestRESPQueueCapacity(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "QCONFIG q MAXLEN 2\r\nRPUSH q a b\r\nLPUSH q c\r\n"+
+		"QCONFIG q OVERFLOW drop-oldest\r\nRPUSH q c\r\nLRANGE q 0 -1\r\n"+
+		"QCONFIG q OVERFLOW block BLOCKTIMEOUT 0.01\r\nRPUSH q d\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "*10\r\n$11\r\nmaxattempts\r\n$1\r\n0\r\n$10\r\ndeadletter\r\n$0\r\n\r\n"+
+		"$6\r\nmaxlen\r\n$1\r\n2\r\n$8\r\noverflow\r\n$6\r\nreject\r\n$12\r\nblocktimeout\r\n$1\r\n0\r\n"+
+		":2\r\n-QUEUEFULL queue is full\r\n"+
+		"*10\r\n$11\r\nmaxattempts\r\n$1\r\n0\r\n$10\r\ndeadletter\r\n$0\r\n\r\n"+
+		"$6\r\nmaxlen\r\n$1\r\n2\r\n$8\r\noverflow\r\n$11\r\ndrop-oldest\r\n$12\r\nblocktimeout\r\n$1\r\n0\r\n"+
+		":2\r\n*2\r\n$1\r\nb\r\n$1\r\nc\r\n"+
+		"*10\r\n$11\r\nmaxattempts\r\n$1\r\n0\r\n$10\r\ndeadletter\r\n$0\r\n\r\n"+
+		"$6\r\nmaxlen\r\n$1\r\n2\r\n$8\r\noverflow\r\n$5\r\nblock\r\n$12\r\nblocktimeout\r\n$4\r\n0.01\r\n"+
+		"-QUEUEFULL queue is full\r\n+OK\r\n", string(replies))
+}
+
+func TestRESPReliableQueue(t *testing.T) {
+	conn := startRESPServer(t)
+	r := bufio.NewReader(conn)
+
+	_, err := io.WriteString(conn, "RPUSH jobs a\r\nQRESERVE jobs 30\r\n")
+	require.NoError(t, err)
+
+	// The ID is only known once reserved
+	var id string
+	for _, want := range []string{":1", "*3", "$", "", "$1", "a", ":1"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\r\n")
+		if want == "" {
+			id = line
+			continue
+		}
+		assert.True(t, strings.HasPrefix(line, want), line)
+	}
+	assert.Regexp(t, `^\d+-0$`, id)
+
+	_, err = io.WriteString(conn, "QNACK jobs "+id+"\r\nQRESERVE jobs 30\r\nQACK jobs "+id+" "+id+"\r\n"+
+		"QRESERVE jobs 30\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, ":1\r\n*3\r\n$"+strconv.Itoa(len(id))+"\r\n"+id+"\r\n$1\r\na\r\n:2\r\n:1\r\n*-1\r\n+OK\r\n", string(replies))
+}
+
+// A lease that already ended when taken makes the message dead at the next
+// reservation, as it only had one attempt
+func TestRESPDeadLetter(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "QCONFIG jobs MAXATTEMPTS 1 DEADLETTER dead\r\nRPUSH jobs a\r\n"+
+		"QRESERVE jobs 30 PXAT 1000000\r\nQRESERVE jobs 30\r\nQDLLIST dead\r\nQDLREQUEUE dead\r\n"+
+		"QDLGET dead jobs/970000-0\r\nQDLPURGE dead\r\nLLEN jobs\r\nQUIT\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Regexp(t, `^\*10\r\n\$11\r\nmaxattempts\r\n\$1\r\n1\r\n\$10\r\ndeadletter\r\n\$4\r\ndead\r\n`+
+		`\$6\r\nmaxlen\r\n\$1\r\n0\r\n\$8\r\noverflow\r\n\$6\r\nreject\r\n\$12\r\nblocktimeout\r\n\$1\r\n0\r\n`+
+		`:1\r\n\*3\r\n\$8\r\n970000-0\r\n\$1\r\na\r\n:1\r\n\*-1\r\n`+
+		`\*1\r\n\*14\r\n\$2\r\nid\r\n\$13\r\njobs/970000-0\r\n\$5\r\nvalue\r\n\$1\r\na\r\n`+
+		`\$5\r\nqueue\r\n\$4\r\njobs\r\n\$8\r\nattempts\r\n\$1\r\n1\r\n\$6\r\nreason\r\n\$13\r\nlease expired\r\n`+
+		`\$8\r\nenqueued\r\n\$13\r\n\d{13}\r\n\$4\r\ndead\r\n\$13\r\n\d{13}\r\n`+
+		`:1\r\n\*-1\r\n:0\r\n:1\r\n\+OK\r\n$`, string(replies))
+}
+
+func TestRESPProtocolError(t *testing.T) {
+	conn := startRESPServer(t)
+
+	_, err := io.WriteString(conn, "*1\r\n+PING\r\n")
+	require.NoError(t, err)
+
+	replies, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(replies), "-ERR protocol error"), string(replies))
+}
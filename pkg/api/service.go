@@ -1,41 +1,124 @@
 package kvstore
 
 import (
-	"encoding/binary"
+	"context"
 	"sync"
 	"time"
 
 	"github.com/sprectza/go-kvstore/internal/kvstore"
 	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/sprectza/go-kvstore/pkg/model"
 )
 
 type Service interface {
-	Set(key, value string, expiresAt time.Time, condition string)
+	Set(key, value string, expiresAt time.Time, condition string, keepTTL bool) (bool, error)
 	Get(key string) (string, error)
-	QPush(key string, values ...interface{}) error
+	IncrBy(key string, delta int64, expiresAt time.Time) (int64, error)
+	IncrByFloat(key string, delta float64, expiresAt time.Time) (float64, error)
+	HSet(key string, fields map[string]string) (int, error)
+	HGet(key, field string) (string, error)
+	HDel(key string, fields ...string) (int, error)
+	HGetAll(key string) (map[string]string, error)
+	HIncrBy(key, field string, delta int64) (int64, error)
+	HLen(key string) (int, error)
+	SAdd(key string, members ...string) (int, error)
+	SRem(key string, members ...string) (int, error)
+	SMembers(key string) ([]string, error)
+	SIsMember(key, member string) (bool, error)
+	SCard(key string) (int, error)
+	ZAdd(key string, scores map[string]float64, condition, comparison string) (int, error)
+	ZIncrBy(key string, delta float64, member string) (float64, error)
+	ZRem(key string, members ...string) (int, error)
+	ZRemRangeByScore(key, min, max string) (int, error)
+	ZScore(key, member string) (float64, error)
+	ZRank(key, member string, rev bool) (int, error)
+	ZCard(key string) (int, error)
+	ZRange(key string, r kvstore.ZRange) ([]kvstore.ZMember, error)
+	SetOperation(op string, keys ...string) ([]string, error)
+	SetOperationStore(op, dest string, keys ...string) (int, error)
+	TTL(key string) (time.Duration, error)
+	Expire(key string, expiresAt time.Time, condition string) (bool, error)
+	Persist(key string) (bool, error)
+	Del(keys ...string) int
+	Exists(keys ...string) int
+	Keys(pattern string) []string
+	Scan(cursor uint64, match string, count int) (uint64, []string)
+	QPush(ctx context.Context, key string, values ...interface{}) error
+	QPushAt(key string, at time.Time, values ...interface{}) error
+	QPushPriority(key string, priority int64, values ...interface{}) error
 	QPop(key string) (interface{}, error)
-	BQPop(key string, timeout time.Duration) (interface{}, error)
-	FetchErrorsForSet() []error
+	BQPop(ctx context.Context, keys []string, timeout time.Duration) (string, interface{}, error)
+	LPush(key string, values ...interface{}) (int, error)
+	RPush(key string, values ...interface{}) (int, error)
+	LPop(key string) (interface{}, error)
+	RPop(key string) (interface{}, error)
+	LLen(key string) int
+	LIndex(key string, index int) (interface{}, error)
+	LRange(key string, start, stop int) []interface{}
+	LTrim(key string, start, stop int) error
+	LInsert(key string, before bool, pivot, value interface{}) (int, error)
+	QReserve(key string, visibility time.Duration, deadline time.Time) (queue.Message, error)
+	QAck(key string, ids ...string) (int, error)
+	QNack(key, reason string, at time.Time, ids ...string) (int, error)
+	QConfig(key string, update queue.ConfigUpdate) (queue.Config, error)
+	QDLList(dlq string, start, stop int) []queue.DeadLetter
+	QDLGet(dlq, id string) (queue.DeadLetter, error)
+	QDLRequeue(dlq string, ids ...string) (int, error)
+	QDLPurge(dlq string, ids ...string) (int, error)
+	QPeek(key string) (interface{}, error)
+	QStats(key string) queue.Stats
+	QPopN(key string, count int) ([]interface{}, error)
+	QPromote(key string, count int, until time.Time) (int, error)
+	QMove(src, dst string, fromTail, toHead bool) (interface{}, error)
+	BQMove(ctx context.Context, src, dst string, fromTail, toHead bool, timeout time.Duration) (interface{}, error)
+	QWaitRoom(ctx context.Context, key string, n int) error
+	QWaitValue(ctx context.Context, keys []string, timeout time.Duration) error
+	QFlush()
+	ExpiresAt(key string) (time.Time, bool)
+	Save() error
+	BGSave() error
+	LastSave() time.Time
+	Sync() error
+	Durable() bool
 }
 
 type service struct {
-	kvs               *kvstore.KVStore
+	store             *kvstore.ShardedKVStore
 	qs                *queue.Queue
-	bufferedSetChan   chan *SetRequest
 	bufferedQPushChan chan *QPushRequest
-	onceSet           sync.Once
 	onceQPush         sync.Once
-	errorList         []error
-	errorListMutex    sync.Mutex
-	shards            []*kvstore.KVStore
+
+	expirer         *kvstore.Expirer
+	expireHz        int
+	expireCPUBudget float64
+
+	snapshotDir  string
+	snapshotKeep int
+	saveMutex    sync.Mutex
+	saving       bool
+	lastSave     time.Time
 }
 
-type SetRequest struct {
-	Key       string
-	Value     string
-	ExpiresAt time.Time
-	Condition string
-	ErrChan   chan error
+// Option configures optional features of the service
+type Option func(*service)
+
+// WithActiveExpiry runs hz active expiry cycles per second, using at most
+// the cpuBudget fraction of a CPU. Without it 10 cycles per second run with a
+// budget of 0.25.
+func WithActiveExpiry(hz int, cpuBudget float64) Option {
+	return func(s *service) {
+		s.expireHz = hz
+		s.expireCPUBudget = cpuBudget
+	}
+}
+
+// WithSnapshots lets SAVE and BGSAVE write snapshots to dir, keeping the
+// keep most recent ones (all of them if keep is 0)
+func WithSnapshots(dir string, keep int) Option {
+	return func(s *service) {
+		s.snapshotDir = dir
+		s.snapshotKeep = keep
+	}
 }
 
 type QPushRequest struct {
@@ -44,38 +127,31 @@ type QPushRequest struct {
 	ErrChan chan error
 }
 
-const numShards = 128
+const defaultScanCount = 10
 
-func NewService(kvs *kvstore.KVStore, qs *queue.Queue) Service {
+// Longest a push waits for room in the push buffer before failing with
+// model.ErrOverloaded
+const maxPushWait = time.Second
+
+func NewService(store *kvstore.ShardedKVStore, qs *queue.Queue, opts ...Option) Service {
 	s := &service{
-		kvs:               kvs,
+		store:             store,
 		qs:                qs,
-		bufferedSetChan:   make(chan *SetRequest, 1000),
 		bufferedQPushChan: make(chan *QPushRequest, 512),
-		shards:            make([]*kvstore.KVStore, numShards),
 	}
 
-	for i := range s.shards {
-		s.shards[i] = kvstore.NewKVStore()
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	s.onceSet.Do(s.spawnSetWorkers)
+	s.expirer = kvstore.NewExpirer(s.store.Shards(), s.expireHz, s.expireCPUBudget, observeExpireCycle)
+	s.expirer.Start()
+
 	s.onceQPush.Do(s.spawnQPushWorkers)
 
 	return s
 }
 
-func (s *service) spawnSetWorkers() {
-	for i := 0; i < 256; i++ {
-		go func() {
-			for req := range s.bufferedSetChan {
-				err := s.kvs.Set(req.Key, req.Value, req.ExpiresAt, req.Condition)
-				req.ErrChan <- err
-			}
-		}()
-	}
-}
-
 func (s *service) spawnQPushWorkers() {
 	for i := 0; i < 256; i++ {
 		go func() {
@@ -89,108 +165,369 @@ func (s *service) spawnQPushWorkers() {
 
 // const maxRetries = 3
 
-func (s *service) Set(key string, value string, expiresAt time.Time, condition string) {
-	go func() {
-		shardIdx := shardIndex(key)
-		shard := s.shards[shardIdx]
-
-		err := shard.Set(key, value, expiresAt, condition)
-
-		if err != nil {
-			s.errorListMutex.Lock()
-			s.errorList = append(s.errorList, err)
-			s.errorListMutex.Unlock()
-		}
-	}()
+// Set stores value under key and reports whether it was stored, which only
+// fails to happen under the NX and XX conditions
+func (s *service) Set(key string, value string, expiresAt time.Time, condition string, keepTTL bool) (bool, error) {
+	return s.store.Set(key, value, expiresAt, condition, keepTTL)
 }
 
 func (s *service) Get(key string) (string, error) {
-	value, err := s.kvs.Get(key)
+	value, err := s.store.Get(key)
 	if err != nil {
 		return "", err
 	}
 	return value.(string), nil
 }
 
-func (s *service) QPush(key string, values ...interface{}) error {
+// IncrBy adds delta to the counter under key, creating it with expiresAt if
+// it does not exist
+func (s *service) IncrBy(key string, delta int64, expiresAt time.Time) (int64, error) {
+	return s.store.IncrBy(key, delta, expiresAt)
+}
+
+func (s *service) IncrByFloat(key string, delta float64, expiresAt time.Time) (float64, error) {
+	return s.store.IncrByFloat(key, delta, expiresAt)
+}
+
+// HSet sets fields of the hash under key and returns how many were added
+func (s *service) HSet(key string, fields map[string]string) (int, error) {
+	return s.store.HSet(key, fields)
+}
+
+func (s *service) HGet(key, field string) (string, error) {
+	return s.store.HGet(key, field)
+}
+
+// HDel removes fields of the hash under key and returns how many existed
+func (s *service) HDel(key string, fields ...string) (int, error) {
+	return s.store.HDel(key, fields...)
+}
+
+func (s *service) HGetAll(key string) (map[string]string, error) {
+	return s.store.HGetAll(key)
+}
+
+func (s *service) HIncrBy(key, field string, delta int64) (int64, error) {
+	return s.store.HIncrBy(key, field, delta)
+}
+
+func (s *service) HLen(key string) (int, error) {
+	return s.store.HLen(key)
+}
+
+// SAdd adds members to the set under key and returns how many were added
+func (s *service) SAdd(key string, members ...string) (int, error) {
+	return s.store.SAdd(key, members...)
+}
+
+// SRem removes members of the set under key and returns how many existed
+func (s *service) SRem(key string, members ...string) (int, error) {
+	return s.store.SRem(key, members...)
+}
+
+func (s *service) SMembers(key string) ([]string, error) {
+	return s.store.SMembers(key)
+}
+
+func (s *service) SIsMember(key, member string) (bool, error) {
+	return s.store.SIsMember(key, member)
+}
+
+func (s *service) SCard(key string) (int, error) {
+	return s.store.SCard(key)
+}
+
+// SetOperation returns the union, intersection or difference of the sets
+// under keys
+func (s *service) SetOperation(op string, keys ...string) ([]string, error) {
+	return s.store.SetOperation(op, keys...)
+}
+
+// SetOperationStore stores the result of SetOperation under dest and
+// returns its size
+func (s *service) SetOperationStore(op, dest string, keys ...string) (int, error) {
+	return s.store.SetOperationStore(op, dest, keys...)
+}
+
+// ZAdd sets the scores of members of the sorted set under key and returns
+// how many were added
+func (s *service) ZAdd(key string, scores map[string]float64, condition, comparison string) (int, error) {
+	return s.store.ZAdd(key, scores, condition, comparison)
+}
+
+func (s *service) ZIncrBy(key string, delta float64, member string) (float64, error) {
+	return s.store.ZIncrBy(key, delta, member)
+}
+
+// ZRem removes members of the sorted set under key and returns how many
+// existed
+func (s *service) ZRem(key string, members ...string) (int, error) {
+	return s.store.ZRem(key, members...)
+}
+
+func (s *service) ZRemRangeByScore(key, min, max string) (int, error) {
+	return s.store.ZRemRangeByScore(key, min, max)
+}
+
+func (s *service) ZScore(key, member string) (float64, error) {
+	return s.store.ZScore(key, member)
+}
+
+func (s *service) ZRank(key, member string, rev bool) (int, error) {
+	return s.store.ZRank(key, member, rev)
+}
+
+func (s *service) ZCard(key string) (int, error) {
+	return s.store.ZCard(key)
+}
+
+func (s *service) ZRange(key string, r kvstore.ZRange) ([]kvstore.ZMember, error) {
+	return s.store.ZRange(key, r)
+}
+
+// TTL returns how long key has left to live, or kvstore.NoExpiry
+func (s *service) TTL(key string) (time.Duration, error) {
+	return s.store.TTL(key)
+}
+
+func (s *service) Expire(key string, expiresAt time.Time, condition string) (bool, error) {
+	return s.store.Expire(key, expiresAt, condition)
+}
+
+func (s *service) Persist(key string) (bool, error) {
+	return s.store.Persist(key)
+}
+
+// Del deletes keys and returns how many existed
+func (s *service) Del(keys ...string) int {
+	return s.store.Delete(keys...)
+}
+
+// Exists returns how many of keys exist, counting repeated keys every time
+func (s *service) Exists(keys ...string) int {
+	return s.store.Exists(keys...)
+}
+
+// Keys returns every key matching the glob pattern
+func (s *service) Keys(pattern string) []string {
+	return s.store.Keys(pattern)
+}
+
+// Scan returns keys matching the glob pattern match, starting at cursor and
+// looking at about count keys, along with the cursor of the next call
+func (s *service) Scan(cursor uint64, match string, count int) (uint64, []string) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	return s.store.Scan(cursor, count, match)
+}
+
+// QPush hands values to the push workers and returns once they are pushed.
+// It waits for room in their buffer until ctx is done, and fails with
+// model.ErrOverloaded if there is none within maxPushWait.
+func (s *service) QPush(ctx context.Context, key string, values ...interface{}) error {
 	errChan := make(chan error, 1)
-	s.bufferedQPushChan <- &QPushRequest{Key: key, Values: values, ErrChan: errChan}
+	timer := time.NewTimer(maxPushWait)
+	defer timer.Stop()
+	select {
+	case s.bufferedQPushChan <- &QPushRequest{Key: key, Values: values, ErrChan: errChan}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		// Every worker is busy and the buffer stayed full
+		return model.ErrOverloaded
+	}
 
 	return <-errChan
 }
 
+// QPushAt schedules values to be pushed to the queue under key at at, so
+// that pops only see them from then on
+func (s *service) QPushAt(key string, at time.Time, values ...interface{}) error {
+	return s.qs.Schedule(key, at, values...)
+}
+
+// QPushPriority pushes values with the given priority to the queue under
+// key, which is popped from highest priority first
+func (s *service) QPushPriority(key string, priority int64, values ...interface{}) error {
+	_, err := s.qs.PushPriority(key, priority, values...)
+	return err
+}
+
 func (s *service) QPop(key string) (interface{}, error) {
 	return s.qs.Pop(key)
 }
 
-func (s *service) BQPop(key string, timeout time.Duration) (interface{}, error) {
-	return s.qs.BPop(key, timeout)
+// BQPop pops from the first queue of keys that is not empty and returns the
+// key with the value. It waits until timeout for a value, forever if timeout
+// is 0, and gives up early once ctx is done.
+func (s *service) BQPop(ctx context.Context, keys []string, timeout time.Duration) (string, interface{}, error) {
+	return s.qs.BPop(ctx, keys, timeout)
+}
+
+// LPush inserts values at the head of the list under key and returns its
+// new length
+func (s *service) LPush(key string, values ...interface{}) (int, error) {
+	return s.qs.LPush(key, values...)
 }
 
-func (s *service) FetchErrorsForSet() []error {
-	s.errorListMutex.Lock()
-	defer s.errorListMutex.Unlock()
+// RPush appends values to the list under key and returns its new length.
+// Unlike QPush it applies the values before returning.
+func (s *service) RPush(key string, values ...interface{}) (int, error) {
+	return s.qs.RPush(key, values...)
+}
 
-	errors := make([]error, len(s.errorList))
-	copy(errors, s.errorList)
-	s.errorList = s.errorList[:0]
+func (s *service) LPop(key string) (interface{}, error) {
+	return s.qs.LPop(key)
+}
 
-	return errors
+func (s *service) RPop(key string) (interface{}, error) {
+	return s.qs.RPop(key)
 }
 
-func shardIndex(key string) int {
-	return int(murmur3_32([]byte(key), 2)) % numShards
+func (s *service) LLen(key string) int {
+	return s.qs.LLen(key)
 }
 
-// Murmer3_32 implementation
-func murmur3_32(data []byte, seed uint32) uint32 {
-	const (
-		c1 uint32 = 0xcc9e2d51
-		c2 uint32 = 0x1b873593
-		r1 uint32 = 15
-		r2 uint32 = 13
-		m  uint32 = 5
-		n  uint32 = 0xe6546b64
-	)
+func (s *service) LIndex(key string, index int) (interface{}, error) {
+	return s.qs.LIndex(key, index)
+}
 
-	var h uint32 = seed
+func (s *service) LRange(key string, start, stop int) []interface{} {
+	return s.qs.LRange(key, start, stop)
+}
 
-	nblocks := len(data) / 4
-	for i := 0; i < nblocks; i++ {
-		k := binary.LittleEndian.Uint32(data[i*4:])
+// LTrim keeps only the elements of the list under key from start to stop
+func (s *service) LTrim(key string, start, stop int) error {
+	return s.qs.LTrim(key, start, stop)
+}
 
-		k *= c1
-		k = (k << r1) | (k >> (32 - r1))
-		k *= c2
+func (s *service) LInsert(key string, before bool, pivot, value interface{}) (int, error) {
+	return s.qs.LInsert(key, before, pivot, value)
+}
 
-		h ^= k
-		h = (h << r2) | (h >> (32 - r2))
-		h = h*m + n
+// QReserve leases the next message of the queue under key until deadline,
+// or for visibility if deadline is zero. The lease is taken as made at
+// deadline minus visibility, which is all the append-only file records, so
+// the time is truncated to the millisecond as the deadline is logged.
+func (s *service) QReserve(key string, visibility time.Duration, deadline time.Time) (queue.Message, error) {
+	if deadline.IsZero() {
+		deadline = time.Now().Truncate(time.Millisecond).Add(visibility)
 	}
-	tail := data[nblocks*4:]
-	k1 := uint32(0)
-
-	switch len(tail) {
-	case 3:
-		k1 ^= uint32(tail[2]) << 16
-		fallthrough
-	case 2:
-		k1 ^= uint32(tail[1]) << 8
-		fallthrough
-	case 1:
-		k1 ^= uint32(tail[0])
-		k1 *= c1
-		k1 = (k1 << r1) | (k1 >> (32 - r1))
-		k1 *= c2
-		h ^= k1
+
+	return s.qs.Reserve(key, deadline.Add(-visibility), visibility)
+}
+
+// QAck deletes the messages reserved from the queue under key with the
+// given IDs and returns how many there were
+func (s *service) QAck(key string, ids ...string) (int, error) {
+	return s.qs.Ack(key, ids...), nil
+}
+
+// QNack releases the messages reserved from the queue under key with the
+// given IDs to be delivered again, or moves those out of attempts to the
+// dead-letter queue with reason, and returns how many there were. They are
+// taken as failed at at, or now if it is zero.
+func (s *service) QNack(key, reason string, at time.Time, ids ...string) (int, error) {
+	if at.IsZero() {
+		at = time.Now()
 	}
 
-	h ^= uint32(len(data))
-	h ^= h >> 16
-	h *= 0x85ebca6b
-	h ^= h >> 13
-	h *= 0xc2b2ae35
-	h ^= h >> 16
+	return s.qs.Nack(key, reason, at, ids...), nil
+}
+
+// QConfig applies update to the settings of the queue under key and returns
+// them, so an empty update only reads them
+func (s *service) QConfig(key string, update queue.ConfigUpdate) (queue.Config, error) {
+	return s.qs.Configure(key, update)
+}
+
+func (s *service) QDLList(dlq string, start, stop int) []queue.DeadLetter {
+	return s.qs.DeadLetters(dlq, start, stop)
+}
+
+func (s *service) QDLGet(dlq, id string) (queue.DeadLetter, error) {
+	return s.qs.DeadLetter(dlq, id)
+}
+
+// QDLRequeue moves the messages of the dead-letter queue dlq with the given
+// IDs, or all of them, back to their queue and returns how many there were
+func (s *service) QDLRequeue(dlq string, ids ...string) (int, error) {
+	return s.qs.Requeue(dlq, ids...), nil
+}
+
+// QDLPurge deletes the messages of the dead-letter queue dlq with the given
+// IDs, or all of them, and returns how many there were
+func (s *service) QDLPurge(dlq string, ids ...string) (int, error) {
+	return s.qs.Purge(dlq, ids...), nil
+}
+
+// QPeek returns the value at the head of the queue under key without
+// popping it
+func (s *service) QPeek(key string) (interface{}, error) {
+	return s.qs.Peek(key)
+}
+
+func (s *service) QStats(key string) queue.Stats {
+	return s.qs.Stats(key)
+}
+
+// QPopN pops up to count values from the head of the queue under key
+func (s *service) QPopN(key string, count int) ([]interface{}, error) {
+	return s.qs.PopN(key, count), nil
+}
+
+// QPromote pushes the first count values scheduled for key, or all of them
+// if count is 0, leaving those due after until unless it is zero
+func (s *service) QPromote(key string, count int, until time.Time) (int, error) {
+	return s.qs.Promote(key, count, until), nil
+}
+
+// QMove pops a value from the queue under src and pushes it to the queue
+// under dst in one step, so that it is never in neither
+func (s *service) QMove(src, dst string, fromTail, toHead bool) (interface{}, error) {
+	return s.qs.Move(src, dst, fromTail, toHead)
+}
+
+// BQMove is QMove, waiting as BQPop does if src is empty
+func (s *service) BQMove(ctx context.Context, src, dst string, fromTail, toHead bool, timeout time.Duration) (interface{}, error) {
+	return s.qs.BMove(ctx, src, dst, fromTail, toHead, timeout)
+}
+
+// QWaitRoom waits for room for n more values in the queue under key if it
+// is full and its overflow policy is to block, for a push that failed with
+// queue.ErrQueueFull to be tried again
+func (s *service) QWaitRoom(ctx context.Context, key string, n int) error {
+	return s.qs.WaitRoom(ctx, key, n)
+}
+
+// QWaitValue waits as BQPop does for a value in one of the queues under
+// keys, leaving it to be popped
+func (s *service) QWaitValue(ctx context.Context, keys []string, timeout time.Duration) error {
+	return s.qs.WaitValue(ctx, keys, timeout)
+}
+
+// QFlush returns once the values of the QPush calls that returned before it
+// are in their queue, which QPush only hands over to be pushed shortly after
+func (s *service) QFlush() {
+	s.qs.Flush()
+}
+
+// ExpiresAt returns the expiry of key, zero if it has none, even if it
+// passed already, and whether the key is stored
+func (s *service) ExpiresAt(key string) (time.Time, bool) {
+	return s.store.ExpiresAt(key)
+}
+
+// Sync fails with model.ErrAOFDisabled, on its own the service keeps nothing
+// on disk
+func (s *service) Sync() error {
+	return model.ErrAOFDisabled
+}
 
-	return h
+// Durable reports whether writes can be synced to disk, which takes the
+// append-only file
+func (s *service) Durable() bool {
+	return false
 }
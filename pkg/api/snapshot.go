@@ -0,0 +1,126 @@
+package kvstore
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/sprectza/go-kvstore/internal/snapshot"
+	"github.com/sprectza/go-kvstore/pkg/model"
+)
+
+// Save writes a snapshot and returns once it is on disk
+func (s *service) Save() error {
+	snap, err := s.beginSave()
+	if err != nil {
+		return err
+	}
+
+	_, err = snapshot.Save(s.snapshotDir, snap, s.snapshotKeep)
+	s.endSave(snap, err)
+
+	return err
+}
+
+// BGSave copies the data set and writes it to disk in the background
+func (s *service) BGSave() error {
+	snap, err := s.beginSave()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		path, err := snapshot.Save(s.snapshotDir, snap, s.snapshotKeep)
+		s.endSave(snap, err)
+		if err != nil {
+			log.Printf("Background save failed: %v", err)
+			return
+		}
+		log.Printf("Background save written to %s", path)
+	}()
+
+	return nil
+}
+
+func (s *service) LastSave() time.Time {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	return s.lastSave
+}
+
+// beginSave takes a copy of the data set. Shards are copied one at a time,
+// so requests to a shard only wait while that shard is being copied.
+func (s *service) beginSave() (*snapshot.Snapshot, error) {
+	if s.snapshotDir == "" {
+		return nil, model.ErrSnapshotsDisabled
+	}
+
+	s.saveMutex.Lock()
+	if s.saving {
+		s.saveMutex.Unlock()
+		return nil, model.ErrSaveInProgress
+	}
+	s.saving = true
+	s.saveMutex.Unlock()
+
+	snap := &snapshot.Snapshot{
+		CreatedAt: time.Now(),
+		Keys:      make(map[string]kvstore.KeyValue),
+	}
+	s.store.Dump(snap.Keys)
+	queues := s.qs.Dump()
+	snap.Queues = queues.Queues
+	snap.Priorities = queues.Priorities
+	snap.QueueConfigs = queues.Configs
+	snap.DeadLetters = queues.DeadLetters
+	snap.Delayed = queues.Delayed
+
+	return snap, nil
+}
+
+func (s *service) endSave(snap *snapshot.Snapshot, err error) {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	s.saving = false
+	if err == nil {
+		s.lastSave = snap.CreatedAt
+	}
+}
+
+// LoadSnapshot loads the most recent valid snapshot in dir into s, which
+// must have been created by NewService and not be serving requests yet. It
+// returns the path of the loaded snapshot, or an empty path if there was
+// none.
+func LoadSnapshot(dir string, s Service) (string, error) {
+	svc, ok := s.(*service)
+	if !ok {
+		return "", errors.New("snapshots can only be loaded into the service returned by NewService")
+	}
+
+	snap, path, err := snapshot.LoadLatest(dir)
+	if err == snapshot.ErrNoSnapshot {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	svc.store.Load(snap.Keys)
+	svc.qs.Load(queue.State{
+		Queues:      snap.Queues,
+		Priorities:  snap.Priorities,
+		Configs:     snap.QueueConfigs,
+		DeadLetters: snap.DeadLetters,
+		Delayed:     snap.Delayed,
+	})
+
+	svc.saveMutex.Lock()
+	svc.lastSave = snap.CreatedAt
+	svc.saveMutex.Unlock()
+
+	return path, nil
+}
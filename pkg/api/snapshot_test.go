@@ -0,0 +1,130 @@
+package kvstore
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/sprectza/go-kvstore/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadedService(t *testing.T, dir string) Service {
+	s := NewService(kvstore.NewShardedKVStore(0), queue.NewQueue())
+	path, err := LoadSnapshot(dir, s)
+	require.NoError(t, err)
+	require.NotEmpty(t, path)
+
+	return s
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := NewService(kvstore.NewShardedKVStore(0), queue.NewQueue(), WithSnapshots(dir, 0))
+	// Without a monotonic reading, which snapshots do not keep
+	now := time.Now().Round(0)
+	later := now.Add(time.Hour)
+	maxAttempts, dlq := 1, "jobs:dead"
+
+	_, err := s.Set("a", "1", later, "", false)
+	require.NoError(t, err)
+	_, err = s.IncrByFloat("f", 2.5, time.Time{})
+	require.NoError(t, err)
+	_, err = s.HSet("h", map[string]string{"a": "1", "b": "2"})
+	require.NoError(t, err)
+	_, err = s.SAdd("s", "a", "b")
+	require.NoError(t, err)
+	_, err = s.ZAdd("z", map[string]float64{"a": 1, "b": 2}, "", "")
+	require.NoError(t, err)
+
+	_, err = s.RPush("q", "a", "b")
+	require.NoError(t, err)
+	require.NoError(t, s.QPushAt("q", later, "later"))
+	require.NoError(t, s.QPushPriority("pri", 2, "high"))
+	require.NoError(t, s.QPushPriority("pri", 1, "low"))
+	_, err = s.QConfig("jobs", queue.ConfigUpdate{MaxAttempts: &maxAttempts, DeadLetter: &dlq})
+	require.NoError(t, err)
+	_, err = s.RPush("jobs", "a", "b", "c")
+	require.NoError(t, err)
+	m, err := s.QReserve("jobs", time.Minute, time.Time{})
+	require.NoError(t, err)
+	_, err = s.QNack("jobs", "failed", now, m.ID)
+	require.NoError(t, err)
+	_, err = s.QReserve("jobs", time.Minute, time.Time{})
+	require.NoError(t, err)
+	require.NoError(t, s.QPush(ctx, "pushed", "x"))
+	s.QFlush()
+
+	require.NoError(t, s.Save())
+	assert.False(t, s.LastSave().IsZero())
+
+	loaded := loadedService(t, dir)
+	keys, queues := stateOf(s)
+	loadedKeys, loadedQueues := stateOf(loaded)
+	assert.Equal(t, keys, loadedKeys)
+	assert.Equal(t, queues, loadedQueues)
+	assert.True(t, s.LastSave().Equal(loaded.LastSave()))
+
+	// The reserved message is delivered again
+	assert.Equal(t, []interface{}{"b", "c"}, loaded.LRange("jobs", 0, -1))
+}
+
+func TestSnapshotDisabled(t *testing.T) {
+	s := NewService(kvstore.NewShardedKVStore(0), queue.NewQueue())
+	assert.Equal(t, model.ErrSnapshotsDisabled, s.Save())
+	assert.Equal(t, model.ErrSnapshotsDisabled, s.BGSave())
+}
+
+func TestBGSaveDuringWrites(t *testing.T) {
+	dir := t.TempDir()
+	s := NewService(kvstore.NewShardedKVStore(0), queue.NewQueue(), WithSnapshots(dir, 0))
+
+	// A writer pushes 0, 1, 2... to a queue and sets a key for each
+	var written int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_, err := s.RPush("q", strconv.Itoa(i))
+			assert.NoError(t, err)
+			_, err = s.Set("k"+strconv.Itoa(i), strconv.Itoa(i), time.Time{}, "", false)
+			assert.NoError(t, err)
+			atomic.StoreInt64(&written, int64(i+1))
+		}
+	}()
+
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&written) >= 100 }, 5*time.Second, time.Millisecond)
+	before := int(atomic.LoadInt64(&written))
+	require.NoError(t, s.BGSave())
+	require.Eventually(t, func() bool { return !s.LastSave().IsZero() }, 5*time.Second, time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	// The snapshot has every write made before it started, and the queue as
+	// it was at one point
+	loaded := loadedService(t, dir)
+	values := loaded.LRange("q", 0, -1)
+	require.GreaterOrEqual(t, len(values), before)
+	for i, value := range values {
+		assert.Equal(t, strconv.Itoa(i), value)
+	}
+	for i := 0; i < before; i++ {
+		value, err := loaded.Get("k" + strconv.Itoa(i))
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), value)
+	}
+}
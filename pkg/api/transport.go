@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
 	"time"
 
@@ -13,15 +16,78 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
 	"github.com/sprectza/go-kvstore/pkg/model"
 )
 
 type Endpoints struct {
-	SetEndpoint   endpoint.Endpoint
-	GetEndpoint   endpoint.Endpoint
-	QPushEndpoint endpoint.Endpoint
-	QPopEndpoint  endpoint.Endpoint
-	BQPopEndpoint endpoint.Endpoint
+	SetEndpoint      endpoint.Endpoint
+	GetEndpoint      endpoint.Endpoint
+	QPushEndpoint    endpoint.Endpoint
+	QPopEndpoint     endpoint.Endpoint
+	BQPopEndpoint    endpoint.Endpoint
+	ListPushEndpoint endpoint.Endpoint
+	ListPopEndpoint  endpoint.Endpoint
+	LLenEndpoint     endpoint.Endpoint
+	LIndexEndpoint   endpoint.Endpoint
+	LRangeEndpoint   endpoint.Endpoint
+	LTrimEndpoint    endpoint.Endpoint
+	LInsertEndpoint  endpoint.Endpoint
+	QReserveEndpoint endpoint.Endpoint
+	QAckEndpoint     endpoint.Endpoint
+	QConfigEndpoint  endpoint.Endpoint
+
+	QDLListEndpoint    endpoint.Endpoint
+	QDLGetEndpoint     endpoint.Endpoint
+	QDLRequeueEndpoint endpoint.Endpoint
+	QPeekEndpoint      endpoint.Endpoint
+	QStatsEndpoint     endpoint.Endpoint
+	QPopNEndpoint      endpoint.Endpoint
+	QPromoteEndpoint   endpoint.Endpoint
+	QMoveEndpoint      endpoint.Endpoint
+
+	IncrEndpoint        endpoint.Endpoint
+	IncrByFloatEndpoint endpoint.Endpoint
+
+	TTLEndpoint     endpoint.Endpoint
+	ExpireEndpoint  endpoint.Endpoint
+	PersistEndpoint endpoint.Endpoint
+
+	HSetEndpoint    endpoint.Endpoint
+	HGetEndpoint    endpoint.Endpoint
+	HDelEndpoint    endpoint.Endpoint
+	HGetAllEndpoint endpoint.Endpoint
+	HIncrByEndpoint endpoint.Endpoint
+	HLenEndpoint    endpoint.Endpoint
+
+	SAddEndpoint              endpoint.Endpoint
+	SRemEndpoint              endpoint.Endpoint
+	SMembersEndpoint          endpoint.Endpoint
+	SIsMemberEndpoint         endpoint.Endpoint
+	SCardEndpoint             endpoint.Endpoint
+	ZAddEndpoint              endpoint.Endpoint
+	ZIncrByEndpoint           endpoint.Endpoint
+	ZRankEndpoint             endpoint.Endpoint
+	ZScoreEndpoint            endpoint.Endpoint
+	ZCardEndpoint             endpoint.Endpoint
+	ZRemEndpoint              endpoint.Endpoint
+	ZRemRangeByScoreEndpoint  endpoint.Endpoint
+	ZRangeEndpoint            endpoint.Endpoint
+	SetOperationEndpoint      endpoint.Endpoint
+	SetOperationStoreEndpoint endpoint.Endpoint
+
+	DelEndpoint    endpoint.Endpoint
+	ExistsEndpoint endpoint.Endpoint
+	KeysEndpoint   endpoint.Endpoint
+	ScanEndpoint   endpoint.Endpoint
+
+	SaveEndpoint     endpoint.Endpoint
+	LastSaveEndpoint endpoint.Endpoint
+
+	// CommandEndpoint serves parsed text commands by dispatching them to
+	// the endpoints above
+	CommandEndpoint endpoint.Endpoint
 }
 
 /* var (
@@ -42,13 +108,73 @@ func init() {
 
 // Create endpoints for each service
 func MakeEndpoints(s Service) Endpoints {
-	return Endpoints{
-		SetEndpoint:   makeSetEndpoint(s),
-		GetEndpoint:   makeGetEndpoint(s),
-		QPushEndpoint: makeQPushEndpoint(s),
-		QPopEndpoint:  makeQPopEndpoint(s),
-		BQPopEndpoint: makeBQPopEndpoint(s),
+	e := Endpoints{
+		SetEndpoint:      makeSetEndpoint(s),
+		GetEndpoint:      makeGetEndpoint(s),
+		QPushEndpoint:    makeQPushEndpoint(s),
+		QPopEndpoint:     makeQPopEndpoint(s),
+		BQPopEndpoint:    makeBQPopEndpoint(s),
+		ListPushEndpoint: makeListPushEndpoint(s),
+		ListPopEndpoint:  makeListPopEndpoint(s),
+		LLenEndpoint:     makeLLenEndpoint(s),
+		LIndexEndpoint:   makeLIndexEndpoint(s),
+		LRangeEndpoint:   makeLRangeEndpoint(s),
+		LTrimEndpoint:    makeLTrimEndpoint(s),
+		LInsertEndpoint:  makeLInsertEndpoint(s),
+		QReserveEndpoint: makeQReserveEndpoint(s),
+		QAckEndpoint:     makeQAckEndpoint(s),
+		QConfigEndpoint:  makeQConfigEndpoint(s),
+
+		QDLListEndpoint:    makeQDLListEndpoint(s),
+		QDLGetEndpoint:     makeQDLGetEndpoint(s),
+		QDLRequeueEndpoint: makeQDLRequeueEndpoint(s),
+		QPeekEndpoint:      makeQPeekEndpoint(s),
+		QStatsEndpoint:     makeQStatsEndpoint(s),
+		QPopNEndpoint:      makeQPopNEndpoint(s),
+		QPromoteEndpoint:   makeQPromoteEndpoint(s),
+		QMoveEndpoint:      makeQMoveEndpoint(s),
+
+		IncrEndpoint:        makeIncrEndpoint(s),
+		IncrByFloatEndpoint: makeIncrByFloatEndpoint(s),
+
+		TTLEndpoint:     makeTTLEndpoint(s),
+		ExpireEndpoint:  makeExpireEndpoint(s),
+		PersistEndpoint: makePersistEndpoint(s),
+
+		HSetEndpoint:    makeHSetEndpoint(s),
+		HGetEndpoint:    makeHGetEndpoint(s),
+		HDelEndpoint:    makeHDelEndpoint(s),
+		HGetAllEndpoint: makeHGetAllEndpoint(s),
+		HIncrByEndpoint: makeHIncrByEndpoint(s),
+		HLenEndpoint:    makeHLenEndpoint(s),
+
+		SAddEndpoint:              makeSAddEndpoint(s),
+		SRemEndpoint:              makeSRemEndpoint(s),
+		SMembersEndpoint:          makeSMembersEndpoint(s),
+		SIsMemberEndpoint:         makeSIsMemberEndpoint(s),
+		SCardEndpoint:             makeSCardEndpoint(s),
+		ZAddEndpoint:              makeZAddEndpoint(s),
+		ZIncrByEndpoint:           makeZIncrByEndpoint(s),
+		ZRankEndpoint:             makeZRankEndpoint(s),
+		ZScoreEndpoint:            makeZScoreEndpoint(s),
+		ZCardEndpoint:             makeZCardEndpoint(s),
+		ZRemEndpoint:              makeZRemEndpoint(s),
+		ZRemRangeByScoreEndpoint:  makeZRemRangeByScoreEndpoint(s),
+		ZRangeEndpoint:            makeZRangeEndpoint(s),
+		SetOperationEndpoint:      makeSetOperationEndpoint(s),
+		SetOperationStoreEndpoint: makeSetOperationStoreEndpoint(s),
+
+		DelEndpoint:    makeDelEndpoint(s),
+		ExistsEndpoint: makeExistsEndpoint(s),
+		KeysEndpoint:   makeKeysEndpoint(s),
+		ScanEndpoint:   makeScanEndpoint(s),
+
+		SaveEndpoint:     makeSaveEndpoint(s),
+		LastSaveEndpoint: makeLastSaveEndpoint(s),
 	}
+	e.CommandEndpoint = makeCommandEndpoint(e)
+
+	return e
 }
 
 // Prometheus middleware
@@ -80,10 +206,29 @@ func makeSetEndpoint(s Service) endpoint.Endpoint {
 		req := request.(model.SetRequest)
 		value, ok := req.Value.(string)
 		if !ok {
-			return model.SetResponse{Err: fmt.Errorf("invalid value type")}, nil
+			return model.SetResponse{Err: model.ErrInvalidValue}, nil
 		}
-		s.Set(req.Key, value, req.ExpiresAt, req.Condition)
-		return model.SetResponse{Err: nil}, nil
+
+		// Fail before storing a value that cannot be made durable
+		if req.Ack == model.AckPersisted && !s.Durable() {
+			return model.SetResponse{Err: model.ErrAOFDisabled}, nil
+		}
+
+		if req.Ack == model.AckNone {
+			go func() {
+				if _, err := s.Set(req.Key, value, req.ExpiresAt, req.Condition, req.KeepTTL); err != nil {
+					log.Printf("SET %s: %v", req.Key, err)
+				}
+			}()
+			return model.SetResponse{}, nil
+		}
+
+		applied, err := s.Set(req.Key, value, req.ExpiresAt, req.Condition, req.KeepTTL)
+		if err == nil && req.Ack == model.AckPersisted {
+			// The value is in memory even if syncing fails
+			err = s.Sync()
+		}
+		return model.SetResponse{Applied: applied, Err: err}, nil
 	}
 }
 
@@ -96,246 +241,2251 @@ func makeGetEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
-// QPUSH endpoint
-func makeQPushEndpoint(s Service) endpoint.Endpoint {
+// INCR, DECR, INCRBY and DECRBY endpoint
+func makeIncrEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(model.QPushRequest)
-		s.QPush(req.Key, req.Values...)
-		return model.QPushResponse{}, nil
+		req := request.(model.IncrRequest)
+		n, err := s.IncrBy(req.Key, req.Delta, req.ExpiresAt)
+		return model.IncrResponse{Value: n, Err: err}, nil
 	}
 }
 
-// QPOP endpoint
-func makeQPopEndpoint(s Service) endpoint.Endpoint {
+// INCRBYFLOAT endpoint
+func makeIncrByFloatEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(model.QPopRequest)
-		value, err := s.QPop(req.Key)
-		return model.QPopResponse{Value: value, Err: err}, nil
+		req := request.(model.IncrByFloatRequest)
+		f, err := s.IncrByFloat(req.Key, req.Delta, req.ExpiresAt)
+		return model.IncrByFloatResponse{Value: f, Err: err}, nil
 	}
 }
 
-// BQPOP endpoint
-func makeBQPopEndpoint(s Service) endpoint.Endpoint {
+// TTL and PTTL endpoint
+func makeTTLEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(model.BQPopRequest)
-		value, err := s.BQPop(req.Key, req.Timeout)
-		return model.BQPopResponse{Value: value, Err: err}, nil
+		req := request.(model.TTLRequest)
+		ttl, err := s.TTL(req.Key)
+		switch {
+		case err != nil:
+			return model.TTLResponse{Err: err}, nil
+		case ttl == kvstore.NoExpiry:
+			return model.TTLResponse{TTL: -1}, nil
+		case req.Milliseconds:
+			return model.TTLResponse{TTL: ttl.Milliseconds()}, nil
+		default:
+			// Round to the closest second, like Redis does
+			return model.TTLResponse{TTL: int64((ttl + 500*time.Millisecond) / time.Second)}, nil
+		}
 	}
 }
 
-// Spawn a new HTTP handler
-func MakeHTTPHandler(endpoints Endpoints) http.Handler {
-	r := mux.NewRouter()
-
-	r.Handle("/metrics", promhttp.Handler())
-
-	statusCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_status_codes",
-		Help: "HTTP status codes.",
-	}, []string{"method", "status"})
-
-	options := []httptransport.ServerOption{
-		// httptransport.ServerErrorEncoder(errorEncoderWrapper),
-		httptransport.ServerFinalizer(serverFinalizer(statusCounter)),
+// EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT endpoint
+func makeExpireEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ExpireRequest)
+		expiresAt := req.ExpiresAt
+		if req.TTL != 0 {
+			expiresAt = time.Now().Add(req.TTL)
+		}
+		applied, err := s.Expire(req.Key, expiresAt, req.Condition)
+		return model.ExpireResponse{Applied: applied, Err: err}, nil
 	}
+}
 
-	// def SET
-	r.Methods("POST").Path("/api/commands/set").Handler(httptransport.NewServer(
-		endpoints.SetEndpoint,
-		decodeSetRequest,
-		encodeResponse,
-		options...,
-	))
-
-	// def GET
-	r.Methods("POST").Path("/api/commands/get").Handler(httptransport.NewServer(
-		endpoints.GetEndpoint,
-		decodeGetRequest,
-		encodeResponse,
-		options...,
-	))
-
-	// def QPUSH
-	r.Methods("POST").Path("/api/commands/qpush").Handler(httptransport.NewServer(
-		endpoints.QPushEndpoint,
-		decodeQPushRequest,
-		encodeResponse,
-		options...,
-	))
+// PERSIST endpoint
+func makePersistEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.PersistRequest)
+		applied, err := s.Persist(req.Key)
+		return model.PersistResponse{Applied: applied, Err: err}, nil
+	}
+}
 
-	// def QPOP
-	r.Methods("POST").Path("/api/commands/qpop").Handler(httptransport.NewServer(
-		endpoints.QPopEndpoint,
-		decodeQPopRequest,
-		encodeResponse,
-		options...,
-	))
+// HSET endpoint
+func makeHSetEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.HSetRequest)
+		added, err := s.HSet(req.Key, req.Fields)
+		return model.HSetResponse{Added: added, Err: err}, nil
+	}
+}
 
-	// def BQPOP
-	r.Methods("POST").Path("/api/commands/bqpop").Handler(httptransport.NewServer(
-		endpoints.BQPopEndpoint,
-		decodeBQPopRequest,
-		encodeResponse,
-		options...,
-	))
+// HGET endpoint
+func makeHGetEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.HGetRequest)
+		value, err := s.HGet(req.Key, req.Field)
+		return model.HGetResponse{Value: value, Err: err}, nil
+	}
+}
 
-	return r
+// HDEL endpoint
+func makeHDelEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.HDelRequest)
+		deleted, err := s.HDel(req.Key, req.Fields...)
+		return model.HDelResponse{Deleted: deleted, Err: err}, nil
+	}
 }
 
-func serverFinalizer(statusCounter *prometheus.CounterVec) httptransport.ServerFinalizerFunc {
-	return func(ctx context.Context, code int, r *http.Request) {
-		method := r.Method
-		status := fmt.Sprint(code)
-		statusCounter.WithLabelValues(method, status).Inc()
+// HGETALL endpoint
+func makeHGetAllEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.HGetAllRequest)
+		fields, err := s.HGetAll(req.Key)
+		return model.HGetAllResponse{Fields: fields, Err: err}, nil
+	}
+}
 
-		// fmt.Printf("ServerFinalizer executed, status code: %d", code)
+// HINCRBY endpoint
+func makeHIncrByEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.HIncrByRequest)
+		n, err := s.HIncrBy(req.Key, req.Field, req.Delta)
+		return model.HIncrByResponse{Value: n, Err: err}, nil
 	}
 }
 
-func decodeSetRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var req model.SetRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, err
+// HLEN endpoint
+func makeHLenEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.HLenRequest)
+		n, err := s.HLen(req.Key)
+		return model.HLenResponse{Len: n, Err: err}, nil
 	}
-	if err := validateSetRequest(&req); err != nil {
-		return nil, err
+}
+
+// SADD endpoint
+func makeSAddEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.SAddRequest)
+		added, err := s.SAdd(req.Key, req.Members...)
+		return model.SAddResponse{Added: added, Err: err}, nil
 	}
+}
 
-	return req, nil
+// SREM endpoint
+func makeSRemEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.SRemRequest)
+		removed, err := s.SRem(req.Key, req.Members...)
+		return model.SRemResponse{Removed: removed, Err: err}, nil
+	}
 }
 
-func decodeGetRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var req model.GetRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, err
+// SMEMBERS endpoint
+func makeSMembersEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.SMembersRequest)
+		members, err := s.SMembers(req.Key)
+		return model.SMembersResponse{Members: members, Err: err}, nil
 	}
-	if err := validateGetRequest(&req); err != nil {
-		return nil, err
+}
+
+// SISMEMBER endpoint
+func makeSIsMemberEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.SIsMemberRequest)
+		isMember, err := s.SIsMember(req.Key, req.Member)
+		return model.SIsMemberResponse{IsMember: isMember, Err: err}, nil
 	}
-	return req, nil
 }
 
-func decodeQPushRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var req model.QPushRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, err
+// SCARD endpoint
+func makeSCardEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.SCardRequest)
+		n, err := s.SCard(req.Key)
+		return model.SCardResponse{Len: n, Err: err}, nil
 	}
-	if err := validateQPushRequest(&req); err != nil {
-		return nil, err
+}
+
+// ZADD endpoint
+func makeZAddEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ZAddRequest)
+		added, err := s.ZAdd(req.Key, req.Members, req.Condition, req.Comparison)
+		return model.ZAddResponse{Added: added, Err: err}, nil
 	}
-	return req, nil
 }
 
-func decodeQPopRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var req model.QPopRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, err
+// ZINCRBY endpoint
+func makeZIncrByEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ZIncrByRequest)
+		score, err := s.ZIncrBy(req.Key, req.Delta, req.Member)
+		return model.ZIncrByResponse{Score: score, Err: err}, nil
 	}
+}
 
-	if err := validateQPopRequest(&req); err != nil {
-		return nil, err
+// ZRANK and ZREVRANK endpoint
+func makeZRankEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ZRankRequest)
+		rank, err := s.ZRank(req.Key, req.Member, req.Rev)
+		return model.ZRankResponse{Rank: rank, Err: err}, nil
 	}
-	return req, nil
 }
 
-func decodeBQPopRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var req model.BQPopRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, err
+// ZSCORE endpoint
+func makeZScoreEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ZScoreRequest)
+		score, err := s.ZScore(req.Key, req.Member)
+		return model.ZScoreResponse{Score: score, Err: err}, nil
 	}
-	if err := validateBQPopRequest(&req); err != nil {
-		return nil, err
+}
+
+// ZCARD endpoint
+func makeZCardEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ZCardRequest)
+		n, err := s.ZCard(req.Key)
+		return model.ZCardResponse{Len: n, Err: err}, nil
 	}
-	return req, nil
 }
 
-func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	return json.NewEncoder(w).Encode(response)
+// ZREM endpoint
+func makeZRemEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ZRemRequest)
+		removed, err := s.ZRem(req.Key, req.Members...)
+		return model.ZRemResponse{Removed: removed, Err: err}, nil
+	}
 }
 
-func validateSetRequest(req *model.SetRequest) error {
-	if req.Key == "" {
-		return errors.New("key must not be empty")
+// ZREMRANGEBYSCORE endpoint
+func makeZRemRangeByScoreEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ZRemRangeByScoreRequest)
+		removed, err := s.ZRemRangeByScore(req.Key, req.Min, req.Max)
+		return model.ZRemRangeByScoreResponse{Removed: removed, Err: err}, nil
 	}
+}
 
-	if req.Value == nil {
-		return errors.New("value must not be empty")
+// ZRANGE endpoint
+func makeZRangeEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ZRangeRequest)
+		r := kvstore.ZRange{By: req.By, Start: req.Start, Stop: req.Stop, Rev: req.Rev, Count: -1}
+		if req.Limit != nil {
+			r.Offset, r.Count = req.Limit.Offset, req.Limit.Count
+		}
+
+		members, err := s.ZRange(req.Key, r)
+		res := model.ZRangeResponse{Members: make([]model.ZMember, len(members)), WithScores: req.WithScores, Err: err}
+		for i, m := range members {
+			res.Members[i] = model.ZMember(m)
+		}
+		return res, nil
 	}
+}
 
-	if req.Condition != "" && req.Condition != "NX" && req.Condition != "XX" {
-		return errors.New("condition must be NX, XX or emtpy")
+// SINTER, SUNION and SDIFF endpoint
+func makeSetOperationEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.SetOperationRequest)
+		members, err := s.SetOperation(req.Op, req.Keys...)
+		return model.SetOperationResponse{Members: members, Err: err}, nil
 	}
+}
 
-	return nil
+// SINTERSTORE, SUNIONSTORE and SDIFFSTORE endpoint
+func makeSetOperationStoreEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.SetOperationStoreRequest)
+		n, err := s.SetOperationStore(req.Op, req.Destination, req.Keys...)
+		return model.SetOperationStoreResponse{Count: n, Err: err}, nil
+	}
 }
 
-func validateGetRequest(req *model.GetRequest) error {
-	if req.Key == "" {
-		return errors.New("key must not be empty to get value")
+// DEL endpoint
+func makeDelEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.DelRequest)
+		return model.DelResponse{Deleted: s.Del(req.Keys...)}, nil
 	}
+}
 
-	return nil
+// EXISTS endpoint
+func makeExistsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ExistsRequest)
+		return model.ExistsResponse{Count: s.Exists(req.Keys...)}, nil
+	}
 }
 
-func validateQPushRequest(req *model.QPushRequest) error {
-	if req.Key == "" {
-		return errors.New("key must not be emtpy")
+// KEYS endpoint
+func makeKeysEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.KeysRequest)
+		return model.KeysResponse{Keys: s.Keys(req.Pattern)}, nil
 	}
-	if req.Values == nil {
-		return errors.New("you must set values to be pushed")
+}
+
+// SCAN endpoint
+func makeScanEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ScanRequest)
+		cursor, keys := s.Scan(req.Cursor, req.Match, req.Count)
+		return model.ScanResponse{Cursor: cursor, Keys: keys}, nil
 	}
+}
 
-	return nil
+// QPUSH endpoint, also serving QPUSHIN and QPUSHAT
+func makeQPushEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QPushRequest)
+		switch {
+		case req.Priority != nil:
+			err := withRoom(ctx, s, req.Key, len(req.Values), func() error {
+				return s.QPushPriority(req.Key, *req.Priority, req.Values...)
+			})
+			return model.QPushResponse{Err: err}, nil
+		case !req.NotBefore.IsZero() || req.Delay > 0:
+			at := req.NotBefore
+			if at.IsZero() {
+				at = time.Now().Add(req.Delay)
+			}
+			err := withRoom(ctx, s, req.Key, len(req.Values), func() error {
+				return s.QPushAt(req.Key, at, req.Values...)
+			})
+			return model.QPushResponse{Err: err}, nil
+		}
+		err := withRoom(ctx, s, req.Key, len(req.Values), func() error {
+			return s.QPush(ctx, req.Key, req.Values...)
+		})
+		if err == nil {
+			// Reply once the values are in the queue, so that the client's
+			// next pop sees them
+			s.QFlush()
+		}
+		return model.QPushResponse{Err: err}, nil
+	}
 }
 
-func validateQPopRequest(req *model.QPopRequest) error {
-	if req.Key == "" {
-		return errors.New("key must not be empty")
+// withRoom runs push, a push of n values to the queue under key, and runs it
+// again as the queue gets room for as long as it fails with
+// queue.ErrQueueFull and the queue blocks producers. The wait is left to the
+// endpoint so that the service is never waiting for room while the append-
+// only file keeps pops to the queue from being logged.
+func withRoom(ctx context.Context, s Service, key string, n int, push func() error) error {
+	err := push()
+	for errors.Is(err, queue.ErrQueueFull) {
+		if err := s.QWaitRoom(ctx, key, n); err != nil {
+			return err
+		}
+		err = push()
 	}
 
-	return nil
+	return err
 }
 
-func validateBQPopRequest(req *model.BQPopRequest) error {
-	if req.Key == "" {
-		return errors.New("key must not be empty")
+// QPOP endpoint
+func makeQPopEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QPopRequest)
+		value, err := s.QPop(req.Key)
+		return model.QPopResponse{Value: value, Err: err}, nil
 	}
-	if req.Timeout < 0 {
-		return errors.New("timeout must not empty")
+}
+
+// BQPOP endpoint
+func makeBQPopEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.BQPopRequest)
+		key, value, err := s.BQPop(ctx, req.Keys, req.Timeout)
+		return model.BQPopResponse{Key: key, Value: value, Err: err}, nil
 	}
+}
 
-	return nil
+// LPUSH and RPUSH endpoint
+func makeListPushEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ListPushRequest)
+		push := s.RPush
+		if req.Left {
+			push = s.LPush
+		}
+		var n int
+		err := withRoom(ctx, s, req.Key, len(req.Values), func() (err error) {
+			n, err = push(req.Key, req.Values...)
+			return err
+		})
+		return model.ListPushResponse{Len: n, Err: err}, nil
+	}
 }
 
-/* func errorEncoder(_ context.Context, err error, w http.ResponseWriter) int {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// LPOP and RPOP endpoint
+func makeListPopEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.ListPopRequest)
+		pop := s.RPop
+		if req.Left {
+			pop = s.LPop
+		}
+		value, err := pop(req.Key)
+		return model.ListPopResponse{Value: value, Err: err}, nil
+	}
+}
 
-	statusCode := http.StatusInternalServerError
+// LLEN endpoint
+func makeLLenEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.LLenRequest)
+		return model.LLenResponse{Len: s.LLen(req.Key)}, nil
+	}
+}
 
-	switch err {
-	case model.ErrQueueEmpty, model.ErrKeyNotFound:
-		statusCode = http.StatusNotFound
-	case model.ErrInvalidValue, model.ErrInvalidExpiryTime, model.ErrInvalidCondition:
-		statusCode = http.StatusBadRequest
-	default:
-		statusCode = http.StatusInternalServerError
+// LINDEX endpoint
+func makeLIndexEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.LIndexRequest)
+		value, err := s.LIndex(req.Key, req.Index)
+		return model.LIndexResponse{Value: value, Err: err}, nil
 	}
-	w.WriteHeader(statusCode)
+}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
-	return statusCode
+// LRANGE endpoint
+func makeLRangeEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.LRangeRequest)
+		return model.LRangeResponse{Values: s.LRange(req.Key, req.Start, req.Stop)}, nil
+	}
 }
 
-type dummyResponseWriter struct{}
+// LTRIM endpoint
+func makeLTrimEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.LTrimRequest)
+		return model.LTrimResponse{Err: s.LTrim(req.Key, req.Start, req.Stop)}, nil
+	}
+}
 
-func (*dummyResponseWriter) Header() http.Header       { return http.Header{} }
-func (*dummyResponseWriter) Write([]byte) (int, error) { return 0, nil }
-func (*dummyResponseWriter) WriteHeader(int)           {}
+// LINSERT endpoint
+func makeLInsertEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.LInsertRequest)
+		var n int
+		err := withRoom(ctx, s, req.Key, 1, func() (err error) {
+			n, err = s.LInsert(req.Key, req.Before, req.Pivot, req.Value)
+			return err
+		})
+		return model.LInsertResponse{Len: n, Err: err}, nil
+	}
+}
+
+// QRESERVE endpoint
+func makeQReserveEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QReserveRequest)
+		m, err := s.QReserve(req.Key, req.Visibility, req.Deadline)
+		if err != nil {
+			return model.QReserveResponse{Err: err}, nil
+		}
+		return model.QReserveResponse{ID: m.ID, Value: m.Value, Attempts: m.Attempts, Deadline: m.Deadline}, nil
+	}
+}
+
+// QACK and QNACK endpoint
+func makeQAckEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QAckRequest)
+		if req.Nack {
+			n, err := s.QNack(req.Key, req.Reason, req.At, req.IDs...)
+			return model.QAckResponse{Count: n, Err: err}, nil
+		}
+		n, err := s.QAck(req.Key, req.IDs...)
+		return model.QAckResponse{Count: n, Err: err}, nil
+	}
+}
+
+// QCONFIG endpoint
+func makeQConfigEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QConfigRequest)
+		c, err := s.QConfig(req.Key, configUpdate(req))
+		return model.QConfigResponse{
+			MaxAttempts:  c.MaxAttempts,
+			DeadLetter:   c.DeadLetter,
+			MaxLen:       c.MaxLen,
+			Overflow:     c.Overflow.String(),
+			BlockTimeout: c.BlockTimeout,
+			Err:          err,
+		}, nil
+	}
+}
+
+// configUpdate returns the settings changed by req
+func configUpdate(req model.QConfigRequest) queue.ConfigUpdate {
+	u := queue.ConfigUpdate{
+		MaxAttempts:  req.MaxAttempts,
+		DeadLetter:   req.DeadLetter,
+		MaxLen:       req.MaxLen,
+		BlockTimeout: req.BlockTimeout,
+	}
+	if req.Overflow != nil {
+		overflow := queue.Overflow(*req.Overflow)
+		u.Overflow = &overflow
+	}
+
+	return u
+}
+
+// QDLLIST endpoint
+func makeQDLListEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QDLListRequest)
+		letters := s.QDLList(req.Key, req.Start, req.Stop)
+		messages := make([]model.DeadLetter, 0, len(letters))
+		for _, d := range letters {
+			messages = append(messages, model.DeadLetter(d))
+		}
+		return model.QDLListResponse{Messages: messages}, nil
+	}
+}
+
+// QDLGET endpoint
+func makeQDLGetEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QDLGetRequest)
+		d, err := s.QDLGet(req.Key, req.ID)
+		return model.QDLGetResponse{Message: model.DeadLetter(d), Err: err}, nil
+	}
+}
+
+// QDLREQUEUE and QDLPURGE endpoint
+func makeQDLRequeueEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QDLRequeueRequest)
+		requeue := s.QDLRequeue
+		if req.Purge {
+			requeue = s.QDLPurge
+		}
+		n, err := requeue(req.Key, req.IDs...)
+		return model.QDLRequeueResponse{Count: n, Err: err}, nil
+	}
+}
+
+// QPEEK endpoint
+func makeQPeekEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QPeekRequest)
+		value, err := s.QPeek(req.Key)
+		return model.QPeekResponse{Value: value, Err: err}, nil
+	}
+}
+
+// QSTATS endpoint
+func makeQStatsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QStatsRequest)
+		stats := s.QStats(req.Key)
+		res := model.QStatsResponse{
+			Len:      stats.Len,
+			Enqueued: stats.Enqueued,
+			Dequeued: stats.Dequeued,
+			Waiters:  stats.Waiters,
+			Rejected: stats.Rejected,
+			Dropped:  stats.Dropped,
+		}
+		if !stats.OldestPushedAt.IsZero() {
+			res.OldestAge = time.Since(stats.OldestPushedAt)
+		}
+		return res, nil
+	}
+}
+
+// QPOPN endpoint
+func makeQPopNEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QPopNRequest)
+		values, err := s.QPopN(req.Key, req.Count)
+		return model.QPopNResponse{Values: values, Err: err}, nil
+	}
+}
+
+// QPROMOTE endpoint
+func makeQPromoteEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QPromoteRequest)
+		n, err := s.QPromote(req.Key, req.Count, time.Time{})
+		return model.QPromoteResponse{Count: n, Err: err}, nil
+	}
+}
+
+// QMOVE and BQMOVE endpoint
+func makeQMoveEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.QMoveRequest)
+		var value interface{}
+		err := withRoom(ctx, s, req.Destination, 1, func() (err error) {
+			if req.Block {
+				value, err = s.BQMove(ctx, req.Source, req.Destination, req.FromTail, req.ToHead, req.Timeout)
+			} else {
+				value, err = s.QMove(req.Source, req.Destination, req.FromTail, req.ToHead)
+			}
+			return err
+		})
+		return model.QMoveResponse{Value: value, Err: err}, nil
+	}
+}
+
+// SAVE and BGSAVE endpoint
+func makeSaveEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(model.SaveRequest)
+		if req.Background {
+			return model.SaveResponse{Err: s.BGSave()}, nil
+		}
+		return model.SaveResponse{Err: s.Save()}, nil
+	}
+}
+
+// LASTSAVE endpoint
+func makeLastSaveEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return model.LastSaveResponse{Time: s.LastSave()}, nil
+	}
+}
+
+// COMMAND endpoint
+func makeCommandEndpoint(e Endpoints) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return e.Dispatch(ctx, request)
+	}
+}
+
+// Spawn a new HTTP handler
+func MakeHTTPHandler(endpoints Endpoints) http.Handler {
+	r := mux.NewRouter()
+
+	r.Handle("/metrics", promhttp.Handler())
+
+	statusCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_status_codes",
+		Help: "HTTP status codes.",
+	}, []string{"method", "status"})
+
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerFinalizer(serverFinalizer(statusCounter)),
+	}
+
+	// def text commands, as sent by the frontend
+	r.Methods("POST").Path("/api/commands").Handler(httptransport.NewServer(
+		endpoints.CommandEndpoint,
+		decodeCommandRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("OPTIONS").PathPrefix("/api/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// def SET
+	r.Methods("POST").Path("/api/commands/set").Handler(httptransport.NewServer(
+		endpoints.SetEndpoint,
+		decodeSetRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def GET
+	r.Methods("POST").Path("/api/commands/get").Handler(httptransport.NewServer(
+		endpoints.GetEndpoint,
+		decodeGetRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def INCR, DECR, INCRBY and DECRBY
+	r.Methods("POST").Path("/api/commands/incr").Handler(httptransport.NewServer(
+		endpoints.IncrEndpoint,
+		decodeIncrRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def INCRBYFLOAT
+	r.Methods("POST").Path("/api/commands/incrbyfloat").Handler(httptransport.NewServer(
+		endpoints.IncrByFloatEndpoint,
+		decodeIncrByFloatRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def TTL and PTTL
+	r.Methods("POST").Path("/api/commands/ttl").Handler(httptransport.NewServer(
+		endpoints.TTLEndpoint,
+		decodeTTLRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT
+	r.Methods("POST").Path("/api/commands/expire").Handler(httptransport.NewServer(
+		endpoints.ExpireEndpoint,
+		decodeExpireRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def PERSIST
+	r.Methods("POST").Path("/api/commands/persist").Handler(httptransport.NewServer(
+		endpoints.PersistEndpoint,
+		decodePersistRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def HSET
+	r.Methods("POST").Path("/api/commands/hset").Handler(httptransport.NewServer(
+		endpoints.HSetEndpoint,
+		decodeHSetRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def HGET
+	r.Methods("POST").Path("/api/commands/hget").Handler(httptransport.NewServer(
+		endpoints.HGetEndpoint,
+		decodeHGetRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def HDEL
+	r.Methods("POST").Path("/api/commands/hdel").Handler(httptransport.NewServer(
+		endpoints.HDelEndpoint,
+		decodeHDelRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def HGETALL
+	r.Methods("POST").Path("/api/commands/hgetall").Handler(httptransport.NewServer(
+		endpoints.HGetAllEndpoint,
+		decodeHGetAllRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def HINCRBY
+	r.Methods("POST").Path("/api/commands/hincrby").Handler(httptransport.NewServer(
+		endpoints.HIncrByEndpoint,
+		decodeHIncrByRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def HLEN
+	r.Methods("POST").Path("/api/commands/hlen").Handler(httptransport.NewServer(
+		endpoints.HLenEndpoint,
+		decodeHLenRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def SADD
+	r.Methods("POST").Path("/api/commands/sadd").Handler(httptransport.NewServer(
+		endpoints.SAddEndpoint,
+		decodeSAddRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def SREM
+	r.Methods("POST").Path("/api/commands/srem").Handler(httptransport.NewServer(
+		endpoints.SRemEndpoint,
+		decodeSRemRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def SMEMBERS
+	r.Methods("POST").Path("/api/commands/smembers").Handler(httptransport.NewServer(
+		endpoints.SMembersEndpoint,
+		decodeSMembersRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def SISMEMBER
+	r.Methods("POST").Path("/api/commands/sismember").Handler(httptransport.NewServer(
+		endpoints.SIsMemberEndpoint,
+		decodeSIsMemberRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def SCARD
+	r.Methods("POST").Path("/api/commands/scard").Handler(httptransport.NewServer(
+		endpoints.SCardEndpoint,
+		decodeSCardRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def ZADD
+	r.Methods("POST").Path("/api/commands/zadd").Handler(httptransport.NewServer(
+		endpoints.ZAddEndpoint,
+		decodeZAddRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def ZINCRBY
+	r.Methods("POST").Path("/api/commands/zincrby").Handler(httptransport.NewServer(
+		endpoints.ZIncrByEndpoint,
+		decodeZIncrByRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def ZRANK
+	r.Methods("POST").Path("/api/commands/zrank").Handler(httptransport.NewServer(
+		endpoints.ZRankEndpoint,
+		decodeZRankRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def ZSCORE
+	r.Methods("POST").Path("/api/commands/zscore").Handler(httptransport.NewServer(
+		endpoints.ZScoreEndpoint,
+		decodeZScoreRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def ZCARD
+	r.Methods("POST").Path("/api/commands/zcard").Handler(httptransport.NewServer(
+		endpoints.ZCardEndpoint,
+		decodeZCardRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def ZREM
+	r.Methods("POST").Path("/api/commands/zrem").Handler(httptransport.NewServer(
+		endpoints.ZRemEndpoint,
+		decodeZRemRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def ZREMRANGEBYSCORE
+	r.Methods("POST").Path("/api/commands/zremrangebyscore").Handler(httptransport.NewServer(
+		endpoints.ZRemRangeByScoreEndpoint,
+		decodeZRemRangeByScoreRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def ZRANGE
+	r.Methods("POST").Path("/api/commands/zrange").Handler(httptransport.NewServer(
+		endpoints.ZRangeEndpoint,
+		decodeZRangeRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def SINTER
+	r.Methods("POST").Path("/api/commands/sinter").Handler(httptransport.NewServer(
+		endpoints.SetOperationEndpoint,
+		decodeSetOperationRequest(kvstore.SetInter),
+		encodeResponse,
+		options...,
+	))
+
+	// def SUNION
+	r.Methods("POST").Path("/api/commands/sunion").Handler(httptransport.NewServer(
+		endpoints.SetOperationEndpoint,
+		decodeSetOperationRequest(kvstore.SetUnion),
+		encodeResponse,
+		options...,
+	))
+
+	// def SDIFF
+	r.Methods("POST").Path("/api/commands/sdiff").Handler(httptransport.NewServer(
+		endpoints.SetOperationEndpoint,
+		decodeSetOperationRequest(kvstore.SetDiff),
+		encodeResponse,
+		options...,
+	))
+
+	// def SINTERSTORE
+	r.Methods("POST").Path("/api/commands/sinterstore").Handler(httptransport.NewServer(
+		endpoints.SetOperationStoreEndpoint,
+		decodeSetOperationStoreRequest(kvstore.SetInter),
+		encodeResponse,
+		options...,
+	))
+
+	// def SUNIONSTORE
+	r.Methods("POST").Path("/api/commands/sunionstore").Handler(httptransport.NewServer(
+		endpoints.SetOperationStoreEndpoint,
+		decodeSetOperationStoreRequest(kvstore.SetUnion),
+		encodeResponse,
+		options...,
+	))
+
+	// def SDIFFSTORE
+	r.Methods("POST").Path("/api/commands/sdiffstore").Handler(httptransport.NewServer(
+		endpoints.SetOperationStoreEndpoint,
+		decodeSetOperationStoreRequest(kvstore.SetDiff),
+		encodeResponse,
+		options...,
+	))
+
+	// def DEL
+	r.Methods("POST").Path("/api/commands/del").Handler(httptransport.NewServer(
+		endpoints.DelEndpoint,
+		decodeDelRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def EXISTS
+	r.Methods("POST").Path("/api/commands/exists").Handler(httptransport.NewServer(
+		endpoints.ExistsEndpoint,
+		decodeExistsRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def KEYS
+	r.Methods("POST").Path("/api/commands/keys").Handler(httptransport.NewServer(
+		endpoints.KeysEndpoint,
+		decodeKeysRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def SCAN
+	r.Methods("POST").Path("/api/commands/scan").Handler(httptransport.NewServer(
+		endpoints.ScanEndpoint,
+		decodeScanRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QPUSH
+	r.Methods("POST").Path("/api/commands/qpush").Handler(httptransport.NewServer(
+		endpoints.QPushEndpoint,
+		decodeQPushRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QPOP
+	r.Methods("POST").Path("/api/commands/qpop").Handler(httptransport.NewServer(
+		endpoints.QPopEndpoint,
+		decodeQPopRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def BQPOP
+	r.Methods("POST").Path("/api/commands/bqpop").Handler(httptransport.NewServer(
+		endpoints.BQPopEndpoint,
+		decodeBQPopRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def LPUSH
+	r.Methods("POST").Path("/api/commands/lpush").Handler(httptransport.NewServer(
+		endpoints.ListPushEndpoint,
+		decodeListPushRequest(true),
+		encodeResponse,
+		options...,
+	))
+
+	// def RPUSH
+	r.Methods("POST").Path("/api/commands/rpush").Handler(httptransport.NewServer(
+		endpoints.ListPushEndpoint,
+		decodeListPushRequest(false),
+		encodeResponse,
+		options...,
+	))
+
+	// def LPOP
+	r.Methods("POST").Path("/api/commands/lpop").Handler(httptransport.NewServer(
+		endpoints.ListPopEndpoint,
+		decodeListPopRequest(true),
+		encodeResponse,
+		options...,
+	))
+
+	// def RPOP
+	r.Methods("POST").Path("/api/commands/rpop").Handler(httptransport.NewServer(
+		endpoints.ListPopEndpoint,
+		decodeListPopRequest(false),
+		encodeResponse,
+		options...,
+	))
+
+	// def LLEN
+	r.Methods("POST").Path("/api/commands/llen").Handler(httptransport.NewServer(
+		endpoints.LLenEndpoint,
+		decodeLLenRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def LINDEX
+	r.Methods("POST").Path("/api/commands/lindex").Handler(httptransport.NewServer(
+		endpoints.LIndexEndpoint,
+		decodeLIndexRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def LRANGE
+	r.Methods("POST").Path("/api/commands/lrange").Handler(httptransport.NewServer(
+		endpoints.LRangeEndpoint,
+		decodeLRangeRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def LTRIM
+	r.Methods("POST").Path("/api/commands/ltrim").Handler(httptransport.NewServer(
+		endpoints.LTrimEndpoint,
+		decodeLTrimRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def LINSERT
+	r.Methods("POST").Path("/api/commands/linsert").Handler(httptransport.NewServer(
+		endpoints.LInsertEndpoint,
+		decodeLInsertRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QRESERVE
+	r.Methods("POST").Path("/api/commands/qreserve").Handler(httptransport.NewServer(
+		endpoints.QReserveEndpoint,
+		decodeQReserveRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QACK
+	r.Methods("POST").Path("/api/commands/qack").Handler(httptransport.NewServer(
+		endpoints.QAckEndpoint,
+		decodeQAckRequest(false),
+		encodeResponse,
+		options...,
+	))
+
+	// def QNACK
+	r.Methods("POST").Path("/api/commands/qnack").Handler(httptransport.NewServer(
+		endpoints.QAckEndpoint,
+		decodeQAckRequest(true),
+		encodeResponse,
+		options...,
+	))
+
+	// def QCONFIG
+	r.Methods("POST").Path("/api/commands/qconfig").Handler(httptransport.NewServer(
+		endpoints.QConfigEndpoint,
+		decodeQConfigRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QDLLIST
+	r.Methods("POST").Path("/api/commands/qdllist").Handler(httptransport.NewServer(
+		endpoints.QDLListEndpoint,
+		decodeQDLListRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QDLGET
+	r.Methods("POST").Path("/api/commands/qdlget").Handler(httptransport.NewServer(
+		endpoints.QDLGetEndpoint,
+		decodeQDLGetRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QDLREQUEUE
+	r.Methods("POST").Path("/api/commands/qdlrequeue").Handler(httptransport.NewServer(
+		endpoints.QDLRequeueEndpoint,
+		decodeQDLRequeueRequest(false),
+		encodeResponse,
+		options...,
+	))
+
+	// def QDLPURGE
+	r.Methods("POST").Path("/api/commands/qdlpurge").Handler(httptransport.NewServer(
+		endpoints.QDLRequeueEndpoint,
+		decodeQDLRequeueRequest(true),
+		encodeResponse,
+		options...,
+	))
+
+	// def QLEN, the same as LLEN
+	r.Methods("POST").Path("/api/commands/qlen").Handler(httptransport.NewServer(
+		endpoints.LLenEndpoint,
+		decodeLLenRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QPEEK
+	r.Methods("POST").Path("/api/commands/qpeek").Handler(httptransport.NewServer(
+		endpoints.QPeekEndpoint,
+		decodeQPeekRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QRANGE, the same as LRANGE
+	r.Methods("POST").Path("/api/commands/qrange").Handler(httptransport.NewServer(
+		endpoints.LRangeEndpoint,
+		decodeLRangeRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QSTATS
+	r.Methods("POST").Path("/api/commands/qstats").Handler(httptransport.NewServer(
+		endpoints.QStatsEndpoint,
+		decodeQStatsRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QPOPN
+	r.Methods("POST").Path("/api/commands/qpopn").Handler(httptransport.NewServer(
+		endpoints.QPopNEndpoint,
+		decodeQPopNRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QPROMOTE
+	r.Methods("POST").Path("/api/commands/qpromote").Handler(httptransport.NewServer(
+		endpoints.QPromoteEndpoint,
+		decodeQPromoteRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def QMOVE
+	r.Methods("POST").Path("/api/commands/qmove").Handler(httptransport.NewServer(
+		endpoints.QMoveEndpoint,
+		decodeQMoveRequest(false),
+		encodeResponse,
+		options...,
+	))
+
+	// def BQMOVE
+	r.Methods("POST").Path("/api/commands/bqmove").Handler(httptransport.NewServer(
+		endpoints.QMoveEndpoint,
+		decodeQMoveRequest(true),
+		encodeResponse,
+		options...,
+	))
+
+	// def SAVE and BGSAVE
+	r.Methods("POST").Path("/api/admin/save").Handler(httptransport.NewServer(
+		endpoints.SaveEndpoint,
+		decodeSaveRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// def LASTSAVE
+	r.Methods("POST").Path("/api/admin/lastsave").Handler(httptransport.NewServer(
+		endpoints.LastSaveEndpoint,
+		decodeLastSaveRequest,
+		encodeResponse,
+		options...,
+	))
+
+	r.Use(corsMiddleware)
+
+	return r
+}
+
+// The frontend is served from a different origin, so browsers need CORS
+// headers before they let it talk to the API
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func serverFinalizer(statusCounter *prometheus.CounterVec) httptransport.ServerFinalizerFunc {
+	return func(ctx context.Context, code int, r *http.Request) {
+		method := r.Method
+		status := fmt.Sprint(code)
+		statusCounter.WithLabelValues(method, status).Inc()
+
+		// fmt.Printf("ServerFinalizer executed, status code: %d", code)
+	}
+}
+
+func decodeCommandRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.CommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	args, err := Tokenize(req.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCommand(args)
+}
+
+func decodeSetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.SetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateSetRequest(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func decodeGetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.GetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateGetRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeIncrRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.IncrRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateIncrRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeIncrByFloatRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.IncrByFloatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateIncrByFloatRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeTTLRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.TTLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateTTLRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeExpireRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ExpireRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateExpireRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodePersistRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.PersistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validatePersistRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHSetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.HSetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateHSetRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHGetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.HGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHDelRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.HDelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateHDelRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHGetAllRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.HGetAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHIncrByRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.HIncrByRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeHLenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.HLenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeSAddRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.SAddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateSetMembers(req.Key, req.Members); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeSRemRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.SRemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateSetMembers(req.Key, req.Members); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeSMembersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.SMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeSIsMemberRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.SIsMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeSCardRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.SCardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeZAddRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ZAddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateZAddRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeZIncrByRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ZIncrByRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeZRankRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ZRankRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeZScoreRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ZScoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeZCardRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ZCardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeZRemRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ZRemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateSetMembers(req.Key, req.Members); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeZRemRangeByScoreRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ZRemRangeByScoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeZRangeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ZRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateZRangeRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// The operation comes from the route rather than the body
+func decodeSetOperationRequest(op string) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		var req model.SetOperationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		req.Op = op
+		if err := validateKeys(req.Keys); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+}
+
+func decodeSetOperationStoreRequest(op string) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		var req model.SetOperationStoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		req.Op = op
+		if err := validateSetOperationStoreRequest(&req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+}
+
+func decodeDelRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.DelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys(req.Keys); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeExistsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ExistsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys(req.Keys); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeKeysRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.KeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.Pattern == "" {
+		return nil, errors.New("pattern must not be empty")
+	}
+	return req, nil
+}
+
+func decodeScanRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.ScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.Count < 0 {
+		return nil, errors.New("count must not be negative")
+	}
+	return req, nil
+}
+
+func decodeQPushRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateQPushRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeQPopRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QPopRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := validateQPopRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeBQPopRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.BQPopRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateBQPopRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// Which end of the list to push to comes from the route
+func decodeListPushRequest(left bool) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		var req model.ListPushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		req.Left = left
+		if err := validateListPushRequest(&req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+}
+
+func decodeListPopRequest(left bool) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		var req model.ListPopRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		req.Left = left
+		if err := validateKeys([]string{req.Key}); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+}
+
+func decodeLLenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.LLenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeLIndexRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.LIndexRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeLRangeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.LRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeLTrimRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.LTrimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeLInsertRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.LInsertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateLInsertRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeQReserveRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QReserveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateQReserveRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// Whether to ack or nack comes from the route
+func decodeQAckRequest(nack bool) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		var req model.QAckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		req.Nack = nack
+		if err := validateQAckRequest(&req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+}
+
+// An empty body only reads the settings
+func decodeQConfigRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateQConfigRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeQDLListRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QDLListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeQDLGetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QDLGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateQDLGetRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// Whether to requeue or purge comes from the route
+func decodeQDLRequeueRequest(purge bool) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		var req model.QDLRequeueRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		req.Purge = purge
+		if err := validateKeys([]string{req.Key}); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+}
+
+func decodeQPeekRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QPeekRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeQStatsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QStatsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateKeys([]string{req.Key}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeQPopNRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QPopNRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateQPopNRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeQPromoteRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.QPromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := validateQPromoteRequest(&req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// Whether to block comes from the route
+func decodeQMoveRequest(block bool) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		var req model.QMoveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		req.Block = block
+		if err := validateQMoveRequest(&req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+}
+
+// An empty body asks for a foreground save
+func decodeSaveRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req model.SaveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return req, nil
+}
 
-// Wrapping the error encoder, for use in makeHttp
-func errorEncoderWrapper(ctx context.Context, err error, w http.ResponseWriter) {
-	_ = errorEncoder(ctx, err, w)
-} */
+func decodeLastSaveRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return model.LastSaveRequest{}, nil
+}
+
+// Responses whose call failed are encoded as an error response instead
+func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		writeError(w, f.Failed(), http.StatusInternalServerError)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
+
+// Errors that reach the error encoder come from decoding and validating the
+// request, so unless they are known they are the client's fault
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	writeError(w, err, http.StatusBadRequest)
+}
+
+// writeError writes err as a model.ErrorResponse, with the status and code
+// of known errors and defaultStatus otherwise
+func writeError(w http.ResponseWriter, err error, defaultStatus int) {
+	status, body := defaultStatus, model.ErrorBody{Message: err.Error()}
+
+	var cmdErr *model.CommandError
+	switch {
+	case errors.As(err, &cmdErr):
+		status, body.Code = http.StatusBadRequest, cmdErr.Code
+		if cmdErr.Command != "" {
+			body.Details = map[string]interface{}{"command": cmdErr.Command}
+		}
+	case errors.As(err, new(*json.SyntaxError)), errors.As(err, new(*json.UnmarshalTypeError)):
+		status, body.Code = http.StatusBadRequest, model.CodeSyntax
+	case errors.Is(err, kvstore.ErrKeyNotFound), errors.Is(err, model.ErrKeyNotFound):
+		status, body.Code = http.StatusNotFound, model.CodeKeyNotFound
+	case errors.Is(err, queue.ErrQueueEmpty), errors.Is(err, model.ErrQueueEmpty):
+		status, body.Code = http.StatusNotFound, model.CodeQueueEmpty
+	case errors.Is(err, kvstore.ErrFieldNotFound):
+		status, body.Code = http.StatusNotFound, model.CodeFieldNotFound
+	case errors.Is(err, kvstore.ErrMemberNotFound):
+		status, body.Code = http.StatusNotFound, model.CodeMemberNotFound
+	case errors.Is(err, queue.ErrIndexOutOfRange):
+		status, body.Code = http.StatusNotFound, model.CodeIndexOutOfRange
+	case errors.Is(err, queue.ErrMessageNotFound):
+		status, body.Code = http.StatusNotFound, model.CodeMessageNotFound
+	case errors.Is(err, kvstore.ErrWrongType), errors.Is(err, queue.ErrPriorityQueue):
+		status, body.Code = http.StatusConflict, model.CodeWrongType
+	case errors.Is(err, kvstore.ErrNotInteger), errors.Is(err, kvstore.ErrNotFloat):
+		status, body.Code = http.StatusBadRequest, model.CodeNotANumber
+	case errors.Is(err, kvstore.ErrOverflow), errors.Is(err, kvstore.ErrNotFinite):
+		status, body.Code = http.StatusBadRequest, model.CodeOverflow
+	case errors.Is(err, kvstore.ErrInvalidCondition), errors.Is(err, kvstore.ErrInvalidSetOperation),
+		errors.Is(err, kvstore.ErrInvalidRange),
+		errors.Is(err, queue.ErrNoDeadLetterQueue), errors.Is(err, queue.ErrOwnDeadLetterQueue),
+		errors.Is(err, queue.ErrNegativeMaxAttempts), errors.Is(err, queue.ErrNegativeMaxLen),
+		errors.Is(err, queue.ErrUnknownOverflow), errors.Is(err, queue.ErrNegativeTimeout),
+		errors.Is(err, model.ErrInvalidCondition),
+		errors.Is(err, model.ErrInvalidValue), errors.Is(err, model.ErrInvalidExpiryTime):
+		status, body.Code = http.StatusBadRequest, model.CodeInvalidRequest
+	case errors.Is(err, model.ErrSnapshotsDisabled), errors.Is(err, model.ErrAOFDisabled):
+		status, body.Code = http.StatusConflict, model.CodeNotEnabled
+	case errors.Is(err, model.ErrSaveInProgress):
+		status, body.Code = http.StatusConflict, model.CodeSaveInProgress
+	case errors.Is(err, queue.ErrQueueFull):
+		status, body.Code = http.StatusTooManyRequests, model.CodeQueueFull
+	case errors.Is(err, model.ErrOverloaded):
+		status, body.Code = http.StatusServiceUnavailable, model.CodeOverloaded
+		w.Header().Set("Retry-After", "1")
+	case defaultStatus == http.StatusBadRequest:
+		body.Code = model.CodeInvalidRequest
+	default:
+		body.Code = model.CodeInternal
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(model.ErrorResponse{Error: body})
+}
+
+func validateSetRequest(req *model.SetRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+
+	if req.Value == nil {
+		return errors.New("value must not be empty")
+	}
+
+	if req.Condition != "" && req.Condition != "NX" && req.Condition != "XX" {
+		return errors.New("condition must be NX, XX or empty")
+	}
+
+	if req.KeepTTL && !req.ExpiresAt.IsZero() {
+		return errors.New("KeepTTL cannot be combined with ExpiresAt")
+	}
+
+	switch req.Ack {
+	case "", model.AckNone, model.AckApplied, model.AckPersisted:
+	default:
+		return errors.New("ack must be none, applied, persisted or empty")
+	}
+
+	return nil
+}
+
+func validateHSetRequest(req *model.HSetRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if len(req.Fields) == 0 {
+		return errors.New("at least one field must be given")
+	}
+
+	return nil
+}
+
+func validateHDelRequest(req *model.HDelRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if len(req.Fields) == 0 {
+		return errors.New("at least one field must be given")
+	}
+
+	return nil
+}
+
+func validateSetMembers(key string, members []string) error {
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+	if len(members) == 0 {
+		return errors.New("at least one member must be given")
+	}
+
+	return nil
+}
+
+func validateSetOperationStoreRequest(req *model.SetOperationStoreRequest) error {
+	if req.Destination == "" {
+		return errors.New("destination must not be empty")
+	}
+
+	return validateKeys(req.Keys)
+}
+
+func validateZAddRequest(req *model.ZAddRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if len(req.Members) == 0 {
+		return errors.New("at least one member must be given")
+	}
+	if req.Condition != "" && req.Condition != "NX" && req.Condition != "XX" {
+		return errors.New("condition must be NX, XX or empty")
+	}
+	if req.Comparison != "" && req.Comparison != "GT" && req.Comparison != "LT" {
+		return errors.New("comparison must be GT, LT or empty")
+	}
+	if req.Condition == "NX" && req.Comparison != "" {
+		return errors.New("GT, LT and NX options at the same time are not compatible")
+	}
+
+	return nil
+}
+
+func validateZRangeRequest(req *model.ZRangeRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+
+	switch req.By {
+	case kvstore.ZByRank, kvstore.ZByScore, kvstore.ZByLex:
+	default:
+		return errors.New("by must be SCORE, LEX or empty")
+	}
+
+	if req.Limit != nil {
+		if req.By == kvstore.ZByRank {
+			return errors.New("LIMIT is only supported with SCORE or LEX ranges")
+		}
+		if req.Limit.Offset < 0 {
+			return errors.New("offset must not be negative")
+		}
+	}
+
+	return nil
+}
+
+func validateListPushRequest(req *model.ListPushRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if len(req.Values) == 0 {
+		return errors.New("at least one value must be given")
+	}
+
+	return nil
+}
+
+func validateLInsertRequest(req *model.LInsertRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if req.Pivot == nil || req.Value == nil {
+		return errors.New("pivot and value must not be empty")
+	}
+
+	return nil
+}
+
+func validateQReserveRequest(req *model.QReserveRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if req.Visibility < time.Millisecond {
+		return errors.New("visibility timeout must be at least one millisecond")
+	}
+
+	return nil
+}
+
+func validateQAckRequest(req *model.QAckRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if len(req.IDs) == 0 {
+		return errors.New("at least one message ID must be given")
+	}
+
+	return nil
+}
+
+func validateQConfigRequest(req *model.QConfigRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if req.MaxAttempts != nil && *req.MaxAttempts < 0 {
+		return queue.ErrNegativeMaxAttempts
+	}
+	if req.MaxLen != nil && *req.MaxLen < 0 {
+		return queue.ErrNegativeMaxLen
+	}
+	if req.Overflow != nil {
+		switch queue.Overflow(*req.Overflow) {
+		case queue.OverflowReject, queue.OverflowBlock, queue.OverflowDropOldest:
+		default:
+			return queue.ErrUnknownOverflow
+		}
+	}
+	if req.BlockTimeout != nil && *req.BlockTimeout < 0 {
+		return queue.ErrNegativeTimeout
+	}
+
+	return nil
+}
+
+func validateQDLGetRequest(req *model.QDLGetRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if req.ID == "" {
+		return errors.New("message ID must not be empty")
+	}
+
+	return nil
+}
+
+func validateQPopNRequest(req *model.QPopNRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if req.Count <= 0 {
+		return errors.New("count must be positive")
+	}
+
+	return nil
+}
+
+func validateQPromoteRequest(req *model.QPromoteRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if req.Count < 0 {
+		return errors.New("count must not be negative")
+	}
+
+	return nil
+}
+
+func validateQMoveRequest(req *model.QMoveRequest) error {
+	if req.Source == "" || req.Destination == "" {
+		return errors.New("source and destination must not be empty")
+	}
+	if req.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if req.Timeout > 0 && !req.Block {
+		return errors.New("timeout can only be set for a blocking move")
+	}
+
+	return nil
+}
+
+func validateKeys(keys []string) error {
+	if len(keys) == 0 {
+		return errors.New("at least one key must be given")
+	}
+	for _, key := range keys {
+		if key == "" {
+			return errors.New("key must not be empty")
+		}
+	}
+
+	return nil
+}
+
+func validateIncrRequest(req *model.IncrRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+
+	return nil
+}
+
+func validateIncrByFloatRequest(req *model.IncrByFloatRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if math.IsNaN(req.Delta) || math.IsInf(req.Delta, 0) {
+		return kvstore.ErrNotFloat
+	}
+
+	return nil
+}
+
+func validateTTLRequest(req *model.TTLRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+
+	return nil
+}
+
+func validateExpireRequest(req *model.ExpireRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+
+	if (req.TTL == 0) == req.ExpiresAt.IsZero() {
+		return errors.New("exactly one of TTL and ExpiresAt must be set")
+	}
+
+	switch req.Condition {
+	case "", "NX", "XX", "GT", "LT":
+	default:
+		return errors.New("condition must be NX, XX, GT, LT or empty")
+	}
+
+	return nil
+}
+
+func validatePersistRequest(req *model.PersistRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+
+	return nil
+}
+
+func validateGetRequest(req *model.GetRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty to get value")
+	}
+
+	return nil
+}
+
+func validateQPushRequest(req *model.QPushRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+	if req.Values == nil {
+		return errors.New("you must set values to be pushed")
+	}
+	if req.Delay < 0 {
+		return errors.New("delay must not be negative")
+	}
+	if req.Delay > 0 && !req.NotBefore.IsZero() {
+		return errors.New("delay and not before cannot both be set")
+	}
+	if req.Priority != nil && (req.Delay > 0 || !req.NotBefore.IsZero()) {
+		return errors.New("priority cannot be set with delay or not before")
+	}
+
+	return nil
+}
+
+func validateQPopRequest(req *model.QPopRequest) error {
+	if req.Key == "" {
+		return errors.New("key must not be empty")
+	}
+
+	return nil
+}
+
+func validateBQPopRequest(req *model.BQPopRequest) error {
+	if err := validateKeys(req.Keys); err != nil {
+		return err
+	}
+	if req.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+
+	return nil
+}
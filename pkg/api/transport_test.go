@@ -1,5 +1,19 @@
 package kvstore
 
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sprectza/go-kvstore/internal/kvstore"
+	"github.com/sprectza/go-kvstore/internal/queue"
+	"github.com/sprectza/go-kvstore/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
 /* func TestIntegration(t *testing.T) {
 	kvs := kvstore.NewKVStore()
 	qs := queue.NewQueue()
@@ -77,3 +91,84 @@ package kvstore
 	assert.NoError(t, err)
 	assert.Equal(t, "item2", bqPopResp.Value)
 } */
+
+func TestHTTPErrorResponses(t *testing.T) {
+	server := httptest.NewServer(MakeHTTPHandler(MakeEndpoints(NewService(kvstore.NewShardedKVStore(0), queue.NewQueue()))))
+	defer server.Close()
+
+	tests := []struct {
+		path, body string
+		status     int
+		code       string
+		details    map[string]interface{}
+	}{
+		{"/api/commands/set", `{"Key": "k", "Value": "v"}`, http.StatusOK, "", nil},
+		{"/api/commands/get", `{"Key": "k"}`, http.StatusOK, "", nil},
+		{"/api/commands/get", `{"Key": "missing"}`, http.StatusNotFound, model.CodeKeyNotFound, nil},
+		{"/api/commands/qpop", `{"Key": "q"}`, http.StatusNotFound, model.CodeQueueEmpty, nil},
+		{"/api/commands/qconfig", `{"Key": "q", "MaxAttempts": 3}`, http.StatusBadRequest, model.CodeInvalidRequest, nil},
+		{"/api/commands/qdlget", `{"Key": "dead", "ID": "q/1-0"}`, http.StatusNotFound, model.CodeMessageNotFound, nil},
+		{"/api/commands/qpush", `{"Key": "pq", "Values": ["a"], "Priority": 1, "Delay": 1000}`, http.StatusBadRequest, model.CodeInvalidRequest, nil},
+		{"/api/commands/qpush", `{"Key": "pq", "Values": ["a"], "Priority": 1}`, http.StatusOK, "", nil},
+		{"/api/commands/rpop", `{"Key": "pq"}`, http.StatusConflict, model.CodeWrongType, nil},
+		{"/api/commands/qpeek", `{"Key": "q"}`, http.StatusNotFound, model.CodeQueueEmpty, nil},
+		{"/api/commands/qstats", `{"Key": ""}`, http.StatusBadRequest, model.CodeInvalidRequest, nil},
+		{"/api/commands/qpopn", `{"Key": "q", "Count": 0}`, http.StatusBadRequest, model.CodeInvalidRequest, nil},
+		{"/api/commands/qmove", `{"Source": "q", "Destination": "d", "Timeout": 1000}`, http.StatusBadRequest, model.CodeInvalidRequest, nil},
+		{"/api/commands/bqmove", `{"Source": "q", "Destination": "d", "Timeout": 1000000}`, http.StatusNotFound, model.CodeQueueEmpty, nil},
+		{"/api/commands/qconfig", `{"Key": "full", "Overflow": "newest"}`, http.StatusBadRequest, model.CodeInvalidRequest, nil},
+		{"/api/commands/qconfig", `{"Key": "full", "MaxLen": 1}`, http.StatusOK, "", nil},
+		{"/api/commands/rpush", `{"Key": "full", "Values": ["a"]}`, http.StatusOK, "", nil},
+		{"/api/commands/qpush", `{"Key": "full", "Values": ["b"]}`, http.StatusTooManyRequests, model.CodeQueueFull, nil},
+		{"/api/commands/set", `{"Key": ""}`, http.StatusBadRequest, model.CodeInvalidRequest, nil},
+		{"/api/commands/set", `{"Key":`, http.StatusBadRequest, model.CodeInvalidRequest, nil},
+		{"/api/commands/set", `{"Key": "unsynced", "Value": "v", "Ack": "persisted"}`, http.StatusConflict, model.CodeNotEnabled, nil},
+		{"/api/commands/get", `{"Key": "unsynced"}`, http.StatusNotFound, model.CodeKeyNotFound, nil},
+		{"/api/admin/save", `{}`, http.StatusConflict, model.CodeNotEnabled, nil},
+		{"/api/commands", `{"Command": "GET"}`, http.StatusBadRequest, model.CodeWrongArity, map[string]interface{}{"command": "GET"}},
+		{"/api/commands", `{"Command": "GET missing"}`, http.StatusNotFound, model.CodeKeyNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		resp, err := http.Post(server.URL+tt.path, "application/json", strings.NewReader(tt.body))
+		require.NoError(t, err)
+
+		var body struct {
+			Error *model.ErrorBody `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		resp.Body.Close()
+
+		assert.Equal(t, tt.status, resp.StatusCode, "%s %s", tt.path, tt.body)
+		if tt.code == "" {
+			assert.Nil(t, body.Error, "%s %s", tt.path, tt.body)
+			continue
+		}
+		if assert.NotNil(t, body.Error, "%s %s", tt.path, tt.body) {
+			assert.Equal(t, tt.code, body.Error.Code, "%s %s", tt.path, tt.body)
+			assert.NotEmpty(t, body.Error.Message)
+			assert.Equal(t, tt.details, body.Error.Details)
+		}
+	}
+}
+
+func TestHTTPOverloaded(t *testing.T) {
+	// No push workers and no buffer, so a push never finds room
+	s := &service{store: kvstore.NewShardedKVStore(0), qs: queue.NewQueue(), bufferedQPushChan: make(chan *QPushRequest)}
+	server := httptest.NewServer(MakeHTTPHandler(MakeEndpoints(s)))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/commands/qpush", "application/json", strings.NewReader(`{"Key": "q", "Values": ["a"]}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body struct {
+		Error *model.ErrorBody `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	if assert.NotNil(t, body.Error) {
+		assert.Equal(t, model.CodeOverloaded, body.Error.Code)
+	}
+}
@@ -11,6 +11,20 @@ var (
 	ErrInvalidValue      = errors.New("invalid value")
 	ErrInvalidExpiryTime = errors.New("invalid expiry time")
 	ErrInvalidCondition  = errors.New("invalid condition")
+	ErrSnapshotsDisabled = errors.New("snapshots are not enabled")
+	ErrSaveInProgress    = errors.New("a snapshot is already being saved")
+	ErrAOFDisabled       = errors.New("append-only file is not enabled")
+	ErrOverloaded        = errors.New("server is overloaded, try again later")
+)
+
+// Acknowledgement levels of SET, from the fastest to the safest
+const (
+	// Reply before the value is stored, failures are only logged
+	AckNone = "none"
+	// Reply once the value is stored in memory, the default
+	AckApplied = "applied"
+	// Reply once the value is stored and the append-only file synced
+	AckPersisted = "persisted"
 )
 
 // Request for SET
@@ -19,11 +33,17 @@ type SetRequest struct {
 	Value     interface{}
 	ExpiresAt time.Time
 	Condition string
+	// Keep the expiry of the existing key, ExpiresAt must be zero
+	KeepTTL bool
+	// When to reply: AckNone, AckApplied (if empty) or AckPersisted
+	Ack string
 }
 
-// Response for SET
+// Response for SET. Applied is false when the condition did not hold, and
+// always with AckNone.
 type SetResponse struct {
-	Err error
+	Applied bool
+	Err     error `json:"-"`
 }
 
 // Request for GET
@@ -34,17 +54,415 @@ type GetRequest struct {
 // Response for GET
 type GetResponse struct {
 	Value interface{}
-	Err   error
+	Err   error `json:"-"`
 }
 
-// Request for PUSH in the queue
-type QPushRequest struct {
+// Request for INCR, DECR, INCRBY and DECRBY
+type IncrRequest struct {
+	Key   string
+	Delta int64
+	// Expiry of the key if the increment creates it, zero for none
+	ExpiresAt time.Time
+}
+
+// Response for INCR, DECR, INCRBY and DECRBY
+type IncrResponse struct {
+	Value int64
+	Err   error `json:"-"`
+}
+
+// Request for INCRBYFLOAT
+type IncrByFloatRequest struct {
+	Key   string
+	Delta float64
+	// Expiry of the key if the increment creates it, zero for none
+	ExpiresAt time.Time
+}
+
+// Response for INCRBYFLOAT
+type IncrByFloatResponse struct {
+	Value float64
+	Err   error `json:"-"`
+}
+
+// Request for TTL and PTTL
+type TTLRequest struct {
+	Key string
+	// Report the TTL in milliseconds instead of seconds
+	Milliseconds bool
+}
+
+// Response for TTL and PTTL. TTL is -1 for keys that never expire.
+type TTLResponse struct {
+	TTL int64
+	Err error `json:"-"`
+}
+
+// Request for EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. The expiry is either
+// relative, with TTL, or absolute, with ExpiresAt.
+type ExpireRequest struct {
+	Key       string
+	TTL       time.Duration
+	ExpiresAt time.Time
+	// NX, XX, GT, LT or empty
+	Condition string
+}
+
+// Response for EXPIRE and friends, Applied is false when the key does not
+// exist or the condition was not met
+type ExpireResponse struct {
+	Applied bool
+	Err     error `json:"-"`
+}
+
+// Request for PERSIST
+type PersistRequest struct {
+	Key string
+}
+
+// Response for PERSIST, Applied is false when the key had no expiry
+type PersistResponse struct {
+	Applied bool
+	Err     error `json:"-"`
+}
+
+// Request for HSET
+type HSetRequest struct {
 	Key    string
-	Values []interface{}
+	Fields map[string]string
+}
+
+// Response for HSET, with the number of fields added rather than updated
+type HSetResponse struct {
+	Added int
+	Err   error `json:"-"`
+}
+
+// Request for HGET
+type HGetRequest struct {
+	Key   string
+	Field string
+}
+
+// Response for HGET
+type HGetResponse struct {
+	Value string
+	Err   error `json:"-"`
+}
+
+// Request for HDEL
+type HDelRequest struct {
+	Key    string
+	Fields []string
+}
+
+// Response for HDEL
+type HDelResponse struct {
+	Deleted int
+	Err     error `json:"-"`
+}
+
+// Request for HGETALL
+type HGetAllRequest struct {
+	Key string
+}
+
+// Response for HGETALL
+type HGetAllResponse struct {
+	Fields map[string]string
+	Err    error `json:"-"`
+}
+
+// Request for HINCRBY
+type HIncrByRequest struct {
+	Key   string
+	Field string
+	Delta int64
+}
+
+// Response for HINCRBY
+type HIncrByResponse struct {
+	Value int64
+	Err   error `json:"-"`
+}
+
+// Request for HLEN
+type HLenRequest struct {
+	Key string
+}
+
+// Response for HLEN
+type HLenResponse struct {
+	Len int
+	Err error `json:"-"`
+}
+
+// Request for SADD
+type SAddRequest struct {
+	Key     string
+	Members []string
+}
+
+// Response for SADD, with the number of members added
+type SAddResponse struct {
+	Added int
+	Err   error `json:"-"`
+}
+
+// Request for SREM
+type SRemRequest struct {
+	Key     string
+	Members []string
+}
+
+// Response for SREM
+type SRemResponse struct {
+	Removed int
+	Err     error `json:"-"`
+}
+
+// Request for SMEMBERS
+type SMembersRequest struct {
+	Key string
+}
+
+// Response for SMEMBERS, with the members in sorted order
+type SMembersResponse struct {
+	Members []string
+	Err     error `json:"-"`
+}
+
+// Request for SISMEMBER
+type SIsMemberRequest struct {
+	Key    string
+	Member string
+}
+
+// Response for SISMEMBER
+type SIsMemberResponse struct {
+	IsMember bool
+	Err      error `json:"-"`
+}
+
+// Request for SCARD
+type SCardRequest struct {
+	Key string
+}
+
+// Response for SCARD
+type SCardResponse struct {
+	Len int
+	Err error `json:"-"`
+}
+
+// Request for SINTER, SUNION and SDIFF. Op is one of the set operations of
+// the kvstore package; over HTTP it is implied by the route.
+type SetOperationRequest struct {
+	Op   string `json:"-"`
+	Keys []string
+}
+
+// Response for SINTER, SUNION and SDIFF, with the members in sorted order
+type SetOperationResponse struct {
+	Members []string
+	Err     error `json:"-"`
+}
+
+// Request for SINTERSTORE, SUNIONSTORE and SDIFFSTORE
+type SetOperationStoreRequest struct {
+	Op          string `json:"-"`
+	Destination string
+	Keys        []string
+}
+
+// Response for SINTERSTORE, SUNIONSTORE and SDIFFSTORE, with the number of
+// members stored
+type SetOperationStoreResponse struct {
+	Count int
+	Err   error `json:"-"`
+}
+
+// Request for ZADD. Condition is NX, XX or empty and Comparison GT, LT or
+// empty.
+type ZAddRequest struct {
+	Key        string
+	Members    map[string]float64
+	Condition  string
+	Comparison string
+}
+
+// Response for ZADD, with the number of members added
+type ZAddResponse struct {
+	Added int
+	Err   error `json:"-"`
+}
+
+// Request for ZINCRBY
+type ZIncrByRequest struct {
+	Key    string
+	Delta  float64
+	Member string
+}
+
+// Response for ZINCRBY, with the new score
+type ZIncrByResponse struct {
+	Score float64
+	Err   error `json:"-"`
+}
+
+// Request for ZRANK, and ZREVRANK with Rev
+type ZRankRequest struct {
+	Key    string
+	Member string
+	Rev    bool
+}
+
+// Response for ZRANK and ZREVRANK
+type ZRankResponse struct {
+	Rank int
+	Err  error `json:"-"`
+}
+
+// Request for ZSCORE
+type ZScoreRequest struct {
+	Key    string
+	Member string
+}
+
+// Response for ZSCORE
+type ZScoreResponse struct {
+	Score float64
+	Err   error `json:"-"`
+}
+
+// Request for ZCARD
+type ZCardRequest struct {
+	Key string
+}
+
+// Response for ZCARD
+type ZCardResponse struct {
+	Len int
+	Err error `json:"-"`
+}
+
+// Request for ZREM
+type ZRemRequest struct {
+	Key     string
+	Members []string
+}
+
+// Response for ZREM
+type ZRemResponse struct {
+	Removed int
+	Err     error `json:"-"`
+}
+
+// Request for ZREMRANGEBYSCORE, Min and Max being scores as in ZRANGE
+type ZRemRangeByScoreRequest struct {
+	Key string
+	Min string
+	Max string
+}
+
+// Response for ZREMRANGEBYSCORE
+type ZRemRangeByScoreResponse struct {
+	Removed int
+	Err     error `json:"-"`
+}
+
+// Request for ZRANGE. Start and Stop are ranks, or with By set to SCORE or
+// LEX scores such as "(1.5" and "+inf" or members such as "[a" and "-", as
+// in the command.
+type ZRangeRequest struct {
+	Key        string
+	Start      string
+	Stop       string
+	By         string
+	Rev        bool
+	Limit      *ZLimit
+	WithScores bool
+}
+
+// ZLimit is the LIMIT option of ZRANGE, a negative Count meaning no limit
+type ZLimit struct {
+	Offset int
+	Count  int
+}
+
+// ZMember is a member of a sorted set along with its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// Response for ZRANGE. Scores are always included, WithScores only applies
+// to the Redis protocol.
+type ZRangeResponse struct {
+	Members    []ZMember
+	WithScores bool  `json:"-"`
+	Err        error `json:"-"`
+}
+
+// Request for DEL
+type DelRequest struct {
+	Keys []string
+}
+
+// Response for DEL, with the number of keys that existed
+type DelResponse struct {
+	Deleted int
+}
+
+// Request for EXISTS
+type ExistsRequest struct {
+	Keys []string
+}
+
+// Response for EXISTS, with the number of keys that exist
+type ExistsResponse struct {
+	Count int
+}
+
+// Request for KEYS, Pattern is a glob pattern such as "user:*"
+type KeysRequest struct {
+	Pattern string
+}
+
+// Response for KEYS
+type KeysResponse struct {
+	Keys []string
+}
+
+// Request for SCAN. Cursor is 0 to start a new scan, Match an optional
+// glob pattern and Count about how many keys to look at.
+type ScanRequest struct {
+	Cursor uint64
+	Match  string
+	Count  int
+}
+
+// Response for SCAN, Cursor is 0 once the scan is complete
+type ScanResponse struct {
+	Cursor uint64
+	Keys   []string
+}
+
+// Request for PUSH in the queue. The values are only pushed after Delay,
+// or at NotBefore, if set. With a Priority they are pushed right away to a
+// priority queue.
+type QPushRequest struct {
+	Key       string
+	Values    []interface{}
+	Delay     time.Duration
+	NotBefore time.Time
+	Priority  *int64
 }
 
 // Response for PUSH in the queue
-type QPushResponse struct{}
+type QPushResponse struct {
+	Err error `json:"-"`
+}
 
 // Request for POP from the queue
 type QPopRequest struct {
@@ -54,17 +472,406 @@ type QPopRequest struct {
 // Response for POP from the queue
 type QPopResponse struct {
 	Value interface{}
-	Err   error
+	Err   error `json:"-"`
 }
 
-// Request for BQPOP from the queue
+// Request for BQPOP from the first queue of Keys that is not empty
 type BQPopRequest struct {
-	Key     string
+	Keys    []string
 	Timeout time.Duration
 }
 
-// Response from BQPOP from the queue
+// Response from BQPOP from the queue under Key
 type BQPopResponse struct {
+	Key   string
+	Value interface{}
+	Err   error `json:"-"`
+}
+
+// Request for LPUSH, or RPUSH without Left
+type ListPushRequest struct {
+	Left   bool `json:"-"`
+	Key    string
+	Values []interface{}
+}
+
+// Response for LPUSH and RPUSH, with the new length of the list
+type ListPushResponse struct {
+	Len int
+	Err error `json:"-"`
+}
+
+// Request for LPOP, or RPOP without Left
+type ListPopRequest struct {
+	Left bool `json:"-"`
+	Key  string
+}
+
+// Response for LPOP and RPOP
+type ListPopResponse struct {
+	Value interface{}
+	Err   error `json:"-"`
+}
+
+// Request for LLEN
+type LLenRequest struct {
+	Key string
+}
+
+// Response for LLEN
+type LLenResponse struct {
+	Len int
+}
+
+// Request for LINDEX, a negative Index counting from the tail
+type LIndexRequest struct {
+	Key   string
+	Index int
+}
+
+// Response for LINDEX
+type LIndexResponse struct {
 	Value interface{}
-	Err   error
+	Err   error `json:"-"`
+}
+
+// Request for LRANGE, Start and Stop being included and negative ones
+// counting from the tail
+type LRangeRequest struct {
+	Key   string
+	Start int
+	Stop  int
+}
+
+// Response for LRANGE
+type LRangeResponse struct {
+	Values []interface{}
+}
+
+// Request for LTRIM, Start and Stop being as for LRANGE
+type LTrimRequest struct {
+	Key   string
+	Start int
+	Stop  int
+}
+
+// Response for LTRIM
+type LTrimResponse struct {
+	Err error `json:"-"`
+}
+
+// Request for LINSERT, inserting Value after Pivot unless Before is set
+type LInsertRequest struct {
+	Key    string
+	Before bool
+	Pivot  interface{}
+	Value  interface{}
+}
+
+// Response for LINSERT, with the new length of the list, -1 if the pivot
+// was not found and 0 if the list is empty
+type LInsertResponse struct {
+	Len int
+	Err error `json:"-"`
+}
+
+// Request for QRESERVE from the queue, leasing the message for Visibility.
+// The lease ends at Deadline if it is set, as when replaying the
+// append-only file.
+type QReserveRequest struct {
+	Key        string
+	Visibility time.Duration
+	Deadline   time.Time `json:"-"`
+}
+
+// Response for QRESERVE with the reserved message
+type QReserveResponse struct {
+	ID    string
+	Value interface{}
+	// Number of times the message has been delivered, this one included
+	Attempts int
+	Deadline time.Time
+	Err      error `json:"-"`
+}
+
+// Request for QACK, or QNACK if Nack is set. Reason tells why nacked
+// messages failed, and At when if it is set, as when replaying the
+// append-only file.
+type QAckRequest struct {
+	Nack   bool `json:"-"`
+	Key    string
+	IDs    []string
+	Reason string
+	At     time.Time `json:"-"`
+}
+
+// Response for QACK and QNACK, with the number of messages acked or
+// released
+type QAckResponse struct {
+	Count int
+	Err   error `json:"-"`
 }
+
+// Request for QCONFIG, changing the settings of the queue that are set
+type QConfigRequest struct {
+	Key          string
+	MaxAttempts  *int
+	DeadLetter   *string
+	MaxLen       *int
+	Overflow     *string
+	BlockTimeout *time.Duration
+}
+
+// Response for QCONFIG with the settings of the queue
+type QConfigResponse struct {
+	MaxAttempts  int
+	DeadLetter   string
+	MaxLen       int
+	Overflow     string
+	BlockTimeout time.Duration
+	Err          error `json:"-"`
+}
+
+// A message moved to a dead-letter queue. ID is made of the queue and the ID
+// of the message, as in jobs/1700000000000-0.
+type DeadLetter struct {
+	ID         string
+	Value      interface{}
+	Queue      string
+	Attempts   int
+	Reason     string
+	EnqueuedAt time.Time
+	DeadAt     time.Time
+}
+
+// Request for QDLLIST
+type QDLListRequest struct {
+	Key   string
+	Start int
+	Stop  int
+}
+
+// Response for QDLLIST
+type QDLListResponse struct {
+	Messages []DeadLetter
+}
+
+// Request for QDLGET
+type QDLGetRequest struct {
+	Key string
+	ID  string
+}
+
+// Response for QDLGET
+type QDLGetResponse struct {
+	Message DeadLetter
+	Err     error `json:"-"`
+}
+
+// Request for QDLREQUEUE, or QDLPURGE if Purge is set, of the messages
+// with the given IDs or of every message if there are none
+type QDLRequeueRequest struct {
+	Purge bool `json:"-"`
+	Key   string
+	IDs   []string
+}
+
+// Response for QDLREQUEUE and QDLPURGE, with the number of messages
+// requeued or deleted
+type QDLRequeueResponse struct {
+	Count int
+	Err   error `json:"-"`
+}
+
+// Request for QPEEK
+type QPeekRequest struct {
+	Key string
+}
+
+// Response for QPEEK, with the value at the head of the queue
+type QPeekResponse struct {
+	Value interface{}
+	Err   error `json:"-"`
+}
+
+// Request for QSTATS
+type QStatsRequest struct {
+	Key string
+}
+
+// Response for QSTATS. Enqueued and Dequeued count the values pushed and
+// popped since the server started, OldestAge is how long the value pushed
+// longest ago has been in the queue and Waiters the number of blocked pops.
+type QStatsResponse struct {
+	Len       int
+	Enqueued  uint64
+	Dequeued  uint64
+	OldestAge time.Duration
+	Waiters   int
+	Rejected  uint64
+	Dropped   uint64
+}
+
+// Request for QPOPN
+type QPopNRequest struct {
+	Key   string
+	Count int
+}
+
+// Response for QPOPN, with up to Count values and none if the queue is
+// empty
+type QPopNResponse struct {
+	Values []interface{}
+	Err    error `json:"-"`
+}
+
+// Request for QPROMOTE, pushing the first Count of the values scheduled
+// for Key, or all of them if it is 0
+type QPromoteRequest struct {
+	Key   string
+	Count int
+}
+
+// Response for QPROMOTE, with how many values were pushed
+type QPromoteResponse struct {
+	Count int
+	Err   error `json:"-"`
+}
+
+// Request for QMOVE, or BQMOVE if Block is set, waiting up to Timeout for a
+// value. The value is popped from the head of Source and pushed to the tail
+// of Destination, unless FromTail or ToHead are set.
+type QMoveRequest struct {
+	Block       bool `json:"-"`
+	Source      string
+	Destination string
+	FromTail    bool
+	ToHead      bool
+	Timeout     time.Duration
+}
+
+// Response for QMOVE and BQMOVE, with the value moved
+type QMoveResponse struct {
+	Value interface{}
+	Err   error `json:"-"`
+}
+
+// Request for a text command such as "SET key value EX 10"
+type CommandRequest struct {
+	Command string
+}
+
+// Error returned when a text command cannot be parsed
+type CommandError struct {
+	Code    string
+	Command string
+	Message string
+}
+
+// Codes reported in CommandError and ErrorResponse
+const (
+	CodeUnknownCommand  = "UNKNOWN_COMMAND"
+	CodeWrongArity      = "WRONG_ARITY"
+	CodeSyntax          = "SYNTAX_ERROR"
+	CodeInvalidRequest  = "INVALID_REQUEST"
+	CodeKeyNotFound     = "KEY_NOT_FOUND"
+	CodeQueueEmpty      = "QUEUE_EMPTY"
+	CodeFieldNotFound   = "FIELD_NOT_FOUND"
+	CodeMemberNotFound  = "MEMBER_NOT_FOUND"
+	CodeIndexOutOfRange = "INDEX_OUT_OF_RANGE"
+	CodeMessageNotFound = "MESSAGE_NOT_FOUND"
+	CodeWrongType       = "WRONG_TYPE"
+	CodeNotANumber      = "NOT_A_NUMBER"
+	CodeOverflow        = "OVERFLOW"
+	CodeNotEnabled      = "NOT_ENABLED"
+	CodeSaveInProgress  = "SAVE_IN_PROGRESS"
+	CodeOverloaded      = "OVERLOADED"
+	CodeQueueFull       = "QUEUE_FULL"
+	CodeInternal        = "INTERNAL_ERROR"
+)
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+// Response for any HTTP request that failed
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// Error reported in ErrorResponse. Details depend on the code, e.g. the
+// command for command errors.
+type ErrorBody struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Request for SAVE and BGSAVE
+type SaveRequest struct {
+	Background bool
+}
+
+// Response for SAVE and BGSAVE
+type SaveResponse struct {
+	Err error `json:"-"`
+}
+
+// Request for LASTSAVE
+type LastSaveRequest struct{}
+
+// Response for LASTSAVE
+type LastSaveResponse struct {
+	Time time.Time
+}
+
+// Responses carry the error of the call in Err, which is reported through
+// Failed rather than in the encoded response. Over HTTP it becomes an
+// ErrorResponse.
+
+func (r SetResponse) Failed() error               { return r.Err }
+func (r GetResponse) Failed() error               { return r.Err }
+func (r IncrResponse) Failed() error              { return r.Err }
+func (r IncrByFloatResponse) Failed() error       { return r.Err }
+func (r HSetResponse) Failed() error              { return r.Err }
+func (r HGetResponse) Failed() error              { return r.Err }
+func (r HDelResponse) Failed() error              { return r.Err }
+func (r HGetAllResponse) Failed() error           { return r.Err }
+func (r HIncrByResponse) Failed() error           { return r.Err }
+func (r HLenResponse) Failed() error              { return r.Err }
+func (r SAddResponse) Failed() error              { return r.Err }
+func (r SRemResponse) Failed() error              { return r.Err }
+func (r SMembersResponse) Failed() error          { return r.Err }
+func (r SIsMemberResponse) Failed() error         { return r.Err }
+func (r SCardResponse) Failed() error             { return r.Err }
+func (r SetOperationResponse) Failed() error      { return r.Err }
+func (r SetOperationStoreResponse) Failed() error { return r.Err }
+func (r ZAddResponse) Failed() error              { return r.Err }
+func (r ZIncrByResponse) Failed() error           { return r.Err }
+func (r ZRankResponse) Failed() error             { return r.Err }
+func (r ZScoreResponse) Failed() error            { return r.Err }
+func (r ZCardResponse) Failed() error             { return r.Err }
+func (r ZRemResponse) Failed() error              { return r.Err }
+func (r ZRemRangeByScoreResponse) Failed() error  { return r.Err }
+func (r ZRangeResponse) Failed() error            { return r.Err }
+func (r TTLResponse) Failed() error               { return r.Err }
+func (r ExpireResponse) Failed() error            { return r.Err }
+func (r PersistResponse) Failed() error           { return r.Err }
+func (r QPushResponse) Failed() error             { return r.Err }
+func (r QPopResponse) Failed() error              { return r.Err }
+func (r BQPopResponse) Failed() error             { return r.Err }
+func (r ListPushResponse) Failed() error          { return r.Err }
+func (r ListPopResponse) Failed() error           { return r.Err }
+func (r LIndexResponse) Failed() error            { return r.Err }
+func (r LTrimResponse) Failed() error             { return r.Err }
+func (r LInsertResponse) Failed() error           { return r.Err }
+func (r QReserveResponse) Failed() error          { return r.Err }
+func (r QAckResponse) Failed() error              { return r.Err }
+func (r QConfigResponse) Failed() error           { return r.Err }
+func (r QDLGetResponse) Failed() error            { return r.Err }
+func (r QDLRequeueResponse) Failed() error        { return r.Err }
+func (r QPeekResponse) Failed() error             { return r.Err }
+func (r QPopNResponse) Failed() error             { return r.Err }
+func (r QPromoteResponse) Failed() error          { return r.Err }
+func (r QMoveResponse) Failed() error             { return r.Err }
+func (r SaveResponse) Failed() error              { return r.Err }